@@ -0,0 +1,449 @@
+// Package analyze computes static upper bounds of the gas used by the
+// functions of a metered module, from the charges inserted by metering.
+package analyze
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+const (
+	defaultModuleStr = "metering"
+	defaultFieldStr  = "usegas"
+)
+
+// Options locate the metering import of the module.
+type Options struct {
+	ModuleStr string // the import string for metering function.
+	FieldStr  string // the field string for the metering function.
+}
+
+// Bound is the worst-case gas of a call to a function.
+type Bound struct {
+	Func      uint32 // index of the function in the function index space.
+	Gas       uint64 // upper bound of the gas used, only meaningful if Bounded.
+	Loop      bool   // the function or a function it may call has a loop, not always set along with Recursion.
+	Recursion bool   // the function may call a function that calls itself.
+}
+
+// Bounded reports whether Gas is an upper bound.
+func (b Bound) Bounded() bool {
+	return !b.Loop && !b.Recursion
+}
+
+// analyzer holds the call graph of the module being analyzed.
+type analyzer struct {
+	module   *tool.Module
+	imported int
+	meter    int // index of the metering import, -1 if there is none.
+	bodies   []tool.CodeBody
+	callees  [][]uint32 // functions each function may call.
+	loops    []bool     // the function has a loop.
+	bounds   []*Bound
+	visiting []bool
+}
+
+// Exports returns the bound of every exported function by export name.
+func Exports(module *tool.Module, opts Options) (map[string]Bound, error) {
+	bounds, err := Funcs(module, opts)
+	if err != nil {
+		return nil, err
+	}
+	exports := make(map[string]Bound)
+	if sec := module.ExportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if entry.Kind != "function" {
+				continue
+			}
+			if entry.Index >= uint32(len(bounds)) {
+				return nil, fmt.Errorf("export %q: unknown function %d", entry.FieldStr, entry.Index)
+			}
+			exports[entry.FieldStr] = bounds[entry.Index]
+		}
+	}
+	return exports, nil
+}
+
+// Funcs returns the bound of every function of the function index space.
+// Imported functions other than the metering import are assumed to use no
+// gas.
+func Funcs(module *tool.Module, opts Options) ([]Bound, error) {
+	if opts.ModuleStr == "" {
+		opts.ModuleStr = defaultModuleStr
+	}
+	if opts.FieldStr == "" {
+		opts.FieldStr = defaultFieldStr
+	}
+
+	a := &analyzer{module: module, meter: -1}
+	if sec := module.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if entry.Kind != "function" {
+				continue
+			}
+			if entry.ModuleStr == opts.ModuleStr && entry.FieldStr == opts.FieldStr {
+				a.meter = a.imported
+			}
+			a.imported++
+		}
+	}
+	if sec := module.CodeSec(); sec != nil {
+		if err := sec.Decode(); err != nil {
+			return nil, err
+		}
+		a.bodies = sec.Entries
+	}
+	num := module.NumFuncs()
+	if num != a.imported+len(a.bodies) {
+		return nil, fmt.Errorf("function and code section have inconsistent lengths %d and %d", num-a.imported, len(a.bodies))
+	}
+
+	a.callees = make([][]uint32, num)
+	a.loops = make([]bool, num)
+	for i, body := range a.bodies {
+		index := a.imported + i
+		callees, err := a.calls(body.Code)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", index, err)
+		}
+		a.callees[index] = callees
+		if a.loops[index], err = hasLoop(body.Code); err != nil {
+			return nil, fmt.Errorf("function %d: %w", index, err)
+		}
+	}
+
+	a.bounds = make([]*Bound, num)
+	a.visiting = make([]bool, num)
+	bounds := make([]Bound, num)
+	for i := range bounds {
+		b, err := a.bound(uint32(i))
+		if err != nil {
+			return nil, err
+		}
+		bounds[i] = *b
+	}
+	return bounds, nil
+}
+
+// calls returns the functions `code` may call: the targets of `call` and, for
+// `call_indirect`, every function of the element section with its type.
+func (a *analyzer) calls(code []tool.OP) ([]uint32, error) {
+	var callees []uint32
+	for pc, op := range code {
+		switch op.FullName() {
+		case "call":
+			index, err := indexImmediate(op, pc)
+			if err != nil {
+				return nil, err
+			}
+			if index >= uint32(len(a.callees)) {
+				return nil, fmt.Errorf("unknown function %d", index)
+			}
+			if int(index) != a.meter {
+				callees = append(callees, index)
+			}
+		case "call_indirect":
+			imm, err := callIndirectImmediate(op, pc)
+			if err != nil {
+				return nil, err
+			}
+			targets, err := a.indirectTargets(imm.Index)
+			if err != nil {
+				return nil, err
+			}
+			callees = append(callees, targets...)
+		}
+	}
+	return callees, nil
+}
+
+// indirectTargets returns the functions of the element section of type
+// `typeIndex`.
+func (a *analyzer) indirectTargets(typeIndex uint32) ([]uint32, error) {
+	sec := a.module.TypeSec()
+	if sec == nil || typeIndex >= uint32(len(sec.Entries)) {
+		return nil, fmt.Errorf("unknown type %d", typeIndex)
+	}
+	typ := sec.Entries[typeIndex]
+
+	var targets []uint32
+	if elems := a.module.ElementSec(); elems != nil {
+		for _, entry := range elems.Entries {
+			for _, index := range entry.Elements {
+				elemType, ok := a.module.FuncType(index)
+				if !ok {
+					return nil, fmt.Errorf("unknown function %d in element section", index)
+				}
+				if elemType.Equal(typ) {
+					targets = append(targets, index)
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+// bound computes the bound of a function after those of its callees. A
+// callee reached while it is visited closes a cycle.
+func (a *analyzer) bound(index uint32) (*Bound, error) {
+	if b := a.bounds[index]; b != nil {
+		return b, nil
+	}
+	b := &Bound{Func: index, Loop: a.loops[index]}
+	a.visiting[index] = true
+	for _, callee := range a.callees[index] {
+		if a.visiting[callee] {
+			b.Recursion = true
+			continue
+		}
+		cb, err := a.bound(callee)
+		if err != nil {
+			return nil, err
+		}
+		b.Loop = b.Loop || cb.Loop
+		b.Recursion = b.Recursion || cb.Recursion
+	}
+	a.visiting[index] = false
+
+	if b.Bounded() && int(index) >= a.imported {
+		gas, err := a.funcGas(a.bodies[int(index)-a.imported].Code)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", index, err)
+		}
+		b.Gas = gas
+	}
+	a.bounds[index] = b
+	return b, nil
+}
+
+// path is the worst-case gas of the runs reaching a point of a function, if
+// any does.
+type path struct {
+	gas   uint64
+	reach bool
+}
+
+func (p *path) join(q path) {
+	if q.reach && (!p.reach || q.gas > p.gas) {
+		*p = q
+	}
+}
+
+// frame is a block being walked by funcGas.
+type frame struct {
+	loop   bool
+	before path // the path entering an `if`.
+	then   path // the path leaving the then arm of an `if` with an else.
+	exit   path // the runs branching to the end of the block.
+}
+
+// funcGas returns the worst-case gas of a function without loops. Each
+// instruction runs at most once, so it follows the paths through the code,
+// joining those of branches at their label. The callees of the function must
+// be bounded.
+func (a *analyzer) funcGas(code []tool.OP) (uint64, error) {
+	var (
+		cur    = path{reach: true}
+		result path
+		frames = []frame{{}}
+	)
+	branch := func(depth uint32) {
+		if depth >= uint32(len(frames)) {
+			return
+		}
+		f := &frames[len(frames)-1-int(depth)]
+		if !f.loop {
+			f.exit.join(cur)
+		}
+	}
+	charge := func(gas uint64) {
+		cur.gas = add(cur.gas, gas)
+	}
+	for pc, op := range code {
+		switch op.FullName() {
+		case "block", "loop":
+			frames = append(frames, frame{loop: op.Name() == "loop"})
+		case "if":
+			frames = append(frames, frame{before: cur})
+		case "else":
+			f := &frames[len(frames)-1]
+			f.then, cur = cur, f.before
+			f.before = path{}
+		case "end":
+			f := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			cur.join(f.before)
+			cur.join(f.then)
+			cur.join(f.exit)
+			if len(frames) == 0 {
+				result.join(cur)
+			}
+		case "br", "br_if":
+			depth, err := indexImmediate(op, pc)
+			if err != nil {
+				return 0, err
+			}
+			branch(depth)
+			if op.Name() == "br" {
+				cur = path{}
+			}
+		case "br_table":
+			imm, err := brTableImmediate(op, pc)
+			if err != nil {
+				return 0, err
+			}
+			for _, depth := range imm.Targets {
+				branch(depth)
+			}
+			branch(imm.DefaultTarget)
+			cur = path{}
+		case "return", "unreachable":
+			// a trap ends the run with the gas used so far.
+			result.join(cur)
+			cur = path{}
+		case "call":
+			index, err := indexImmediate(op, pc)
+			if err != nil {
+				return 0, err
+			}
+			if int(index) == a.meter {
+				gas, err := chargeOf(code, pc)
+				if err != nil {
+					return 0, err
+				}
+				charge(gas)
+				break
+			}
+			charge(a.bounds[index].Gas)
+		case "call_indirect":
+			imm, err := callIndirectImmediate(op, pc)
+			if err != nil {
+				return 0, err
+			}
+			targets, err := a.indirectTargets(imm.Index)
+			if err != nil {
+				return 0, err
+			}
+			var max uint64
+			for _, target := range targets {
+				if gas := a.bounds[target].Gas; gas > max {
+					max = gas
+				}
+			}
+			charge(max)
+		}
+	}
+	return result.gas, nil
+}
+
+// chargeOf returns the constant charged by the metering call at `pc`.
+func chargeOf(code []tool.OP, pc int) (uint64, error) {
+	if pc == 0 || code[pc-1].Name() != "const" {
+		return 0, fmt.Errorf("instruction %d: metering call without a constant charge", pc)
+	}
+	op := code[pc-1]
+	switch imm := op.Immediates.(type) {
+	case int32:
+		return uint64(uint32(imm)), nil
+	case int64:
+		return uint64(imm), nil
+	case []byte:
+		var f float64
+		switch len(imm) {
+		case 4:
+			f = float64(math.Float32frombits(binary.LittleEndian.Uint32(imm)))
+		case 8:
+			f = math.Float64frombits(binary.LittleEndian.Uint64(imm))
+		default:
+			return 0, fmt.Errorf("instruction %d: invalid %s immediate", pc-1, op.FullName())
+		}
+		if math.IsNaN(f) || f < 0 {
+			return 0, fmt.Errorf("instruction %d: invalid gas amount %v", pc-1, f)
+		}
+		if f = math.Ceil(f); f >= 1<<64 {
+			return math.MaxUint64, nil
+		}
+		return uint64(f), nil
+	}
+	return 0, fmt.Errorf("instruction %d: invalid %s immediate", pc-1, op.FullName())
+}
+
+// hasLoop reports whether `code` has a loop that a branch jumps back to.
+func hasLoop(code []tool.OP) (bool, error) {
+	// labels holds whether each enclosing label is a loop.
+	labels := []bool{false}
+	target := func(depth uint32) bool {
+		return depth < uint32(len(labels)) && labels[len(labels)-1-int(depth)]
+	}
+	for pc, op := range code {
+		switch op.FullName() {
+		case "block", "if":
+			labels = append(labels, false)
+		case "loop":
+			labels = append(labels, true)
+		case "end":
+			if len(labels) > 0 {
+				labels = labels[:len(labels)-1]
+			}
+		case "br", "br_if":
+			depth, err := indexImmediate(op, pc)
+			if err != nil {
+				return false, err
+			}
+			if target(depth) {
+				return true, nil
+			}
+		case "br_table":
+			imm, err := brTableImmediate(op, pc)
+			if err != nil {
+				return false, err
+			}
+			if target(imm.DefaultTarget) {
+				return true, nil
+			}
+			for _, depth := range imm.Targets {
+				if target(depth) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// indexImmediate returns the function index or label depth of the
+// instruction at `pc`.
+func indexImmediate(op tool.OP, pc int) (uint32, error) {
+	switch imm := op.Immediates.(type) {
+	case uint32:
+		return imm, nil
+	}
+	return 0, fmt.Errorf("instruction %d: invalid %s immediate", pc, op.FullName())
+}
+
+func brTableImmediate(op tool.OP, pc int) (tool.BrTable, error) {
+	switch imm := op.Immediates.(type) {
+	case tool.BrTable:
+		return imm, nil
+	}
+	return tool.BrTable{}, fmt.Errorf("instruction %d: invalid %s immediate", pc, op.FullName())
+}
+
+func callIndirectImmediate(op tool.OP, pc int) (tool.CallIndirect, error) {
+	switch imm := op.Immediates.(type) {
+	case tool.CallIndirect:
+		return imm, nil
+	}
+	return tool.CallIndirect{}, fmt.Errorf("instruction %d: invalid %s immediate", pc, op.FullName())
+}
+
+// add adds gas, saturating at the maximum.
+func add(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
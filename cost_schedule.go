@@ -0,0 +1,175 @@
+package go_wasm_metering
+
+import (
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// CostSchedule is a cost table compiled once for metering, pricing
+// instructions by opcode, locals and types without walking the table on
+// every call. Pass it as Options.CostSchedule to share it between runs.
+type CostSchedule struct {
+	ops    [256]uint64 // costs of the instructions, by opcode.
+	locals *costNode   // CostTable["code"]["locals"].
+	types  *costNode   // CostTable["type"].
+}
+
+// costNode is a table, or a nested table, of the cost table.
+type costNode struct {
+	costs      map[string]uint64    // costs of string values.
+	fields     map[string]*costNode // tables of struct fields, by their JSON name.
+	dflt       uint64
+	hasDefault bool
+}
+
+// CompileCostTable compiles a cost table, see Options.CostTable.
+func CompileCostTable(table tool.JSON) (*CostSchedule, error) {
+	code, err := subTable(table, "code")
+	if err != nil {
+		return nil, fmt.Errorf("invalid cost table: %w", err)
+	}
+	schedule := &CostSchedule{}
+	var ops *costNode
+	for _, c := range []struct {
+		node  **costNode
+		table tool.JSON
+		key   string
+		name  string
+	}{
+		{&ops, code, "code", "code.code"},
+		{&schedule.locals, code, "locals", "code.locals"},
+		{&schedule.types, table, "type", "type"},
+	} {
+		sub, err := subTable(c.table, c.key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cost table: %s: %w", c.name, err)
+		}
+		if *c.node, err = compileCostNode(sub); err != nil {
+			return nil, fmt.Errorf("invalid cost table: %s%w", c.name, err)
+		}
+	}
+	for i := range schedule.ops {
+		op := tool.OP{Opcode: byte(i)}
+		if op.FullName() == "" {
+			schedule.ops[i] = ops.defaultCost(DefaultCost)
+		} else {
+			schedule.ops[i] = ops.stringCost(costName(op.ReturnType(), op.Name()), DefaultCost)
+		}
+	}
+	return schedule, nil
+}
+
+// subTable returns the table at `key`, empty if there is none.
+func subTable(table tool.JSON, key string) (tool.JSON, error) {
+	value, ok := table[key]
+	if !ok {
+		return tool.JSON{}, nil
+	}
+	sub, ok := value.(tool.JSON)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a table", key)
+	}
+	return sub, nil
+}
+
+func compileCostNode(table tool.JSON) (*costNode, error) {
+	node := &costNode{costs: make(map[string]uint64), fields: make(map[string]*costNode)}
+	for key, value := range table {
+		if sub, ok := value.(tool.JSON); ok {
+			field, err := compileCostNode(sub)
+			if err != nil {
+				return nil, fmt.Errorf(".%s%w", key, err)
+			}
+			node.fields[key] = field
+			continue
+		}
+		cost, ok := value.(int)
+		if !ok || cost < 0 {
+			return nil, fmt.Errorf(".%s: invalid cost %v", key, value)
+		}
+		if key == "DEFAULT" {
+			node.dflt, node.hasDefault = uint64(cost), true
+		} else {
+			node.costs[key] = uint64(cost)
+		}
+	}
+	return node, nil
+}
+
+// defaultCost returns the default of the node, or `inherited` from the
+// enclosing table.
+func (n *costNode) defaultCost(inherited uint64) uint64 {
+	if n.hasDefault {
+		return n.dflt
+	}
+	return inherited
+}
+
+func (n *costNode) stringCost(s string, inherited uint64) uint64 {
+	if s == "" {
+		return 0
+	}
+	if cost, ok := n.costs[s]; ok {
+		return cost
+	}
+	return n.defaultCost(inherited)
+}
+
+func (n *costNode) stringsCost(ss []string) (cost uint64) {
+	for _, s := range ss {
+		cost += n.stringCost(s, 0)
+	}
+	return cost
+}
+
+// OpCost returns the cost of an instruction. Opcodes missing from
+// tool.OPCODES cost the DEFAULT of the table.
+func (s *CostSchedule) OpCost(op tool.OP) uint64 {
+	return s.ops[op.Opcode]
+}
+
+// OpcodeCost returns the cost of the instruction with `opcode`.
+func (s *CostSchedule) OpcodeCost(opcode byte) uint64 {
+	return s.ops[opcode]
+}
+
+// TypeCost returns the cost of the type of a function.
+func (s *CostSchedule) TypeCost(typ tool.TypeEntry) uint64 {
+	n := s.types
+	dflt := n.defaultCost(DefaultCost)
+	cost := dflt
+	if field, ok := n.fields["form"]; ok {
+		cost = field.stringCost(typ.Form, dflt)
+	}
+	for _, c := range []struct {
+		key   string
+		types []string
+	}{{"params", typ.Params}, {"returns", typ.Returns}} {
+		if field, ok := n.fields[c.key]; ok {
+			cost += field.stringsCost(c.types)
+		} else {
+			cost += dflt
+		}
+	}
+	return cost
+}
+
+// LocalsCost returns the cost of the locals of a function body.
+func (s *CostSchedule) LocalsCost(locals []tool.LocalEntry) (cost uint64) {
+	n := s.locals
+	dflt := n.defaultCost(0)
+	for _, local := range locals {
+		if field, ok := n.fields["count"]; ok {
+			cost += field.defaultCost(dflt)
+		} else {
+			cost += dflt
+		}
+		if field, ok := n.fields["type"]; ok {
+			cost += field.stringCost(local.Type, dflt)
+		} else {
+			cost += dflt
+		}
+	}
+	return cost
+}
@@ -0,0 +1,223 @@
+package go_wasm_metering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// FloatPolicy is how metering treats floating point, whose NaN bit patterns
+// differ across hosts.
+type FloatPolicy string
+
+const (
+	FloatsAllowed      FloatPolicy = ""             // floats are left as they are.
+	FloatsRejected     FloatPolicy = "reject"       // modules using floats are rejected.
+	FloatsCanonicalNaN FloatPolicy = "canonicalize" // NaNs produced by instructions are made canonical.
+)
+
+var (
+	// NAN_PRODUCING_OPS are the float operators whose NaN results are not
+	// deterministic. Those only moving bits, like `neg` or `reinterpret`,
+	// are.
+	NAN_PRODUCING_OPS = map[string]bool{
+		"add": true, "sub": true, "mul": true, "div": true, "sqrt": true,
+		"min": true, "max": true, "ceil": true, "floor": true, "trunc": true,
+		"nearest": true, "promote_f32": true, "demote_f64": true,
+	}
+
+	// CANONICAL_NANS are the little-endian canonical NaNs of float types.
+	CANONICAL_NANS = map[string][]byte{
+		"f32": {0x00, 0x00, 0xc0, 0x7f},
+		"f64": {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x7f},
+	}
+)
+
+// FloatViolation is a use of floats in a module with FloatsRejected. Func and
+// Offset locate the use in function bodies like validate.Error does.
+type FloatViolation struct {
+	Section string
+	Func    int
+	Offset  int
+	Msg     string
+}
+
+func (v *FloatViolation) Error() string {
+	switch {
+	case v.Func < 0:
+		return fmt.Sprintf("%s section: %s", v.Section, v.Msg)
+	case v.Offset < 0:
+		return fmt.Sprintf("function %d: %s", v.Func, v.Msg)
+	}
+	return fmt.Sprintf("function %d, instruction %d: %s", v.Func, v.Offset, v.Msg)
+}
+
+// FloatViolations are all the uses of floats in a module.
+type FloatViolations []*FloatViolation
+
+func (e FloatViolations) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("float policy violation: %s", strings.Join(msgs, "; "))
+}
+
+// Name returns the name of the float policy pass.
+func (p FloatPolicy) Name() string {
+	return "float policy"
+}
+
+// Run checks or rewrites a module for the policy.
+func (p FloatPolicy) Run(module *tool.Module) error {
+	return applyFloatPolicy(module, p)
+}
+
+// applyFloatPolicy checks or rewrites a module for a float policy.
+func applyFloatPolicy(module *tool.Module, policy FloatPolicy) error {
+	switch policy {
+	case FloatsAllowed:
+		return nil
+	case FloatsRejected:
+		return checkFloats(module)
+	case FloatsCanonicalNaN:
+		return canonicalizeNaNs(module)
+	}
+	return fmt.Errorf("invalid float policy %q", policy)
+}
+
+func isFloat(typ string) bool {
+	return typ == "f32" || typ == "f64"
+}
+
+// checkFloats returns FloatViolations if the module uses float types or
+// instructions.
+func checkFloats(module *tool.Module) error {
+	if err := module.DecodeCode(); err != nil {
+		return err
+	}
+	var violations FloatViolations
+	report := func(section string, fn, offset int, format string, args ...interface{}) {
+		violations = append(violations, &FloatViolation{Section: section, Func: fn, Offset: offset, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if sec := module.TypeSec(); sec != nil {
+		for i, typ := range sec.Entries {
+			for _, t := range append(append([]string{}, typ.Params...), typ.Returns...) {
+				if isFloat(t) {
+					report("type", -1, -1, "type %d uses %s", i, t)
+					break
+				}
+			}
+		}
+	}
+	if sec := module.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if global, ok := entry.Type.(tool.Global); ok && isFloat(global.ContentType) {
+				report("import", -1, -1, "global %s.%s has type %s", entry.ModuleStr, entry.FieldStr, global.ContentType)
+			}
+		}
+	}
+	if sec := module.GlobalSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			if isFloat(entry.Type.ContentType) {
+				report("global", -1, -1, "global %d has type %s", i, entry.Type.ContentType)
+			}
+		}
+	}
+	if sec := module.CodeSec(); sec != nil {
+		imported := module.ImportedFuncs()
+		for i, body := range sec.Entries {
+			fn := imported + i
+			for _, local := range body.Locals {
+				if isFloat(local.Type) {
+					report("code", fn, -1, "local of type %s", local.Type)
+				}
+			}
+			for offset, op := range body.Code {
+				if usesFloat(op) {
+					report("code", fn, offset, "%s", op.FullName())
+				}
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// usesFloat reports whether an instruction has float operands or results.
+func usesFloat(op tool.OP) bool {
+	if typ, ok := op.Immediates.(string); ok && isFloat(typ) {
+		// the block type of `block`, `loop` and `if`.
+		return true
+	}
+	sig, ok := tool.OP_SIGNATURES[op.FullName()]
+	if !ok {
+		return false
+	}
+	for _, t := range append(append([]string{}, sig.Params...), sig.Results...) {
+		if isFloat(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalizeNaNs replaces the NaN results of the instructions of
+// NAN_PRODUCING_OPS by the canonical NaN, keeping them in a scratch local
+// added to the function:
+//
+//	local.tee $t, fN.const nan, local.get $t, local.get $t, fN.eq, select
+func canonicalizeNaNs(module *tool.Module) error {
+	sec := module.CodeSec()
+	if sec == nil {
+		return nil
+	}
+	if err := sec.Decode(); err != nil {
+		return err
+	}
+	imported := module.ImportedFuncs()
+	for i := range sec.Entries {
+		body := &sec.Entries[i]
+		typ, ok := module.FuncType(uint32(imported + i))
+		if !ok {
+			return fmt.Errorf("invalid type of function %d", imported+i)
+		}
+
+		numLocals := uint32(len(typ.Params))
+		for _, local := range body.Locals {
+			numLocals += local.Count
+		}
+		scratch := make(map[string]uint32)
+
+		code := make([]tool.OP, 0, len(body.Code))
+		for _, op := range body.Code {
+			code = append(code, op)
+			if !isFloat(op.ReturnType()) || !NAN_PRODUCING_OPS[op.Name()] {
+				continue
+			}
+			t := op.ReturnType()
+			local, ok := scratch[t]
+			if !ok {
+				local = numLocals
+				numLocals++
+				scratch[t] = local
+				body.Locals = append(body.Locals, tool.LocalEntry{Count: 1, Type: t})
+			}
+			code = append(code,
+				tool.NewOP("local.tee", local),
+				tool.NewOP(t+".const", append([]byte{}, CANONICAL_NANS[t]...)),
+				tool.NewOP("local.get", local),
+				tool.NewOP("local.get", local),
+				tool.NewOP(t+".eq", nil),
+				tool.NewOP("select", nil),
+			)
+		}
+		body.Code = code
+	}
+	return nil
+}
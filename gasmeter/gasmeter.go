@@ -0,0 +1,89 @@
+// Package gasmeter implements the host side of the metering import: it
+// deducts the gas charged by metered code from a limit.
+package gasmeter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrOutOfGas matches every OutOfGasError with errors.Is.
+var ErrOutOfGas = errors.New("out of gas")
+
+// OutOfGasError is returned when more gas is consumed than remains.
+type OutOfGasError struct {
+	Limit  uint64
+	Used   uint64 // gas used before the failed charge.
+	Amount uint64 // the amount of the failed charge.
+}
+
+func (e *OutOfGasError) Error() string {
+	return fmt.Sprintf("out of gas: %d charged with %d of %d remaining", e.Amount, e.Limit-e.Used, e.Limit)
+}
+
+// Is makes errors.Is(err, ErrOutOfGas) hold.
+func (e *OutOfGasError) Is(target error) bool {
+	return target == ErrOutOfGas
+}
+
+// GasMeter tracks the gas used by an execution against a limit.
+type GasMeter interface {
+	Limit() uint64
+	Used() uint64
+	Remaining() uint64
+
+	// Consume charges `amount`. If it exceeds the remaining gas, all the gas
+	// is used and an *OutOfGasError is returned.
+	Consume(amount uint64) error
+
+	// Refund gives back `amount` of the used gas, down to nothing used.
+	Refund(amount uint64)
+}
+
+// basicGasMeter is a GasMeter, not safe for concurrent use.
+type basicGasMeter struct {
+	limit uint64
+	used  uint64
+}
+
+// NewGasMeter returns a GasMeter with the given limit.
+func NewGasMeter(limit uint64) GasMeter {
+	return &basicGasMeter{limit: limit}
+}
+
+// NewInfiniteGasMeter returns a GasMeter that only runs out of gas when the
+// used gas would overflow.
+func NewInfiniteGasMeter() GasMeter {
+	return &basicGasMeter{limit: math.MaxUint64}
+}
+
+func (m *basicGasMeter) Limit() uint64 {
+	return m.limit
+}
+
+func (m *basicGasMeter) Used() uint64 {
+	return m.used
+}
+
+func (m *basicGasMeter) Remaining() uint64 {
+	return m.limit - m.used
+}
+
+func (m *basicGasMeter) Consume(amount uint64) error {
+	// used never exceeds limit, so the remaining gas does not underflow.
+	if amount > m.limit-m.used {
+		err := &OutOfGasError{Limit: m.limit, Used: m.used, Amount: amount}
+		m.used = m.limit
+		return err
+	}
+	m.used += amount
+	return nil
+}
+
+func (m *basicGasMeter) Refund(amount uint64) {
+	if amount > m.used {
+		amount = m.used
+	}
+	m.used -= amount
+}
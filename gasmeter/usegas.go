@@ -0,0 +1,59 @@
+package gasmeter
+
+import (
+	"fmt"
+	"math"
+)
+
+// The adapters below charge the argument of the metering import, which is of
+// the `MeterType` of the metering options.
+
+// UseGasI32 charges an i32 amount, read as unsigned.
+func UseGasI32(m GasMeter, amount int32) error {
+	return m.Consume(uint64(uint32(amount)))
+}
+
+// UseGasI64 charges an i64 amount, read as unsigned.
+func UseGasI64(m GasMeter, amount int64) error {
+	return m.Consume(uint64(amount))
+}
+
+// UseGasF32 charges an f32 amount, rounded up. Negative and NaN amounts are
+// an error.
+func UseGasF32(m GasMeter, amount float32) error {
+	return useGasFloat(m, float64(amount))
+}
+
+// UseGasF64 charges an f64 amount, rounded up. Negative and NaN amounts are
+// an error.
+func UseGasF64(m GasMeter, amount float64) error {
+	return useGasFloat(m, amount)
+}
+
+func useGasFloat(m GasMeter, amount float64) error {
+	if math.IsNaN(amount) || amount < 0 {
+		return fmt.Errorf("invalid gas amount %v", amount)
+	}
+	amount = math.Ceil(amount)
+	if amount >= 1<<64 {
+		return m.Consume(math.MaxUint64)
+	}
+	return m.Consume(uint64(amount))
+}
+
+// UseGas charges an amount of the given meter type passed as its bits, as
+// runtimes and interpreters pass values: i32 in the low 32 bits and floats as
+// returned by math.Float32bits and math.Float64bits.
+func UseGas(m GasMeter, meterType string, bits uint64) error {
+	switch meterType {
+	case "i32":
+		return UseGasI32(m, int32(uint32(bits)))
+	case "i64":
+		return UseGasI64(m, int64(bits))
+	case "f32":
+		return UseGasF32(m, math.Float32frombits(uint32(bits)))
+	case "f64":
+		return UseGasF64(m, math.Float64frombits(bits))
+	}
+	return fmt.Errorf("invalid meter type %s", meterType)
+}
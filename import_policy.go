@@ -0,0 +1,137 @@
+package go_wasm_metering
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// ImportPolicy restricts the imports of the modules to meter. An import not
+// allowed by any of its rules is a violation.
+type ImportPolicy struct {
+	Allowed []AllowedImport
+}
+
+// AllowedImport allows imports by `module.field` name, which may be a glob
+// pattern of path.Match, and kind. Functions must have the signature of Type
+// unless it is nil.
+type AllowedImport struct {
+	Name string
+	Kind string // `function`, `table`, `memory` or `global`, `function` if empty.
+	Type *tool.TypeEntry
+}
+
+// ImportViolation is an import not allowed by an ImportPolicy.
+type ImportViolation struct {
+	ModuleStr string
+	FieldStr  string
+	Kind      string
+	Msg       string
+}
+
+func (v *ImportViolation) Error() string {
+	return fmt.Sprintf("import %s.%s: %s", v.ModuleStr, v.FieldStr, v.Msg)
+}
+
+// ImportViolations are all the imports of a module not allowed by an
+// ImportPolicy.
+type ImportViolations []*ImportViolation
+
+func (e ImportViolations) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("import policy violation: %s", strings.Join(msgs, "; "))
+}
+
+// Name returns the name of the import policy pass.
+func (p *ImportPolicy) Name() string {
+	return "import policy"
+}
+
+// Run checks a module, see Check.
+func (p *ImportPolicy) Run(module *tool.Module) error {
+	return p.Check(module)
+}
+
+// Check returns ImportViolations if the module has imports the policy does
+// not allow.
+func (p *ImportPolicy) Check(module *tool.Module) error {
+	sec := module.ImportSec()
+	if sec == nil {
+		return nil
+	}
+
+	var violations ImportViolations
+	for _, entry := range sec.Entries {
+		if msg := p.check(module, entry); msg != "" {
+			violations = append(violations, &ImportViolation{
+				ModuleStr: entry.ModuleStr,
+				FieldStr:  entry.FieldStr,
+				Kind:      entry.Kind,
+				Msg:       msg,
+			})
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+// check returns why an import is not allowed, or "" if it is.
+func (p *ImportPolicy) check(module *tool.Module, entry tool.ImportEntry) string {
+	name := fmt.Sprintf("%s.%s", entry.ModuleStr, entry.FieldStr)
+
+	var expected *tool.TypeEntry
+	for _, allowed := range p.Allowed {
+		kind := allowed.Kind
+		if kind == "" {
+			kind = "function"
+		}
+		if kind != entry.Kind {
+			continue
+		}
+		if matched, _ := path.Match(allowed.Name, name); !matched {
+			continue
+		}
+		if entry.Kind != "function" || allowed.Type == nil {
+			return ""
+		}
+
+		typ, ok := importType(module, entry)
+		if !ok {
+			return "unknown type"
+		}
+		if typ.Equal(*allowed.Type) {
+			return ""
+		}
+		if expected == nil {
+			expected = allowed.Type
+		}
+	}
+
+	if expected != nil {
+		typ, _ := importType(module, entry)
+		return fmt.Sprintf("signature %s does not match %s", formatType(typ), formatType(*expected))
+	}
+	return fmt.Sprintf("%s not allowed", entry.Kind)
+}
+
+// importType returns the signature of an imported function.
+func importType(module *tool.Module, entry tool.ImportEntry) (tool.TypeEntry, bool) {
+	index, ok := entry.Type.(uint32)
+	sec := module.TypeSec()
+	if !ok || sec == nil || index >= uint32(len(sec.Entries)) {
+		return tool.TypeEntry{}, false
+	}
+	return sec.Entries[index], true
+}
+
+// formatType formats a function type as `[params] -> [returns]`.
+func formatType(typ tool.TypeEntry) string {
+	return fmt.Sprintf("[%s] -> [%s]", strings.Join(typ.Params, " "), strings.Join(typ.Returns, " "))
+}
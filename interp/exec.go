@@ -0,0 +1,286 @@
+package interp
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// label is an entry of the control stack of a running function.
+type label struct {
+	arity  int // number of values a branch to the label carries.
+	height int // height of the operand stack when the label was entered.
+	cont   int // position to continue at after a branch.
+	loop   bool
+}
+
+// call runs a function with its arguments and returns its results.
+func (inst *Instance) call(fn *function, args []uint64) ([]uint64, error) {
+	if fn.host != nil {
+		results, err := fn.host(inst, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) != len(fn.typ.Returns) {
+			return nil, fmt.Errorf("host function returned %d values, expected %d", len(results), len(fn.typ.Returns))
+		}
+		return results, nil
+	}
+
+	inst.depth++
+	defer func() { inst.depth-- }()
+	if inst.depth > inst.MaxCallDepth {
+		return nil, trap("call stack exhausted")
+	}
+
+	locals := make([]uint64, len(fn.typ.Params)+fn.numLocals)
+	copy(locals, args)
+	return inst.run(fn, locals)
+}
+
+func (inst *Instance) run(fn *function, locals []uint64) ([]uint64, error) {
+	var (
+		stack  []uint64
+		labels = []label{{arity: len(fn.typ.Returns), cont: len(fn.code)}}
+	)
+	push := func(v uint64) {
+		stack = append(stack, v)
+	}
+	pop := func() uint64 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+	// branch unwinds to the label at `depth` and returns where to continue.
+	branch := func(depth uint32) int {
+		l := labels[len(labels)-1-int(depth)]
+		copy(stack[l.height:], stack[len(stack)-l.arity:])
+		stack = stack[:l.height+l.arity]
+		if l.loop {
+			labels = labels[:len(labels)-int(depth)]
+		} else {
+			labels = labels[:len(labels)-1-int(depth)]
+		}
+		return l.cont
+	}
+
+	for pc := 0; pc < len(fn.code); {
+		op := fn.code[pc]
+		name := fn.names[pc]
+		switch name {
+		case "unreachable":
+			return nil, trap("unreachable")
+		case "nop":
+		case "block", "loop", "if":
+			cond := uint64(1)
+			if name == "if" {
+				cond = pop()
+			}
+			arity := 0
+			if typ, ok := op.Immediates.(string); ok && typ != "block_type" {
+				arity = 1
+			}
+			l := label{arity: arity, height: len(stack), cont: fn.ends[pc] + 1}
+			if name == "loop" {
+				l = label{height: len(stack), cont: pc + 1, loop: true}
+			}
+			if cond == 0 {
+				if fn.elses[pc] < 0 {
+					pc = fn.ends[pc] + 1
+					continue
+				}
+				labels = append(labels, l)
+				pc = fn.elses[pc] + 1
+				continue
+			}
+			labels = append(labels, l)
+		case "else":
+			// the end of the then branch.
+			l := labels[len(labels)-1]
+			labels = labels[:len(labels)-1]
+			pc = l.cont
+			continue
+		case "end":
+			labels = labels[:len(labels)-1]
+		case "br":
+			pc = branch(op.Immediates.(uint32))
+			continue
+		case "br_if":
+			if pop() != 0 {
+				pc = branch(op.Immediates.(uint32))
+				continue
+			}
+		case "br_table":
+			imm := op.Immediates.(tool.BrTable)
+			depth := imm.DefaultTarget
+			if i := uint32(pop()); i < uint32(len(imm.Targets)) {
+				depth = imm.Targets[i]
+			}
+			pc = branch(depth)
+			continue
+		case "return":
+			pc = branch(uint32(len(labels) - 1))
+			continue
+		case "call", "call_indirect":
+			var callee *function
+			if name == "call" {
+				callee = inst.funcs[op.Immediates.(uint32)]
+			} else {
+				imm := op.Immediates.(tool.CallIndirect)
+				i := uint32(pop())
+				if i >= uint32(len(inst.table)) {
+					return nil, trap("undefined element %d", i)
+				}
+				callee = inst.table[i]
+				if callee == nil {
+					return nil, trap("uninitialized element %d", i)
+				}
+				if !sameType(callee.typ, inst.types[imm.Index]) {
+					return nil, trap("indirect call type mismatch")
+				}
+			}
+			n := len(callee.typ.Params)
+			args := append([]uint64{}, stack[len(stack)-n:]...)
+			stack = stack[:len(stack)-n]
+			results, err := inst.call(callee, args)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, results...)
+		case "drop":
+			pop()
+		case "select":
+			c, b, a := pop(), pop(), pop()
+			if c != 0 {
+				push(a)
+			} else {
+				push(b)
+			}
+		case "local.get":
+			push(locals[op.Immediates.(uint32)])
+		case "local.set":
+			locals[op.Immediates.(uint32)] = pop()
+		case "local.tee":
+			locals[op.Immediates.(uint32)] = stack[len(stack)-1]
+		case "global.get":
+			push(inst.globals[op.Immediates.(uint32)])
+		case "global.set":
+			inst.globals[op.Immediates.(uint32)] = pop()
+		case "memory.size":
+			push(uint64(len(inst.memory) / PAGE_SIZE))
+		case "memory.grow":
+			push(uint64(inst.grow(uint32(pop()))))
+		case "i32.const", "i64.const", "f32.const", "f64.const":
+			v, err := constValue(op)
+			if err != nil {
+				return nil, err
+			}
+			push(v)
+		default:
+			if imm, ok := op.Immediates.(tool.MemoryImmediate); ok {
+				if err := inst.access(name, imm, &stack); err != nil {
+					return nil, err
+				}
+				break
+			}
+			sig, ok := tool.OP_SIGNATURES[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported instruction %s", name)
+			}
+			args := stack[len(stack)-len(sig.Params):]
+			v, err := numeric(name, args)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack[:len(stack)-len(sig.Params)], v)
+		}
+		pc++
+	}
+	return stack[len(stack)-len(fn.typ.Returns):], nil
+}
+
+// grow grows the memory by `delta` pages and returns the previous size in
+// pages, or -1 if the memory can not grow.
+func (inst *Instance) grow(delta uint32) uint32 {
+	pages := uint32(len(inst.memory) / PAGE_SIZE)
+	if !inst.hasMem || uint64(pages)+uint64(delta) > uint64(inst.memMax) {
+		return 0xffffffff
+	}
+	inst.memory = append(inst.memory, make([]byte, int(delta)*PAGE_SIZE)...)
+	return pages
+}
+
+// MEMORY_ACCESS_SIZES is the number of bytes loads and stores access.
+var MEMORY_ACCESS_SIZES = map[string]int{
+	"i32.load": 4, "i64.load": 8, "f32.load": 4, "f64.load": 8,
+	"i32.load8_s": 1, "i32.load8_u": 1, "i32.load16_s": 2, "i32.load16_u": 2,
+	"i64.load8_s": 1, "i64.load8_u": 1, "i64.load16_s": 2, "i64.load16_u": 2,
+	"i64.load32_s": 4, "i64.load32_u": 4,
+	"i32.store": 4, "i64.store": 8, "f32.store": 4, "f64.store": 8,
+	"i32.store8": 1, "i32.store16": 2,
+	"i64.store8": 1, "i64.store16": 2, "i64.store32": 4,
+}
+
+// access runs a load or a store.
+func (inst *Instance) access(name string, imm tool.MemoryImmediate, stack *[]uint64) error {
+	size, ok := MEMORY_ACCESS_SIZES[name]
+	if !ok {
+		return fmt.Errorf("unsupported instruction %s", name)
+	}
+	s := *stack
+	store := tool.OP_SIGNATURES[name].Results == nil
+	var value uint64
+	if store {
+		value, s = s[len(s)-1], s[:len(s)-1]
+	}
+	ea := uint64(uint32(s[len(s)-1])) + uint64(imm.Offset)
+	s = s[:len(s)-1]
+	if ea+uint64(size) > uint64(len(inst.memory)) {
+		return trap("out of bounds memory access")
+	}
+	mem := inst.memory[ea : ea+uint64(size)]
+
+	if store {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], value)
+		copy(mem, buf[:size])
+		*stack = s
+		return nil
+	}
+
+	var buf [8]byte
+	copy(buf[:], mem)
+	value = binary.LittleEndian.Uint64(buf[:])
+	switch name {
+	case "i32.load8_s":
+		value = uint64(uint32(int8(value)))
+	case "i32.load16_s":
+		value = uint64(uint32(int16(value)))
+	case "i64.load8_s":
+		value = uint64(int8(value))
+	case "i64.load16_s":
+		value = uint64(int16(value))
+	case "i64.load32_s":
+		value = uint64(int32(value))
+	}
+	*stack = append(s, value)
+	return nil
+}
+
+func sameType(a, b tool.TypeEntry) bool {
+	if len(a.Params) != len(b.Params) || len(a.Returns) != len(b.Returns) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	for i := range a.Returns {
+		if a.Returns[i] != b.Returns[i] {
+			return false
+		}
+	}
+	return true
+}
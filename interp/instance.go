@@ -0,0 +1,342 @@
+// Package interp is a reference interpreter of the WebAssembly MVP over the
+// module IR. It favours simplicity over speed and is meant for tests, e.g.
+// running metered code against a Go implementation of the metering import.
+package interp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+const (
+	PAGE_SIZE = 65536
+	MAX_PAGES = 65536
+
+	// DEFAULT_MAX_CALL_DEPTH is the number of nested calls after which the
+	// interpreter traps.
+	DEFAULT_MAX_CALL_DEPTH = 10000
+)
+
+// Trap is an error raised by the executed code, e.g. `unreachable` or an out
+// of bounds memory access.
+type Trap struct {
+	Msg string
+}
+
+func (t *Trap) Error() string {
+	return "trap: " + t.Msg
+}
+
+func trap(format string, args ...interface{}) error {
+	return &Trap{Msg: fmt.Sprintf(format, args...)}
+}
+
+// HostFunc implements an imported function in Go. Values are passed as their
+// bits: i32 zero extended to 64 bits, floats as returned by math.Float32bits
+// and math.Float64bits. An error aborts the execution and is returned by the
+// outermost call.
+type HostFunc func(inst *Instance, args []uint64) ([]uint64, error)
+
+// Imports provide the imported functions and global values of a module, by
+// module and field name. Imported tables and memories are created with their
+// minimum size.
+type Imports struct {
+	Funcs   map[string]map[string]HostFunc
+	Globals map[string]map[string]uint64
+}
+
+// function is a function of the function index space.
+type function struct {
+	typ   tool.TypeEntry
+	host  HostFunc
+	names []string // full names of the operators of the body.
+	code  []tool.OP
+	ends  []int // position of the matching `end` of block operators.
+	elses []int // position of the `else` of `if` operators, -1 without.
+
+	numLocals int
+}
+
+// Instance is an instantiated module.
+type Instance struct {
+	MaxCallDepth int
+
+	types    []tool.TypeEntry
+	funcs    []*function
+	table    []*function
+	tableMax *uint32
+	memory   []byte
+	memMax   uint32
+	hasMem   bool
+	globals  []uint64
+	exports  map[string]tool.ExportEntry
+	depth    int
+}
+
+// Instantiate instantiates a module: it resolves the imports, initializes
+// globals, the table and the memory and runs the start function. The module
+// is expected to be valid.
+func Instantiate(module *tool.Module, imports Imports) (*Instance, error) {
+	inst := &Instance{
+		MaxCallDepth: DEFAULT_MAX_CALL_DEPTH,
+		exports:      map[string]tool.ExportEntry{},
+	}
+	if sec := module.TypeSec(); sec != nil {
+		inst.types = sec.Entries
+	}
+
+	if sec := module.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			name := entry.ModuleStr + "." + entry.FieldStr
+			switch typ := entry.Type.(type) {
+			case uint32:
+				host := imports.Funcs[entry.ModuleStr][entry.FieldStr]
+				if host == nil {
+					return nil, fmt.Errorf("instantiate error: unknown import %s", name)
+				}
+				if typ >= uint32(len(inst.types)) {
+					return nil, fmt.Errorf("instantiate error: unknown type %d", typ)
+				}
+				inst.funcs = append(inst.funcs, &function{typ: inst.types[typ], host: host})
+			case tool.Global:
+				value, ok := imports.Globals[entry.ModuleStr][entry.FieldStr]
+				if !ok {
+					return nil, fmt.Errorf("instantiate error: unknown import %s", name)
+				}
+				inst.globals = append(inst.globals, value)
+			case tool.Table:
+				inst.newTable(typ)
+			case tool.MemLimits:
+				inst.newMemory(typ)
+			default:
+				return nil, fmt.Errorf("instantiate error: invalid import %s", name)
+			}
+		}
+	}
+
+	if sec := module.FuncSec(); sec != nil {
+		var bodies []tool.CodeBody
+		if code := module.CodeSec(); code != nil {
+			if err := code.Decode(); err != nil {
+				return nil, fmt.Errorf("instantiate error: %w", err)
+			}
+			bodies = code.Entries
+		}
+		if len(bodies) != len(sec.Entries) {
+			return nil, fmt.Errorf("instantiate error: function and code section have inconsistent lengths %d and %d", len(sec.Entries), len(bodies))
+		}
+		for i, typ := range sec.Entries {
+			if typ >= uint32(len(inst.types)) {
+				return nil, fmt.Errorf("instantiate error: unknown type %d", typ)
+			}
+			fn, err := newFunction(inst.types[typ], bodies[i])
+			if err != nil {
+				return nil, fmt.Errorf("instantiate error: function %d: %w", len(inst.funcs), err)
+			}
+			inst.funcs = append(inst.funcs, fn)
+		}
+	}
+
+	if sec := module.TableSec(); sec != nil {
+		for _, table := range sec.Entries {
+			inst.newTable(table)
+		}
+	}
+	if sec := module.MemSec(); sec != nil {
+		for _, mem := range sec.Entries {
+			inst.newMemory(mem)
+		}
+	}
+	if sec := module.GlobalSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			value, err := inst.constExpr(entry.Init)
+			if err != nil {
+				return nil, fmt.Errorf("instantiate error: %w", err)
+			}
+			inst.globals = append(inst.globals, value)
+		}
+	}
+	if sec := module.ExportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			inst.exports[entry.FieldStr] = entry
+		}
+	}
+
+	if sec := module.ElementSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			offset, err := inst.constExpr(entry.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("instantiate error: %w", err)
+			}
+			if uint64(uint32(offset))+uint64(len(entry.Elements)) > uint64(len(inst.table)) {
+				return nil, fmt.Errorf("instantiate error: %w", trap("elements segment does not fit"))
+			}
+			for i, index := range entry.Elements {
+				if index >= uint32(len(inst.funcs)) {
+					return nil, fmt.Errorf("instantiate error: unknown function %d", index)
+				}
+				inst.table[uint32(offset)+uint32(i)] = inst.funcs[index]
+			}
+		}
+	}
+	if sec := module.DataSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			offset, err := inst.constExpr(entry.Offset)
+			if err != nil {
+				return nil, fmt.Errorf("instantiate error: %w", err)
+			}
+			if uint64(uint32(offset))+uint64(len(entry.Data)) > uint64(len(inst.memory)) {
+				return nil, fmt.Errorf("instantiate error: %w", trap("data segment does not fit"))
+			}
+			copy(inst.memory[uint32(offset):], entry.Data)
+		}
+	}
+
+	if sec := module.StartSec(); sec != nil {
+		if _, err := inst.CallIndex(sec.Index); err != nil {
+			return nil, fmt.Errorf("instantiate error: start function: %w", err)
+		}
+	}
+	return inst, nil
+}
+
+func (inst *Instance) newTable(table tool.Table) {
+	inst.table = make([]*function, table.Limits.Intial)
+	if max, ok := table.Limits.Maximum.(uint32); ok {
+		inst.tableMax = &max
+	}
+}
+
+func (inst *Instance) newMemory(limits tool.MemLimits) {
+	inst.hasMem = true
+	inst.memory = make([]byte, int(limits.Intial)*PAGE_SIZE)
+	inst.memMax = MAX_PAGES
+	if max, ok := limits.Maximum.(uint32); ok {
+		inst.memMax = max
+	}
+}
+
+// constExpr evaluates an initializer expression.
+func (inst *Instance) constExpr(op tool.OP) (uint64, error) {
+	switch op.FullName() {
+	case "i32.const", "i64.const", "f32.const", "f64.const":
+		return constValue(op)
+	case "global.get":
+		index, ok := op.Immediates.(uint32)
+		if !ok || index >= uint32(len(inst.globals)) {
+			return 0, fmt.Errorf("unknown global %v", op.Immediates)
+		}
+		return inst.globals[index], nil
+	}
+	return 0, fmt.Errorf("constant expression required, got %s", op.FullName())
+}
+
+// constValue returns the bits of the immediate of a const operator.
+func constValue(op tool.OP) (uint64, error) {
+	switch imm := op.Immediates.(type) {
+	case int32:
+		return uint64(uint32(imm)), nil
+	case int64:
+		return uint64(imm), nil
+	case []byte:
+		if op.ReturnType() == "f32" && len(imm) == 4 {
+			return uint64(binary.LittleEndian.Uint32(imm)), nil
+		}
+		if op.ReturnType() == "f64" && len(imm) == 8 {
+			return binary.LittleEndian.Uint64(imm), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid immediate of %s: %v", op.FullName(), op.Immediates)
+}
+
+// newFunction prepares a function body for execution, matching block
+// operators with their `else` and `end`.
+func newFunction(typ tool.TypeEntry, body tool.CodeBody) (*function, error) {
+	fn := &function{
+		typ:   typ,
+		code:  body.Code,
+		names: make([]string, len(body.Code)),
+		ends:  make([]int, len(body.Code)),
+		elses: make([]int, len(body.Code)),
+	}
+	for _, local := range body.Locals {
+		fn.numLocals += int(local.Count)
+	}
+
+	var open []int
+	for pc, op := range body.Code {
+		fn.names[pc] = op.FullName()
+		fn.elses[pc] = -1
+		switch fn.names[pc] {
+		case "block", "loop", "if":
+			open = append(open, pc)
+		case "else":
+			if len(open) == 0 {
+				return nil, fmt.Errorf("else without if")
+			}
+			fn.elses[open[len(open)-1]] = pc
+		case "end":
+			if len(open) == 0 {
+				if pc != len(body.Code)-1 {
+					return nil, fmt.Errorf("operators after the end of the body")
+				}
+				break
+			}
+			fn.ends[open[len(open)-1]] = pc
+			open = open[:len(open)-1]
+		}
+	}
+	if len(open) != 0 || len(body.Code) == 0 || fn.names[len(body.Code)-1] != "end" {
+		return nil, fmt.Errorf("missing end")
+	}
+	return fn, nil
+}
+
+// Call calls an exported function.
+func (inst *Instance) Call(name string, args ...uint64) ([]uint64, error) {
+	export, ok := inst.exports[name]
+	if !ok || export.Kind != "function" {
+		return nil, fmt.Errorf("call error: unknown exported function %s", name)
+	}
+	return inst.CallIndex(export.Index, args...)
+}
+
+// CallIndex calls a function of the function index space.
+func (inst *Instance) CallIndex(index uint32, args ...uint64) ([]uint64, error) {
+	if index >= uint32(len(inst.funcs)) {
+		return nil, fmt.Errorf("call error: unknown function %d", index)
+	}
+	fn := inst.funcs[index]
+	if len(args) != len(fn.typ.Params) {
+		return nil, fmt.Errorf("call error: function %d takes %d arguments, got %d", index, len(fn.typ.Params), len(args))
+	}
+	inst.depth = 0
+	return inst.call(fn, args)
+}
+
+// Memory returns the memory of the instance, nil without memory.
+func (inst *Instance) Memory() []byte {
+	return inst.memory
+}
+
+// Global returns the value of an exported global.
+func (inst *Instance) Global(name string) (uint64, bool) {
+	export, ok := inst.exports[name]
+	if !ok || export.Kind != "global" || export.Index >= uint32(len(inst.globals)) {
+		return 0, false
+	}
+	return inst.globals[export.Index], true
+}
+
+// I32, I64, F32 and F64 return the bits of values passed to and returned by
+// functions.
+func I32(v int32) uint64 { return uint64(uint32(v)) }
+
+func I64(v int64) uint64 { return uint64(v) }
+
+func F32(v float32) uint64 { return uint64(math.Float32bits(v)) }
+
+func F64(v float64) uint64 { return math.Float64bits(v) }
@@ -0,0 +1,384 @@
+package interp
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+func b2i(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func f32(v uint64) float32 { return math.Float32frombits(uint32(v)) }
+
+func f64(v uint64) float64 { return math.Float64frombits(v) }
+
+// numeric runs an operator with a fixed signature, other than loads and
+// stores, on the bits of its operands.
+func numeric(name string, args []uint64) (uint64, error) {
+	if len(args) == 1 {
+		return unary(name, args[0])
+	}
+	a, b := args[0], args[1]
+	x, y := uint32(a), uint32(b)
+	switch name {
+	// i32
+	case "i32.add":
+		return uint64(x + y), nil
+	case "i32.sub":
+		return uint64(x - y), nil
+	case "i32.mul":
+		return uint64(x * y), nil
+	case "i32.div_s":
+		if y == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int32(x) == math.MinInt32 && int32(y) == -1 {
+			return 0, trap("integer overflow")
+		}
+		return uint64(uint32(int32(x) / int32(y))), nil
+	case "i32.div_u":
+		if y == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return uint64(x / y), nil
+	case "i32.rem_s":
+		if y == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int32(y) == -1 {
+			return 0, nil
+		}
+		return uint64(uint32(int32(x) % int32(y))), nil
+	case "i32.rem_u":
+		if y == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return uint64(x % y), nil
+	case "i32.and":
+		return uint64(x & y), nil
+	case "i32.or":
+		return uint64(x | y), nil
+	case "i32.xor":
+		return uint64(x ^ y), nil
+	case "i32.shl":
+		return uint64(x << (y & 31)), nil
+	case "i32.shr_s":
+		return uint64(uint32(int32(x) >> (y & 31))), nil
+	case "i32.shr_u":
+		return uint64(x >> (y & 31)), nil
+	case "i32.rotl":
+		return uint64(bits.RotateLeft32(x, int(y&31))), nil
+	case "i32.rotr":
+		return uint64(bits.RotateLeft32(x, -int(y&31))), nil
+	case "i32.eq":
+		return b2i(x == y), nil
+	case "i32.ne":
+		return b2i(x != y), nil
+	case "i32.lt_s":
+		return b2i(int32(x) < int32(y)), nil
+	case "i32.lt_u":
+		return b2i(x < y), nil
+	case "i32.gt_s":
+		return b2i(int32(x) > int32(y)), nil
+	case "i32.gt_u":
+		return b2i(x > y), nil
+	case "i32.le_s":
+		return b2i(int32(x) <= int32(y)), nil
+	case "i32.le_u":
+		return b2i(x <= y), nil
+	case "i32.ge_s":
+		return b2i(int32(x) >= int32(y)), nil
+	case "i32.ge_u":
+		return b2i(x >= y), nil
+
+	// i64
+	case "i64.add":
+		return a + b, nil
+	case "i64.sub":
+		return a - b, nil
+	case "i64.mul":
+		return a * b, nil
+	case "i64.div_s":
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			return 0, trap("integer overflow")
+		}
+		return uint64(int64(a) / int64(b)), nil
+	case "i64.div_u":
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return a / b, nil
+	case "i64.rem_s":
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		if int64(b) == -1 {
+			return 0, nil
+		}
+		return uint64(int64(a) % int64(b)), nil
+	case "i64.rem_u":
+		if b == 0 {
+			return 0, trap("integer divide by zero")
+		}
+		return a % b, nil
+	case "i64.and":
+		return a & b, nil
+	case "i64.or":
+		return a | b, nil
+	case "i64.xor":
+		return a ^ b, nil
+	case "i64.shl":
+		return a << (b & 63), nil
+	case "i64.shr_s":
+		return uint64(int64(a) >> (b & 63)), nil
+	case "i64.shr_u":
+		return a >> (b & 63), nil
+	case "i64.rotl":
+		return bits.RotateLeft64(a, int(b&63)), nil
+	case "i64.rotr":
+		return bits.RotateLeft64(a, -int(b&63)), nil
+	case "i64.eq":
+		return b2i(a == b), nil
+	case "i64.ne":
+		return b2i(a != b), nil
+	case "i64.lt_s":
+		return b2i(int64(a) < int64(b)), nil
+	case "i64.lt_u":
+		return b2i(a < b), nil
+	case "i64.gt_s":
+		return b2i(int64(a) > int64(b)), nil
+	case "i64.gt_u":
+		return b2i(a > b), nil
+	case "i64.le_s":
+		return b2i(int64(a) <= int64(b)), nil
+	case "i64.le_u":
+		return b2i(a <= b), nil
+	case "i64.ge_s":
+		return b2i(int64(a) >= int64(b)), nil
+	case "i64.ge_u":
+		return b2i(a >= b), nil
+
+	// f32
+	case "f32.add":
+		return F32(f32(a) + f32(b)), nil
+	case "f32.sub":
+		return F32(f32(a) - f32(b)), nil
+	case "f32.mul":
+		return F32(f32(a) * f32(b)), nil
+	case "f32.div":
+		return F32(f32(a) / f32(b)), nil
+	case "f32.min":
+		return F32(float32(math.Min(float64(f32(a)), float64(f32(b))))), nil
+	case "f32.max":
+		return F32(float32(math.Max(float64(f32(a)), float64(f32(b))))), nil
+	case "f32.copysign":
+		return uint64(x&0x7fffffff | y&0x80000000), nil
+	case "f32.eq":
+		return b2i(f32(a) == f32(b)), nil
+	case "f32.ne":
+		return b2i(f32(a) != f32(b)), nil
+	case "f32.lt":
+		return b2i(f32(a) < f32(b)), nil
+	case "f32.gt":
+		return b2i(f32(a) > f32(b)), nil
+	case "f32.le":
+		return b2i(f32(a) <= f32(b)), nil
+	case "f32.ge":
+		return b2i(f32(a) >= f32(b)), nil
+
+	// f64
+	case "f64.add":
+		return F64(f64(a) + f64(b)), nil
+	case "f64.sub":
+		return F64(f64(a) - f64(b)), nil
+	case "f64.mul":
+		return F64(f64(a) * f64(b)), nil
+	case "f64.div":
+		return F64(f64(a) / f64(b)), nil
+	case "f64.min":
+		return F64(math.Min(f64(a), f64(b))), nil
+	case "f64.max":
+		return F64(math.Max(f64(a), f64(b))), nil
+	case "f64.copysign":
+		return a&(1<<63-1) | b&(1<<63), nil
+	case "f64.eq":
+		return b2i(f64(a) == f64(b)), nil
+	case "f64.ne":
+		return b2i(f64(a) != f64(b)), nil
+	case "f64.lt":
+		return b2i(f64(a) < f64(b)), nil
+	case "f64.gt":
+		return b2i(f64(a) > f64(b)), nil
+	case "f64.le":
+		return b2i(f64(a) <= f64(b)), nil
+	case "f64.ge":
+		return b2i(f64(a) >= f64(b)), nil
+	}
+	return 0, fmt.Errorf("unsupported instruction %s", name)
+}
+
+func unary(name string, a uint64) (uint64, error) {
+	x := uint32(a)
+	switch name {
+	case "i32.eqz":
+		return b2i(x == 0), nil
+	case "i32.clz":
+		return uint64(bits.LeadingZeros32(x)), nil
+	case "i32.ctz":
+		return uint64(bits.TrailingZeros32(x)), nil
+	case "i32.popcnt":
+		return uint64(bits.OnesCount32(x)), nil
+	case "i32.extend8_s":
+		return uint64(uint32(int8(x))), nil
+	case "i32.extend16_s":
+		return uint64(uint32(int16(x))), nil
+	case "i64.eqz":
+		return b2i(a == 0), nil
+	case "i64.clz":
+		return uint64(bits.LeadingZeros64(a)), nil
+	case "i64.ctz":
+		return uint64(bits.TrailingZeros64(a)), nil
+	case "i64.popcnt":
+		return uint64(bits.OnesCount64(a)), nil
+	case "i64.extend8_s":
+		return uint64(int8(a)), nil
+	case "i64.extend16_s":
+		return uint64(int16(a)), nil
+	case "i64.extend32_s":
+		return uint64(int32(a)), nil
+
+	case "f32.abs":
+		return uint64(x & 0x7fffffff), nil
+	case "f32.neg":
+		return uint64(x ^ 0x80000000), nil
+	case "f32.ceil":
+		return F32(float32(math.Ceil(float64(f32(a))))), nil
+	case "f32.floor":
+		return F32(float32(math.Floor(float64(f32(a))))), nil
+	case "f32.trunc":
+		return F32(float32(math.Trunc(float64(f32(a))))), nil
+	case "f32.nearest":
+		return F32(float32(math.RoundToEven(float64(f32(a))))), nil
+	case "f32.sqrt":
+		return F32(float32(math.Sqrt(float64(f32(a))))), nil
+	case "f64.abs":
+		return a & (1<<63 - 1), nil
+	case "f64.neg":
+		return a ^ 1<<63, nil
+	case "f64.ceil":
+		return F64(math.Ceil(f64(a))), nil
+	case "f64.floor":
+		return F64(math.Floor(f64(a))), nil
+	case "f64.trunc":
+		return F64(math.Trunc(f64(a))), nil
+	case "f64.nearest":
+		return F64(math.RoundToEven(f64(a))), nil
+	case "f64.sqrt":
+		return F64(math.Sqrt(f64(a))), nil
+
+	// conversions
+	case "i32.wrap_i64":
+		return uint64(x), nil
+	case "i64.extend_i32_s":
+		return uint64(int32(x)), nil
+	case "i64.extend_i32_u":
+		return uint64(x), nil
+	case "i32.trunc_f32_s", "i32.trunc_f64_s", "i32.trunc_f32_u", "i32.trunc_f64_u",
+		"i64.trunc_f32_s", "i64.trunc_f64_s", "i64.trunc_f32_u", "i64.trunc_f64_u":
+		return truncate(name, a, false)
+	case "i32.trunc_sat_f32_s", "i32.trunc_sat_f64_s", "i32.trunc_sat_f32_u", "i32.trunc_sat_f64_u",
+		"i64.trunc_sat_f32_s", "i64.trunc_sat_f64_s", "i64.trunc_sat_f32_u", "i64.trunc_sat_f64_u":
+		return truncate(name, a, true)
+	case "f32.convert_i32_s":
+		return F32(float32(int32(x))), nil
+	case "f32.convert_i32_u":
+		return F32(float32(x)), nil
+	case "f32.convert_i64_s":
+		return F32(float32(int64(a))), nil
+	case "f32.convert_i64_u":
+		return F32(float32(a)), nil
+	case "f64.convert_i32_s":
+		return F64(float64(int32(x))), nil
+	case "f64.convert_i32_u":
+		return F64(float64(x)), nil
+	case "f64.convert_i64_s":
+		return F64(float64(int64(a))), nil
+	case "f64.convert_i64_u":
+		return F64(float64(a)), nil
+	case "f32.demote_f64":
+		return F32(float32(f64(a))), nil
+	case "f64.promote_f32":
+		return F64(float64(f32(a))), nil
+	case "i32.reinterpret_f32", "f32.reinterpret_i32":
+		return uint64(x), nil
+	case "i64.reinterpret_f64", "f64.reinterpret_i64":
+		return a, nil
+	}
+	return 0, fmt.Errorf("unsupported instruction %s", name)
+}
+
+// truncate converts a float to an integer, trapping on NaN and values out of
+// range or saturating them if `sat` is set.
+func truncate(name string, a uint64, sat bool) (uint64, error) {
+	var v float64
+	if name[len(name)-5:len(name)-2] == "f32" {
+		v = float64(f32(a))
+	} else {
+		v = f64(a)
+	}
+	is32 := name[:3] == "i32"
+	signed := name[len(name)-1] == 's'
+
+	// the truncated value must be in [lo, hi).
+	var (
+		lo, hi     float64
+		minV, maxV uint64 // the saturated results.
+	)
+	switch {
+	case is32 && signed:
+		lo, hi, minV, maxV = -1<<31, 1<<31, 1<<31, math.MaxInt32
+	case is32:
+		lo, hi, minV, maxV = 0, 1<<32, 0, math.MaxUint32
+	case signed:
+		lo, hi, minV, maxV = -1<<63, 1<<63, 1<<63, math.MaxInt64
+	default:
+		lo, hi, minV, maxV = 0, 1<<64, 0, math.MaxUint64
+	}
+
+	t := math.Trunc(v)
+	switch {
+	case math.IsNaN(v):
+		if !sat {
+			return 0, trap("invalid conversion to integer")
+		}
+		return 0, nil
+	case t < lo:
+		if !sat {
+			return 0, trap("integer overflow")
+		}
+		return minV, nil
+	case t >= hi:
+		if !sat {
+			return 0, trap("integer overflow")
+		}
+		return maxV, nil
+	}
+
+	switch {
+	case is32 && signed:
+		return uint64(uint32(int32(t))), nil
+	case is32:
+		return uint64(uint32(t)), nil
+	case signed:
+		return uint64(int64(t)), nil
+	}
+	return uint64(t), nil
+}
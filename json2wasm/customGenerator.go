@@ -26,7 +26,7 @@ func (customGenerator) CustomName(custom []tool.CustomName, payload *tool.Stream
 				return nil, fmt.Errorf("custom generator name: %w", err)
 			}
 			for _, funcName := range funcNames {
-				if err := subPayload.WriteByte(byte(funcName.Index)); err != nil {
+				if _, err := tool.EncodeULEB128(funcName.Index, subPayload); err != nil {
 					return nil, fmt.Errorf("custom generator name: %w", err)
 				}
 				if _, err := tool.EncodeULEB128(uint32(len(funcName.NameStr)), subPayload); err != nil {
@@ -42,11 +42,14 @@ func (customGenerator) CustomName(custom []tool.CustomName, payload *tool.Stream
 				return nil, fmt.Errorf("custom generator name: %w", err)
 			}
 			for _, funcLocal := range functionLocals {
-				if err := subPayload.WriteByte(byte(funcLocal.Index)); err != nil {
+				if _, err := tool.EncodeULEB128(funcLocal.Index, subPayload); err != nil {
+					return nil, fmt.Errorf("custom generator name: %w", err)
+				}
+				if _, err := tool.EncodeULEB128(uint32(len(funcLocal.NameMap)), subPayload); err != nil {
 					return nil, fmt.Errorf("custom generator name: %w", err)
 				}
 				for _, local := range funcLocal.NameMap {
-					if err := subPayload.WriteByte(byte(local.Index)); err != nil {
+					if _, err := tool.EncodeULEB128(local.Index, subPayload); err != nil {
 						return nil, fmt.Errorf("custom generator name: %w", err)
 					}
 					if _, err := tool.EncodeULEB128(uint32(len(local.NameStr)), subPayload); err != nil {
@@ -68,3 +71,138 @@ func (customGenerator) CustomName(custom []tool.CustomName, payload *tool.Stream
 	}
 	return payload, nil
 }
+
+// writeString writes a length-prefixed UTF-8 string.
+func (customGenerator) writeString(str string, payload *tool.Stream) error {
+	if _, err := tool.EncodeULEB128(uint32(len(str)), payload); err != nil {
+		return err
+	}
+	if _, err := payload.Write([]byte(str)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c customGenerator) Producers(producers tool.Producers, payload *tool.Stream) (*tool.Stream, error) {
+	if _, err := tool.EncodeULEB128(uint32(len(producers.Fields)), payload); err != nil {
+		return nil, fmt.Errorf("custom generator producers: %w", err)
+	}
+	for _, field := range producers.Fields {
+		if err := c.writeString(field.Name, payload); err != nil {
+			return nil, fmt.Errorf("custom generator producers: %w", err)
+		}
+		if _, err := tool.EncodeULEB128(uint32(len(field.Values)), payload); err != nil {
+			return nil, fmt.Errorf("custom generator producers: %w", err)
+		}
+		for _, value := range field.Values {
+			if err := c.writeString(value.Name, payload); err != nil {
+				return nil, fmt.Errorf("custom generator producers: %w", err)
+			}
+			if err := c.writeString(value.Version, payload); err != nil {
+				return nil, fmt.Errorf("custom generator producers: %w", err)
+			}
+		}
+	}
+	return payload, nil
+}
+
+func (c customGenerator) TargetFeatures(features tool.TargetFeatures, payload *tool.Stream) (*tool.Stream, error) {
+	if _, err := tool.EncodeULEB128(uint32(len(features)), payload); err != nil {
+		return nil, fmt.Errorf("custom generator target features: %w", err)
+	}
+	for _, feature := range features {
+		if err := payload.WriteByte(feature.Prefix); err != nil {
+			return nil, fmt.Errorf("custom generator target features: %w", err)
+		}
+		if err := c.writeString(feature.Name, payload); err != nil {
+			return nil, fmt.Errorf("custom generator target features: %w", err)
+		}
+	}
+	return payload, nil
+}
+
+// URL generates the payload shared by `sourceMappingURL` and `external_debug_info`.
+func (c customGenerator) URL(url string, payload *tool.Stream) (*tool.Stream, error) {
+	if err := c.writeString(url, payload); err != nil {
+		return nil, fmt.Errorf("custom generator url: %w", err)
+	}
+	return payload, nil
+}
+
+func (c customGenerator) Dylink(dylink tool.Dylink, payload *tool.Stream) (*tool.Stream, error) {
+	for _, entry := range dylink {
+		sub, err := c.dylinkSubsection(entry)
+		if err != nil {
+			return nil, fmt.Errorf("custom generator dylink: %w", err)
+		}
+		if err := payload.WriteByte(entry.Id); err != nil {
+			return nil, fmt.Errorf("custom generator dylink: %w", err)
+		}
+		if _, err := tool.EncodeULEB128(uint32(sub.Len()), payload); err != nil {
+			return nil, fmt.Errorf("custom generator dylink: %w", err)
+		}
+		if _, err := payload.Write(sub.Bytes()); err != nil {
+			return nil, fmt.Errorf("custom generator dylink: %w", err)
+		}
+	}
+	return payload, nil
+}
+
+// dylinkSubsection generates the payload of a `dylink.0` subsection from the
+// field of its id, or its raw payload for unknown ids.
+func (c customGenerator) dylinkSubsection(entry tool.DylinkSubsection) (*tool.Stream, error) {
+	sub := tool.NewStream(nil)
+	switch entry.Id {
+	case J2W_DYLINK_SUBSECTION_TYPES["mem_info"]:
+		info := entry.MemInfo
+		if info == nil {
+			return nil, fmt.Errorf("mem_info subsection without mem_info")
+		}
+		for _, field := range []uint32{info.MemorySize, info.MemoryAlign, info.TableSize, info.TableAlign} {
+			if _, err := tool.EncodeULEB128(field, sub); err != nil {
+				return nil, err
+			}
+		}
+	case J2W_DYLINK_SUBSECTION_TYPES["needed"]:
+		if _, err := tool.EncodeULEB128(uint32(len(entry.Needed)), sub); err != nil {
+			return nil, err
+		}
+		for _, needed := range entry.Needed {
+			if err := c.writeString(needed, sub); err != nil {
+				return nil, err
+			}
+		}
+	case J2W_DYLINK_SUBSECTION_TYPES["export_info"]:
+		if _, err := tool.EncodeULEB128(uint32(len(entry.ExportInfo)), sub); err != nil {
+			return nil, err
+		}
+		for _, info := range entry.ExportInfo {
+			if err := c.writeString(info.Name, sub); err != nil {
+				return nil, err
+			}
+			if _, err := tool.EncodeULEB128(info.Flags, sub); err != nil {
+				return nil, err
+			}
+		}
+	case J2W_DYLINK_SUBSECTION_TYPES["import_info"]:
+		if _, err := tool.EncodeULEB128(uint32(len(entry.ImportInfo)), sub); err != nil {
+			return nil, err
+		}
+		for _, info := range entry.ImportInfo {
+			if err := c.writeString(info.ModuleStr, sub); err != nil {
+				return nil, err
+			}
+			if err := c.writeString(info.FieldStr, sub); err != nil {
+				return nil, err
+			}
+			if _, err := tool.EncodeULEB128(info.Flags, sub); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		if _, err := sub.Write(entry.Payload); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
@@ -30,7 +30,7 @@ func (immediataryGenerator) Varint32(j int32, stream *tool.Stream) (*tool.Stream
 }
 
 func (immediataryGenerator) Varint64(j int64, stream *tool.Stream) (*tool.Stream, error) {
-	if _, err := tool.EncodeSLEB128(int32(j), stream); err != nil {
+	if _, err := tool.EncodeSLEB128Int64(j, stream); err != nil {
 		return nil, fmt.Errorf("immediatary generator Varint64: %w", err)
 	}
 	return stream, nil
@@ -57,39 +57,37 @@ func (immediataryGenerator) BlockType(j string, stream *tool.Stream) (*tool.Stre
 	return stream, nil
 }
 
-func (immediataryGenerator) BrTable(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
-	targets := j["targets"].([]uint32)
-	if _, err := tool.EncodeULEB128(uint32(len(targets)), stream); err != nil {
+func (immediataryGenerator) BrTable(j tool.BrTable, stream *tool.Stream) (*tool.Stream, error) {
+	if _, err := tool.EncodeULEB128(uint32(len(j.Targets)), stream); err != nil {
 		return nil, fmt.Errorf("immediatary generator BrTable: %w", err)
 	}
 
-	for _, target := range targets {
+	for _, target := range j.Targets {
 		if _, err := tool.EncodeULEB128(target, stream); err != nil {
 			return nil, fmt.Errorf("immediatary generator BrTable: %w", err)
 		}
 	}
-	if _, err := tool.EncodeULEB128(j["default_target"].(uint32), stream); err != nil {
-		return nil, fmt.Errorf("immediatary generator Varuint1: %w", err)
+	if _, err := tool.EncodeULEB128(j.DefaultTarget, stream); err != nil {
+		return nil, fmt.Errorf("immediatary generator BrTable: %w", err)
 	}
 	return stream, nil
 }
 
-func (immediataryGenerator) CallIndirect(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
-	index := j["index"]
-	if _, err := tool.EncodeULEB128(index.(uint32), stream); err != nil {
+func (immediataryGenerator) CallIndirect(j tool.CallIndirect, stream *tool.Stream) (*tool.Stream, error) {
+	if _, err := tool.EncodeULEB128(j.Index, stream); err != nil {
 		return nil, fmt.Errorf("immediatary generator CallIndirect: %w", err)
 	}
-	if err := stream.WriteByte(j["reserved"].(byte)); err != nil {
+	if err := stream.WriteByte(j.Reserved); err != nil {
 		return nil, fmt.Errorf("immediatary generator CallIndirect: %w", err)
 	}
 	return stream, nil
 }
 
-func (immediataryGenerator) MemoryImmediate(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
-	if _, err := tool.EncodeULEB128(j["flags"].(uint32), stream); err != nil {
+func (immediataryGenerator) MemoryImmediate(j tool.MemoryImmediate, stream *tool.Stream) (*tool.Stream, error) {
+	if _, err := tool.EncodeULEB128(j.Flags, stream); err != nil {
 		return nil, fmt.Errorf("immediatary generator MemoryImmediate: %w", err)
 	}
-	if _, err := tool.EncodeULEB128(j["offset"].(uint32), stream); err != nil {
+	if _, err := tool.EncodeULEB128(j.Offset, stream); err != nil {
 		return nil, fmt.Errorf("immediatary generator MemoryImmediate: %w", err)
 	}
 	return stream, nil
@@ -15,21 +15,95 @@ var (
 
 // Json2Wasm converts a JSON array to wasm binary.
 func Json2Wasm(j []tool.JSON) ([]byte, error) {
-	stream := tool.NewStream(nil)
-	preamble := j[0]
-	if _, err := GeneratePreramble(preamble, stream); err != nil {
+	module, err := tool.ModuleFromJSON(j)
+	if err != nil {
 		return nil, fmt.Errorf("json 2 wasm error: %w", err)
 	}
-	rest := j[1:]
-	for _, item := range rest {
-		if _, err := GenerateSection(item, stream); err != nil {
-			return nil, fmt.Errorf("json 2 wasm error: %w", err)
+	return Module2Wasm(module)
+}
+
+// Options configures the encoder.
+type Options struct {
+	Workers int // encode function bodies with up to that many goroutines.
+}
+
+// Module2Wasm converts a typed module to wasm binary.
+func Module2Wasm(module *tool.Module) ([]byte, error) {
+	return Module2WasmWithOptions(module, Options{})
+}
+
+// Module2WasmWithOptions converts a typed module to wasm binary.
+func Module2WasmWithOptions(module *tool.Module, opts Options) ([]byte, error) {
+	stream := tool.NewStream(nil)
+	if _, err := stream.Write(module.Magic); err != nil {
+		return nil, fmt.Errorf("module 2 wasm error: %w", err)
+	}
+	if _, err := stream.Write(module.Version); err != nil {
+		return nil, fmt.Errorf("module 2 wasm error: %w", err)
+	}
+	for _, section := range module.Sections {
+		if sec, ok := section.(*tool.CodeSec); ok && opts.Workers > 1 {
+			if err := generateCodeSection(sec, stream, opts.Workers); err != nil {
+				return nil, fmt.Errorf("module 2 wasm error: %w", err)
+			}
+			continue
+		}
+		if _, err := GenerateModuleSection(section, stream); err != nil {
+			return nil, fmt.Errorf("module 2 wasm error: %w", err)
 		}
 	}
 
 	return stream.Bytes(), nil
 }
 
+// generateCodeSection generates the code section, encoding function bodies
+// with up to `workers` goroutines.
+func generateCodeSection(sec *tool.CodeSec, stream *tool.Stream, workers int) error {
+	bodies := make([]*tool.Stream, len(sec.Entries))
+	err := tool.Parallel(len(sec.Entries), workers, func(i int) error {
+		bodies[i] = tool.NewStream(nil)
+		return generateCodeBody(sec, i, bodies[i])
+	})
+	if err != nil {
+		return fmt.Errorf("generate section error: %w", err)
+	}
+
+	payload := tool.NewStream(nil)
+	if _, err := tool.EncodeULEB128(uint32(len(bodies)), payload); err != nil {
+		return fmt.Errorf("generate section error: %w", err)
+	}
+	for _, body := range bodies {
+		if _, err := payload.Write(body.Bytes()); err != nil {
+			return fmt.Errorf("generate section error: %w", err)
+		}
+	}
+	if err := stream.WriteByte(J2W_SECTION_IDS[sec.SecName()]); err != nil {
+		return fmt.Errorf("generate section error: %w", err)
+	}
+	if _, err := tool.EncodeULEB128(uint32(payload.BytesWrote), stream); err != nil {
+		return fmt.Errorf("generate section error: %w", err)
+	}
+	if _, err := stream.Write(payload.Bytes()); err != nil {
+		return fmt.Errorf("generate section error: %w", err)
+	}
+	return nil
+}
+
+// generateCodeBody generates the function body at `i` of the code section,
+// copying the bytes of a body decoded lazily and neither decoded nor replaced
+// since.
+func generateCodeBody(sec *tool.CodeSec, i int, stream *tool.Stream) error {
+	if raw, ok := sec.RawBody(i); ok {
+		if _, err := tool.EncodeULEB128(uint32(len(raw)), stream); err != nil {
+			return err
+		}
+		_, err := stream.Write(raw)
+		return err
+	}
+	_, err := entryGen.Code(sec.Entries[i], stream)
+	return err
+}
+
 func GeneratePreramble(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
 	if stream == nil {
 		stream = tool.NewStream(nil)
@@ -53,87 +127,127 @@ func GenerateOP(op tool.OP, stream *tool.Stream) (*tool.Stream, error) {
 		stream = tool.NewStream(nil)
 	}
 
-	name := op.Name
-	if op.ReturnType != "" {
-		name = op.ReturnType + "." + name
+	name := op.FullName()
+	if name == "" {
+		return nil, fmt.Errorf("generate op error: unknown opcode %#x", op.Opcode)
 	}
-
-	if err := stream.WriteByte(J2W_OPCODES[name]); err != nil {
+	if err := stream.WriteByte(op.Opcode); err != nil {
 		return nil, fmt.Errorf("generate op error: %w", err)
 	}
 
-	immediateKey := op.Name
+	immediateKey := op.Name()
 	if immediateKey == "const" {
-		immediateKey = op.ReturnType
+		immediateKey = op.ReturnType()
 	}
 	immediates, exist := tool.OP_IMMEDIATES[immediateKey]
-	if exist {
-		switch immediates {
-		case "block_type":
-			if _, err := immeGen.BlockType(op.Immediates.(string), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "varuint32":
-			if _, err := immeGen.Varuint32(op.Immediates.(uint32), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "varint32":
-			if _, err := immeGen.Varint32(op.Immediates.(int32), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "varint64":
-			if _, err := immeGen.Varint64(op.Immediates.(int64), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "varuint1":
-			if _, err := immeGen.Varuint1(op.Immediates.(int8), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "uint32":
-			if _, err := immeGen.Uint32(op.Immediates.([]byte), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "uint64":
-			if _, err := immeGen.Uint64(op.Immediates.([]byte), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "call_indirect":
-			if _, err := immeGen.CallIndirect(op.Immediates.(tool.JSON), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "memory_immediate":
-			if _, err := immeGen.MemoryImmediate(op.Immediates.(tool.JSON), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		case "br_table":
-			if _, err := immeGen.BrTable(op.Immediates.(tool.JSON), stream); err != nil {
-				return nil, fmt.Errorf("generate op error: %w", err)
-			}
-		default:
-			return nil, fmt.Errorf("generate preramble error: invalid op immediate: %s", immediates)
+	if !exist {
+		return stream, nil
+	}
+
+	// operators built from the JSON form carry JSON immediates.
+	op, ok := tool.OPFromJSON(op)
+	if !ok {
+		return nil, fmt.Errorf("generate op error: invalid immediates of %s", name)
+	}
+
+	var err error
+	switch immediates {
+	case "block_type":
+		var imm string
+		if imm, ok = op.Immediates.(string); ok {
+			_, err = immeGen.BlockType(imm, stream)
+		}
+	case "varuint32":
+		var imm uint32
+		if imm, ok = op.Immediates.(uint32); ok {
+			_, err = immeGen.Varuint32(imm, stream)
+		}
+	case "varint32":
+		var imm int32
+		if imm, ok = op.Immediates.(int32); ok {
+			_, err = immeGen.Varint32(imm, stream)
+		}
+	case "varint64":
+		var imm int64
+		if imm, ok = op.Immediates.(int64); ok {
+			_, err = immeGen.Varint64(imm, stream)
+		}
+	case "varuint1":
+		var imm int8
+		if imm, ok = op.Immediates.(int8); ok {
+			_, err = immeGen.Varuint1(imm, stream)
+		}
+	case "uint32":
+		var imm []byte
+		if imm, ok = op.Immediates.([]byte); ok {
+			_, err = immeGen.Uint32(imm, stream)
 		}
+	case "uint64":
+		var imm []byte
+		if imm, ok = op.Immediates.([]byte); ok {
+			_, err = immeGen.Uint64(imm, stream)
+		}
+	case "call_indirect":
+		var imm tool.CallIndirect
+		if imm, ok = op.Immediates.(tool.CallIndirect); ok {
+			_, err = immeGen.CallIndirect(imm, stream)
+		}
+	case "memory_immediate":
+		var imm tool.MemoryImmediate
+		if imm, ok = op.Immediates.(tool.MemoryImmediate); ok {
+			_, err = immeGen.MemoryImmediate(imm, stream)
+		}
+	case "br_table":
+		var imm tool.BrTable
+		if imm, ok = op.Immediates.(tool.BrTable); ok {
+			_, err = immeGen.BrTable(imm, stream)
+		}
+	default:
+		return nil, fmt.Errorf("generate op error: invalid op immediate: %s", immediates)
+	}
+	if !ok {
+		return nil, fmt.Errorf("generate op error: invalid immediates of %s: %#v", name, op.Immediates)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate op error: %w", err)
 	}
 	return stream, nil
 }
 
-func GenerateSection(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
+// GenerateCodeBody generates a function body of the code section, starting
+// with its size.
+func GenerateCodeBody(body tool.CodeBody, stream *tool.Stream) (*tool.Stream, error) {
 	if stream == nil {
 		stream = tool.NewStream(nil)
 	}
+	return entryGen.Code(body, stream)
+}
+
+// GenerateSection generates a section from its JSON object form.
+func GenerateSection(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
+	section, err := tool.SectionFromJSON(j)
+	if err != nil {
+		return nil, fmt.Errorf("generate section error: %w", err)
+	}
+	return GenerateModuleSection(section, stream)
+}
 
-	var name string
-	nameinterf, exist := j["name"]
-	if exist {
-		name = nameinterf.(string)
+// GenerateModuleSection generates a typed section.
+func GenerateModuleSection(section tool.Section, stream *tool.Stream) (*tool.Stream, error) {
+	if stream == nil {
+		stream = tool.NewStream(nil)
 	}
+
+	name := section.SecName()
 	payload := tool.NewStream(nil)
 	err := stream.WriteByte(J2W_SECTION_IDS[name])
 	if err != nil {
 		return nil, fmt.Errorf("generate section error: %w", err)
 	}
 
-	if name == "custom" {
-		sectionName := j["section_name"].(string)
+	switch sec := section.(type) {
+	case *tool.CustomSec:
+		sectionName := sec.SectionName
 		if _, err := tool.EncodeULEB128(uint32(len(sectionName)), payload); err != nil {
 			return nil, fmt.Errorf("generate section error: %w", err)
 		}
@@ -142,130 +256,144 @@ func GenerateSection(j tool.JSON, stream *tool.Stream) (*tool.Stream, error) {
 			return nil, fmt.Errorf("generate section error: %w", err)
 		}
 
-		if sectionName == "name" {
-			custom := j["custom"].([]tool.CustomName)
+		switch custom := sec.Custom.(type) {
+		case []tool.CustomName:
 			if _, err := cusGen.CustomName(custom, payload); err != nil {
 				return nil, fmt.Errorf("generate section error: %w", err)
 			}
-		} else {
-			_, err = payload.Write([]byte(j["custom"].(string)))
+		case tool.Producers:
+			if _, err := cusGen.Producers(custom, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		case tool.TargetFeatures:
+			if _, err := cusGen.TargetFeatures(custom, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		case tool.SourceMappingURL:
+			if _, err := cusGen.URL(string(custom), payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		case tool.ExternalDebugInfo:
+			if _, err := cusGen.URL(string(custom), payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		case tool.Dylink:
+			if _, err := cusGen.Dylink(custom, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		case []byte:
+			_, err = payload.Write(custom)
 			if err != nil {
 				return nil, fmt.Errorf("generate section error: %w", err)
 			}
+		case string:
+			_, err = payload.Write([]byte(custom))
+			if err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("generate section error: invalid payload of custom section %s", sectionName)
+		}
+	case *tool.StartSec:
+		if _, err := tool.EncodeULEB128(sec.Index, payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+	case *tool.DataCountSec:
+		if _, err := tool.EncodeULEB128(sec.Count, payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+	case *tool.TypeSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Type(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
 		}
-	} else if name == "start" {
-		if _, err := tool.EncodeULEB128(j["index"].(uint32), payload); err != nil {
+	case *tool.ImportSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
 			return nil, fmt.Errorf("generate section error: %w", err)
 		}
-	} else {
-		ientries, exist := j["entries"]
-		if exist {
-			//fmt.Printf("Gen %v\n", name)
-			switch name {
-			case "type":
-				entries := ientries.([]tool.TypeEntry)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Type(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "import":
-				entries := ientries.([]tool.ImportEntry)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if err := entryGen.Import(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "function":
-				entries := ientries.([]uint32)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Function(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "table":
-				entries := ientries.([]tool.Table)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if err := entryGen.Table(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "memory":
-				entries := ientries.([]tool.MemLimits)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if err := entryGen.Memory(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "global":
-				entries := ientries.([]tool.GlobalEntry)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Global(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "export":
-				entries := ientries.([]tool.ExportEntry)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Export(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "element":
-				entries := ientries.([]tool.ElementEntry)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Element(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "code":
-				entries := ientries.([]tool.CodeBody)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Code(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			case "data":
-				entries := ientries.([]tool.DataSegment)
-				if _, err := tool.EncodeULEB128(uint32(len(entries)), payload); err != nil {
-					return nil, fmt.Errorf("generate section error: %w", err)
-				}
-				for _, entry := range entries {
-					if _, err := entryGen.Data(entry, payload); err != nil {
-						return nil, fmt.Errorf("generate section error: %w", err)
-					}
-				}
-			default:
-				panic(fmt.Sprintf("invalid section name: %s", name))
+		for _, entry := range sec.Entries {
+			if err := entryGen.Import(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.FuncSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Function(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.TableSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if err := entryGen.Table(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.MemSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if err := entryGen.Memory(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.GlobalSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Global(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.ExportSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Export(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.ElementSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Element(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.CodeSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for i := range sec.Entries {
+			if err := generateCodeBody(sec, i, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
+			}
+		}
+	case *tool.DataSec:
+		if _, err := tool.EncodeULEB128(uint32(len(sec.Entries)), payload); err != nil {
+			return nil, fmt.Errorf("generate section error: %w", err)
+		}
+		for _, entry := range sec.Entries {
+			if _, err := entryGen.Data(entry, payload); err != nil {
+				return nil, fmt.Errorf("generate section error: %w", err)
 			}
 		}
+	default:
+		return nil, fmt.Errorf("generate section error: invalid section %s", name)
 	}
 
 	// write the size of the payload.
@@ -6,6 +6,13 @@ var J2W_CUSTOM_NAME_TYPES = map[string]byte{
 	"local":    0x02,
 }
 
+var J2W_DYLINK_SUBSECTION_TYPES = map[string]byte{
+	"mem_info":    0x01,
+	"needed":      0x02,
+	"export_info": 0x03,
+	"import_info": 0x04,
+}
+
 var J2W_LANGUAGE_TYPES = map[string]byte{
 	"i32":        0x7f,
 	"i64":        0x7e,
@@ -65,10 +65,7 @@ func (typeGenerator) InitExpr(op tool.OP, stream *tool.Stream) error {
 	if _, err := GenerateOP(op, stream); err != nil {
 		return fmt.Errorf("type generator InitExpr: %w", err)
 	}
-	if _, err := GenerateOP(tool.OP{
-		Name: "end",
-		Type: "void",
-	}, stream); err != nil {
+	if _, err := GenerateOP(tool.NewOP("end", nil), stream); err != nil {
 		return fmt.Errorf("type generator InitExpr: %w", err)
 	}
 
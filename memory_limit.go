@@ -0,0 +1,73 @@
+package go_wasm_metering
+
+import (
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// pageSize is the size of a page of linear memory.
+const pageSize = 65536
+
+// limitMemory rejects memories with an initial size above `maxPages`, and data
+// segments outside of their initial pages or at offsets that are not
+// constant. With `capMaximum`, memories get a maximum size of at most
+// `maxPages` so they can not grow past it.
+func limitMemory(module *tool.Module, maxPages uint32, capMaximum bool) error {
+	if maxPages == 0 {
+		return fmt.Errorf("option CapMemoryMaximum needs MaxMemoryPages")
+	}
+	// initial size of the memories, imported first.
+	var initial []uint32
+
+	limit := func(limits *tool.MemLimits, name string) error {
+		if limits.Intial > maxPages {
+			return fmt.Errorf("%s: initial size %d pages exceeds the limit of %d pages", name, limits.Intial, maxPages)
+		}
+		if max, ok := limits.Maximum.(uint32); capMaximum && (!ok || max > maxPages) {
+			limits.Flags |= 1
+			limits.Maximum = maxPages
+		}
+		initial = append(initial, limits.Intial)
+		return nil
+	}
+
+	if sec := module.ImportSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			limits, ok := entry.Type.(tool.MemLimits)
+			if !ok || entry.Kind != "memory" {
+				continue
+			}
+			if err := limit(&limits, fmt.Sprintf("memory import %s.%s", entry.ModuleStr, entry.FieldStr)); err != nil {
+				return err
+			}
+			sec.Entries[i].Type = limits
+		}
+	}
+	if sec := module.MemSec(); sec != nil {
+		for i := range sec.Entries {
+			if err := limit(&sec.Entries[i], fmt.Sprintf("memory %d", len(initial))); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sec := module.DataSec(); sec != nil {
+		for i, segment := range sec.Entries {
+			// offsets from imported globals could place a segment anywhere.
+			offset, ok := segment.Offset.Immediates.(int32)
+			if !ok || segment.Offset.FullName() != "i32.const" {
+				return fmt.Errorf("data segment %d: offset %s is not a constant", i, segment.Offset.FullName())
+			}
+			if segment.Index >= uint32(len(initial)) {
+				return fmt.Errorf("data segment %d: unknown memory %d", i, segment.Index)
+			}
+			start := uint64(uint32(offset))
+			end := start + uint64(len(segment.Data))
+			if pages := initial[segment.Index]; end > uint64(pages)*pageSize {
+				return fmt.Errorf("data segment %d: bytes [%d, %d) outside of the %d initial pages of memory %d", i, start, end, pages, segment.Index)
+			}
+		}
+	}
+	return nil
+}
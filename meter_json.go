@@ -1,16 +1,23 @@
 package go_wasm_metering
 
 import (
+	"encoding/binary"
 	"fmt"
-	"reflect"
-	"strconv"
+	"math"
+	"sync"
 
-	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/pass"
 	"github.com/meshplus/go-wasm-metering/tool"
 )
 
 type Metering struct {
-	Opts Options
+	Opts    Options
+	GasCost uint64 // gas of all the charges injected by the last run of the pass.
+
+	schedule     *CostSchedule      // CostSchedule, or CostTable compiled on the first run.
+	importCosts  map[uint32]uint64  // cost of calls to imported functions from ImportCosts.
+	softFloatOps map[string]tool.OP // instructions replaced by soft float imports.
+	statsMu      sync.Mutex         // guards Opts.Stats while metering bodies concurrently.
 }
 
 var (
@@ -25,339 +32,458 @@ var (
 		"return":      {},
 		"loop":        {},
 	}
+
+	// branchOpcodes are the opcodes of branchOps.
+	branchOpcodes = func() (res [256]bool) {
+		for i := range res {
+			op := tool.OP{Opcode: byte(i)}
+			_, res[i] = branchOps[costName(op.ReturnType(), op.Name())]
+		}
+		return res
+	}()
+
+	// costNames maps memory operators to their key in the cost table, which
+	// names them as the MVP did.
+	costNames = map[string]string{
+		"size": "current_memory",
+		"grow": "grow_memory",
+	}
 )
 
-// meterJSON injects metering into a JSON output of Wasm2Json.
+// costName returns the key of an instruction in the cost table.
+func costName(returnType, name string) string {
+	if returnType == "memory" {
+		if key, ok := costNames[name]; ok {
+			return key
+		}
+	}
+	return name
+}
+
+// MeterJSON injects metering into a JSON output of Wasm2Json.
 func (m *Metering) MeterJSON(module []tool.JSON) ([]tool.JSON, uint64, error) {
-	// 1. add necessary `type` and `import` sections if and only if they don't exist.
-	if m.findSection(module, "type") == nil {
-		module = m.createSection(module, "type")
+	typedModule, err := tool.ModuleFromJSON(module)
+	if err != nil {
+		return nil, 0, err
 	}
-	if m.findSection(module, "import") == nil {
-		module = m.createSection(module, "import")
+	gasCost, err := m.MeterModule(typedModule)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	// 2. prepare
-	importEntry := tool.ImportEntry{
-		ModuleStr: m.Opts.ModuleStr,
-		FieldStr:  m.Opts.FieldStr,
-		Kind:      "function",
+	j, err := tool.ModuleToJSON(typedModule)
+	if err != nil {
+		return nil, 0, err
 	}
+	return j, gasCost, nil
+}
 
-	importType := tool.TypeEntry{
-		Form:   "func",
-		Params: []string{m.Opts.MeterType},
+// MeterModule runs the passes of the options on a module in place and
+// returns the gas of all the charges injected. The module is checked before
+// the passes change it, so that a module metering rejects is left as it is;
+// a pass of Options.Passes failing can leave it partly changed.
+func (m *Metering) MeterModule(module *tool.Module) (uint64, error) {
+	if err := m.checkModule(module); err != nil {
+		return 0, err
+	}
+	if err := pass.NewPipeline(m.Passes()...).Run(module); err != nil {
+		return 0, err
 	}
+	return m.GasCost, nil
+}
 
-	importCusName := tool.NameAssoc{
-		NameStr: fmt.Sprintf("%s.%s", m.Opts.ModuleStr, m.Opts.FieldStr),
+// Passes returns the passes of the options, the checks and rewrites asked
+// for and Options.Passes, followed by metering itself.
+func (m *Metering) Passes() []pass.Pass {
+	var passes []pass.Pass
+	if m.Opts.ImportPolicy != nil {
+		passes = append(passes, m.Opts.ImportPolicy)
 	}
+	if m.Opts.FloatPolicy != FloatsAllowed {
+		passes = append(passes, m.Opts.FloatPolicy)
+	}
+	if m.Opts.MaxMemoryPages != 0 || m.Opts.CapMemoryMaximum {
+		passes = append(passes, pass.Func("memory limit", func(module *tool.Module) error {
+			return limitMemory(module, m.Opts.MaxMemoryPages, m.Opts.CapMemoryMaximum)
+		}))
+	}
+	m.softFloatOps = nil
+	if m.Opts.SoftFloat {
+		passes = append(passes, pass.Func("soft float", func(module *tool.Module) error {
+			moduleStr := m.Opts.SoftFloatModule
+			if moduleStr == "" {
+				moduleStr = defaultSoftFloatModule
+			}
+			replaced, err := softFloat(module, moduleStr)
+			m.softFloatOps = replaced
+			return err
+		}))
+	}
+	passes = append(passes, m.Opts.Passes...)
+	return append(passes, m)
+}
 
-	var (
-		typeModule     tool.JSON
-		functionModule tool.JSON
-		funcIndex      int
-		newModule      = make([]tool.JSON, len(module))
-		gasCost        uint64
-	)
+// Name returns the name of the metering pass.
+func (m *Metering) Name() string {
+	return "metering"
+}
 
-	copy(newModule, module)
+// Run injects metering into a module in place and sets GasCost.
+func (m *Metering) Run(module *tool.Module) error {
+	gasCost, err := m.meterModule(module)
+	m.GasCost = gasCost
+	return err
+}
 
-	// 3. Insert module by module
-	for _, section := range newModule {
-		sectionName, exist := section["name"]
-		if !exist {
-			continue
+// meterModule injects metering into a module in place, once it is checked.
+func (m *Metering) meterModule(module *tool.Module) (uint64, error) {
+	if err := m.checkModule(module); err != nil {
+		return 0, err
+	}
+
+	// add necessary `type` and `import` sections if and only if they don't exist.
+	if module.TypeSec() == nil {
+		module.AddSection(&tool.TypeSec{Name: "type", Entries: []tool.TypeEntry{}})
+	}
+	if module.ImportSec() == nil {
+		module.AddSection(&tool.ImportSec{Name: "import", Entries: []tool.ImportEntry{}})
+	}
+
+	state := m.newMeterState(module)
+	for _, section := range module.Sections {
+		if err := m.meterSection(state, section); err != nil {
+			return 0, err
 		}
-		switch sectionName.(string) {
-		case "type":
-			var entries []tool.TypeEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]tool.TypeEntry)
-			}
-			importEntry.Type = uint32(len(entries))
-			entries = append(entries, importType)
-			section["entries"] = entries
-
-			// save for use for the code section.
-			typeModule = section
-		case "function":
-			// save for use for the code section.
-			functionModule = section
-		case "import":
-			var entries []tool.ImportEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]tool.ImportEntry)
-			}
-			for _, entry := range entries {
-				if entry.ModuleStr == m.Opts.ModuleStr && entry.FieldStr == m.Opts.FieldStr {
-					return nil, 0, fmt.Errorf("importing metering function is not allowed")
-				}
+	}
+	return state.gasCost, nil
+}
 
-				if entry.Kind == "function" {
-					funcIndex += 1
-				}
-			}
-			// append the metering import.
-			section["entries"] = append(entries, importEntry)
-		case "export":
-			var entries []tool.ExportEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]tool.ExportEntry)
-			}
-			for i, entry := range entries {
-				if entry.Kind == "function" && entry.Index >= uint32(funcIndex) {
-					entries[i].Index = entry.Index + 1
-				}
-			}
-		case "element":
-			var entries []tool.ElementEntry
-			ientries, exist := section["entries"]
-			if exist {
-				entries = ientries.([]tool.ElementEntry)
-			}
-			for i, entry := range entries {
-				// remap element indices.
-				newElements := make([]uint32, 0, len(entry.Elements))
-				for _, el := range entry.Elements {
-					if el >= uint32(funcIndex) {
-						el += 1
-					}
-					newElements = append(newElements, el)
-				}
-				entries[i].Elements = newElements
-			}
-		case "start":
-			index := section["index"].(uint32)
-			if index >= uint32(funcIndex) {
-				index += 1
-			}
-			section["index"] = index
-		case "code":
-			entries := section["entries"].([]tool.CodeBody)
-			funcEntries := functionModule["entries"].([]uint32)
-			typEntries := typeModule["entries"].([]tool.TypeEntry)
-			for i, entry := range entries {
-				typeIndex := funcEntries[i]
-				typ := typEntries[typeIndex]
-				cost := m.getCost(typ, m.Opts.CostTable["type"].(tool.JSON), DefaultCost)
-
-				entry, cost = m.meterCodeEntry(entry, m.Opts.CostTable["code"].(tool.JSON), m.Opts.MeterType, funcIndex, cost)
-				gasCost += cost
-				entries[i] = entry
+// meterState is what metering keeps from a section for the next ones.
+type meterState struct {
+	module        *tool.Module // sections metering reads: type, import and function.
+	importEntry   tool.ImportEntry
+	importType    tool.TypeEntry
+	importCusName tool.NameAssoc
+
+	typeSection     *tool.TypeSec
+	functionSection *tool.FuncSec
+	typeCosts       []uint64 // costs of the types of the type section.
+	funcIndex       int
+	gasCost         uint64
+}
+
+// checkModule checks what metering needs of a module: the options, no
+// metering import yet, the type of the functions and imports it prices, and
+// function bodies ending with `end`.
+func (m *Metering) checkModule(module *tool.Module) error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+
+	typeSection := module.TypeSec()
+	if section := module.ImportSec(); section != nil {
+		for _, entry := range section.Entries {
+			if entry.ModuleStr == m.Opts.ModuleStr && entry.FieldStr == m.Opts.FieldStr {
+				return fmt.Errorf("importing metering function is not allowed")
 			}
-		case "custom":
-			var customNames []tool.CustomName
-			sectionName, exist := section["section_name"]
-			if exist && sectionName == "name" {
-				iCustomNames, exist1 := section["custom"]
-				if exist1 {
-					customNames = iCustomNames.([]tool.CustomName)
-					for i, cusName := range customNames {
-						switch cusName.Kind {
-						case "function":
-							names := cusName.Names.([]tool.NameAssoc)
-							newNames := []tool.NameAssoc{}
-							for _, functionName := range names {
-								if functionName.Index >= uint32(funcIndex) {
-									if functionName.Index == uint32(funcIndex) {
-										importCusName.Index = uint32(funcIndex)
-										newNames = append(newNames, importCusName)
-									}
-									functionName.Index++
-								}
-								newNames = append(newNames, functionName)
-							}
-							customNames[i].Names = newNames
-						case "local":
-							names := cusName.Names.([]tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌)
-							newNames := []tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌{}
-							for _, functionLocals := range names {
-								if functionLocals.Index >= uint32(funcIndex) {
-									functionLocals.Index++
-								}
-							}
-							customNames[i].Names = newNames
-						}
-					}
+			if _, ok := m.Opts.ImportCosts[fmt.Sprintf("%s.%s", entry.ModuleStr, entry.FieldStr)]; ok && entry.Kind == "function" {
+				if err := checkImportType(entry, typeSection); err != nil {
+					return err
 				}
 			}
+		}
+	}
 
+	section := module.CodeSec()
+	if section == nil {
+		return nil
+	}
+	if err := section.Decode(); err != nil {
+		return err
+	}
+	functionSection := module.FuncSec()
+	if functionSection == nil || typeSection == nil {
+		return fmt.Errorf("code section without function or type section")
+	}
+	if len(section.Entries) != len(functionSection.Entries) {
+		return fmt.Errorf("function and code section have inconsistent lengths %d and %d", len(functionSection.Entries), len(section.Entries))
+	}
+	for i, entry := range section.Entries {
+		if functionSection.Entries[i] >= uint32(len(typeSection.Entries)) {
+			return fmt.Errorf("invalid type of function %d", i)
+		}
+		if err := checkBody(entry); err != nil {
+			return fmt.Errorf("function body %d: %w", i, err)
 		}
 	}
-	return newModule, gasCost, nil
+	return nil
 }
 
-func (m *Metering) findSection(module []tool.JSON, sectionName string) tool.JSON {
-	for _, section := range module {
-		if name, exist := section["name"]; exist {
-			if name.(string) == sectionName {
-				return section
-			}
+// prepare checks the meter type and sets the schedule from CostSchedule, or
+// from CostTable compiled, on the first run.
+func (m *Metering) prepare() error {
+	if _, ok := tool.Opcode(m.Opts.MeterType + ".const"); !ok {
+		return fmt.Errorf("invalid meter type %s", m.Opts.MeterType)
+	}
+	if m.schedule != nil {
+		return nil
+	}
+	schedule := m.Opts.CostSchedule
+	if schedule == nil {
+		var err error
+		if schedule, err = CompileCostTable(m.Opts.CostTable); err != nil {
+			return err
 		}
 	}
+	m.schedule = schedule
 	return nil
 }
 
-func (m *Metering) createSection(module []tool.JSON, sectionName string) []tool.JSON {
-	newSectionId := json2wasm.J2W_SECTION_IDS[sectionName]
-	for i, section := range module {
-		name, exist := section["name"]
-		if exist {
-			secId, exist := json2wasm.J2W_SECTION_IDS[name.(string)]
-			if exist && secId > 0 && newSectionId < secId {
-				rest := append([]tool.JSON{}, module[i:]...)
-				// insert the section at pos `i`
-				module = append(module[:i], tool.JSON{
-					"name": sectionName,
-				})
-				module = append(module, rest...)
-				break
-			}
-		}
+// checkImportType checks the type of an imported function.
+func checkImportType(entry tool.ImportEntry, typeSection *tool.TypeSec) error {
+	typeIndex, ok := entry.Type.(uint32)
+	if !ok || typeSection == nil || typeIndex >= uint32(len(typeSection.Entries)) {
+		return fmt.Errorf("invalid type of import %s.%s", entry.ModuleStr, entry.FieldStr)
 	}
-	return module
+	return nil
 }
 
-// meter code json========================================================================================
-// getCost returns the cost of an operation for the entry in a section from the cost table.
-func (m *Metering) getCost(j interface{}, costTable tool.JSON, defaultCost uint64) (cost uint64) {
-	if dc, exist := costTable["DEFAULT"]; exist {
-		defaultCost = uint64(dc.(int))
-	}
-	rval := reflect.ValueOf(j)
-	kind := rval.Type().Kind()
-	if kind == reflect.Slice {
-		for i := 0; i < rval.Len(); i++ {
-			cost += m.getCost(rval.Index(i).Interface(), costTable, 0)
-		}
-	} else if kind == reflect.Struct {
-		rtype := rval.Type()
-		for i := 0; i < rval.NumField(); i++ {
-			rv := rval.Field(i)
-			propCost, exist := costTable[tool.Lcfirst(rtype.Field(i).Name)]
-			if exist {
-				cost += m.getCost(rv.Interface(), propCost.(tool.JSON), defaultCost)
-			} else {
-				cost += defaultCost
-			}
-		}
-	} else if kind == reflect.String {
-		key := j.(string)
-		if key == "" {
-			return 0
+// checkBody checks the calls and branches of a function body, and that it
+// ends with `end`.
+func checkBody(body tool.CodeBody) error {
+	for i, op := range body.Code {
+		if _, ok := op.Immediates.(uint32); op.Name() == "call" && !ok {
+			return fmt.Errorf("call at %d: invalid immediates type %T", i, op.Immediates)
 		}
-		c, exist := costTable[key]
-		if exist {
-			cost = uint64(c.(int))
-		} else {
-			cost = defaultCost
+		if _, err := branchTargets(op, i); err != nil {
+			return err
 		}
-	} else {
-		cost = defaultCost
 	}
-	//fmt.Printf("json %#v cost %v\n", j, cost)
-	return
+	if n := len(body.Code); n == 0 || body.Code[n-1].FullName() != "end" {
+		return fmt.Errorf("END opcode expected")
+	}
+	return nil
 }
 
-// meterCodeEntry meters a single code entry (see tool.CodeBody).
-func (m *Metering) meterCodeEntry(entry tool.CodeBody, costTable tool.JSON, meterType string, meterFuncIndex int, cost uint64) (tool.CodeBody, uint64) {
-	getImmediateFromOP := func(name, opType string) string {
-		var immediatesKey string
-		if name == "const" {
-			immediatesKey = opType
-		} else {
-			immediatesKey = name
-		}
-		return tool.OP_IMMEDIATES[immediatesKey]
+func (m *Metering) newMeterState(module *tool.Module) *meterState {
+	m.importCosts = make(map[uint32]uint64)
+	return &meterState{
+		module: module,
+		importEntry: tool.ImportEntry{
+			ModuleStr: m.Opts.ModuleStr,
+			FieldStr:  m.Opts.FieldStr,
+			Kind:      "function",
+		},
+		importType: tool.TypeEntry{
+			Form:   "func",
+			Params: []string{m.Opts.MeterType},
+		},
+		importCusName: tool.NameAssoc{
+			NameStr: fmt.Sprintf("%s.%s", m.Opts.ModuleStr, m.Opts.FieldStr),
+		},
 	}
+}
 
-	meteringStatement := func(cost uint64, meteringImportIndex int) (ops []tool.OP) {
-		opsJson := tool.Text2Json(fmt.Sprintf("%s.const %v call %v", meterType, cost, meteringImportIndex))
-		for _, op := range opsJson {
-
-			oop := tool.OP{
-				Name: op["name"].(string),
+// meterSection injects metering into a section in place, the sections of
+// the module being metered in order.
+func (m *Metering) meterSection(state *meterState, s tool.Section) error {
+	switch section := s.(type) {
+	case *tool.TypeSec:
+		// reuse the type of the metering import if the module has it.
+		state.importEntry.Type = pass.AddType(state.module, state.importType)
+
+		// save for use for the code section.
+		state.typeSection = section
+	case *tool.FuncSec:
+		// save for use for the code section.
+		state.functionSection = section
+	case *tool.ImportSec:
+		for _, entry := range section.Entries {
+			if entry.ModuleStr == m.Opts.ModuleStr && entry.FieldStr == m.Opts.FieldStr {
+				return fmt.Errorf("importing metering function is not allowed")
 			}
 
-			// convert immediates.
-			imm := getImmediateFromOP(oop.Name, meterType)
-			if imm != "" {
-				opImm := op["immediates"]
-				switch imm {
-				case "varuint1":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 8)
-					oop.Immediates = int8(imme)
-				case "varuint32":
-					imme, _ := strconv.ParseUint(opImm.(string), 10, 32)
-					oop.Immediates = uint32(imme)
-				case "varint32":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 32)
-					oop.Immediates = int32(imme)
-				case "varint64":
-					imme, _ := strconv.ParseInt(opImm.(string), 10, 64)
-					oop.Immediates = int64(imme)
-				case "uint32":
-					oop.Immediates = opImm.([]byte)
-				case "uint64":
-					oop.Immediates = opImm.([]byte)
-				case "block_type":
-					oop.Immediates = opImm.(string)
-				case "br_table", "call_indirect", "memory_immediate":
-					oop.Immediates = opImm.(tool.JSON)
+			if entry.Kind == "function" {
+				if err := m.setImportCost(entry, uint32(state.funcIndex), state.typeSection); err != nil {
+					return err
 				}
+				state.funcIndex += 1
 			}
+		}
+		// append the metering import.
+		section.Entries = append(section.Entries, state.importEntry)
+	case *tool.ExportSec, *tool.ElementSec, *tool.StartSec:
+		return pass.RemapSection(section, state.shift)
+	case *tool.CodeSec:
+		if err := section.Decode(); err != nil {
+			return err
+		}
+		if err := m.prepareCode(state, len(section.Entries)); err != nil {
+			return err
+		}
+		costs := make([]uint64, len(section.Entries))
+		err := tool.Parallel(len(section.Entries), m.Opts.Workers, func(i int) error {
+			entry, cost, err := m.meterFunc(state, i, section.Entries[i])
+			section.Entries[i], costs[i] = entry, cost
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, cost := range costs {
+			state.gasCost += cost
+		}
+	case *tool.CustomSec:
+		if err := pass.RemapSection(section, state.shift); err != nil {
+			return err
+		}
+		state.nameImport(section)
+	}
+	return nil
+}
 
-			if rt, ok := op["returns"]; ok {
-				oop.ReturnType = rt.(string)
-			}
+// shift returns the index of a function once the metering import is
+// inserted.
+func (state *meterState) shift(index uint32) uint32 {
+	if index >= uint32(state.funcIndex) {
+		return index + 1
+	}
+	return index
+}
 
-			if rt, ok := op["type"]; ok {
-				oop.Type = rt.(string)
+// nameImport names the metering import in the function names of a name
+// section, before the function following it if that one is named.
+func (state *meterState) nameImport(section *tool.CustomSec) {
+	customNames, ok := section.Custom.([]tool.CustomName)
+	if !ok || section.SectionName != "name" {
+		return
+	}
+	for i, cusName := range customNames {
+		names, ok := cusName.Names.([]tool.NameAssoc)
+		if !ok || cusName.Kind != "function" {
+			continue
+		}
+		for j, name := range names {
+			if name.Index == uint32(state.funcIndex)+1 {
+				importCusName := state.importCusName
+				importCusName.Index = uint32(state.funcIndex)
+				newNames := append(append(append([]tool.NameAssoc{}, names[:j]...), importCusName), names[j:]...)
+				customNames[i].Names = newNames
+				break
 			}
-
-			ops = append(ops, oop)
 		}
+	}
+}
 
-		return
+// prepareCode checks there is a function entry for each of the `numCodes`
+// function bodies of the code section, and prices the types once for them.
+func (m *Metering) prepareCode(state *meterState, numCodes int) error {
+	if state.functionSection == nil || state.typeSection == nil {
+		return fmt.Errorf("code section without function or type section")
 	}
+	if numCodes != len(state.functionSection.Entries) {
+		return fmt.Errorf("function and code section have inconsistent lengths %d and %d", len(state.functionSection.Entries), numCodes)
+	}
+	state.typeCosts = make([]uint64, len(state.typeSection.Entries))
+	for i, typ := range state.typeSection.Entries {
+		state.typeCosts[i] = m.schedule.TypeCost(typ)
+	}
+	return nil
+}
 
-	remapOp := func(op *tool.OP, funcIndex int) {
-		if op.Name == "call" {
-			switch imm := op.Immediates.(type) {
-			case string:
-				rv, _ := strconv.ParseInt(imm, 10, 64)
-				if rv >= int64(funcIndex) {
-					rv += 1
-					op.Immediates = strconv.FormatInt(rv, 10)
-				}
-			case uint32:
-				if imm >= uint32(funcIndex) {
-					imm += 1
-					op.Immediates = imm
-				}
-			default:
-				panic(fmt.Sprintf("invalid immediates type: %v", imm))
-			}
+// meterFunc returns the function body at `i` in the code section metered,
+// and its cost. Function bodies can be metered concurrently.
+func (m *Metering) meterFunc(state *meterState, i int, entry tool.CodeBody) (tool.CodeBody, uint64, error) {
+	if state.functionSection.Entries[i] >= uint32(len(state.typeSection.Entries)) {
+		return tool.CodeBody{}, 0, fmt.Errorf("invalid type of function %d", i)
+	}
+	cost := state.typeCosts[state.functionSection.Entries[i]]
+
+	if err := pass.RemapBody(&entry, state.shift); err != nil {
+		return tool.CodeBody{}, 0, fmt.Errorf("function body %d: %w", i, err)
+	}
+	entry, cost, err := m.meterCodeEntry(entry, m.Opts.MeterType, state.funcIndex, cost)
+	if err != nil {
+		return tool.CodeBody{}, 0, fmt.Errorf("function body %d: %w", i, err)
+	}
+	return entry, cost, nil
+}
 
+// setImportCost records the cost of calls to an imported function at `index`
+// if ImportCosts prices it. Calls to soft float imports cost the instruction
+// they replace on top of the call otherwise.
+func (m *Metering) setImportCost(entry tool.ImportEntry, index uint32, typeSection *tool.TypeSec) error {
+	name := fmt.Sprintf("%s.%s", entry.ModuleStr, entry.FieldStr)
+	importCost, ok := m.Opts.ImportCosts[name]
+	if !ok {
+		if op, ok := m.softFloatOps[name]; ok {
+			m.importCosts[index] = m.schedule.OpCost(tool.NewOP("call", nil)) + m.schedule.OpCost(op)
 		}
+		return nil
 	}
+	if err := checkImportType(entry, typeSection); err != nil {
+		return err
+	}
+	params := uint64(len(typeSection.Entries[entry.Type.(uint32)].Params))
+	m.importCosts[index] = importCost.Cost + importCost.PerArg*params
+	return nil
+}
 
-	meterTheMeteringStatement := func() uint64 {
-		code := meteringStatement(0, meterFuncIndex)
-		// sum the operations cost
-		sum := uint64(0)
-		for _, op := range code {
-			sum += m.getCost(op.Name, costTable["code"].(tool.JSON), DefaultCost)
+// opCost returns the cost of an instruction, from ImportCosts for calls to
+// imported functions it prices.
+func (m *Metering) opCost(op tool.OP) uint64 {
+	if op.Name() == "call" {
+		if index, ok := op.Immediates.(uint32); ok {
+			if cost, ok := m.importCosts[index]; ok {
+				return cost
+			}
 		}
-		return sum
+	}
+	return m.schedule.OpCost(op)
+}
+
+// meteringStatement returns the instructions charging `cost` with the
+// metering import at `meteringImportIndex`.
+func meteringStatement(meterType string, cost uint64, meteringImportIndex int) []tool.OP {
+	charge := tool.NewOP(meterType+".const", nil)
+	switch meterType {
+	case "i32":
+		charge.Immediates = int32(cost)
+	case "i64":
+		charge.Immediates = int64(cost)
+	case "f32":
+		// f32.const, little-endian.
+		bytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(bytes, math.Float32bits(float32(cost)))
+		charge.Immediates = bytes
+	case "f64":
+		// f64.const, little-endian.
+		bytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(bytes, math.Float64bits(float64(cost)))
+		charge.Immediates = bytes
+	}
+	return []tool.OP{charge, tool.NewOP("call", uint32(meteringImportIndex))}
+}
+
+// meteringCost returns the cost of a metering statement.
+func (m *Metering) meteringCost(meterType string, meterFuncIndex int) uint64 {
+	code := meteringStatement(meterType, 0, meterFuncIndex)
+	// sum the operations cost
+	sum := uint64(0)
+	for _, op := range code {
+		sum += m.schedule.OpCost(op)
+	}
+	return sum
+}
+
+// meterCodeEntry meters a single code entry (see tool.CodeBody).
+func (m *Metering) meterCodeEntry(entry tool.CodeBody, meterType string, meterFuncIndex int, cost uint64) (tool.CodeBody, uint64, error) {
+	if m.Opts.LoopHeadersOnly {
+		entry, cost = m.meterLoopHeaders(entry, meterType, meterFuncIndex, cost)
+		return entry, cost, nil
 	}
 
 	var (
-		meteringCost = meterTheMeteringStatement()
+		meteringCost = m.meteringCost(meterType, meterFuncIndex)
 		code         = make([]tool.OP, len(entry.Code))
 		meteredCode  []tool.OP
 	)
@@ -366,35 +492,216 @@ func (m *Metering) meterCodeEntry(entry tool.CodeBody, costTable tool.JSON, mete
 	// create a code copy.
 	copy(code, entry.Code)
 
-	cost += m.getCost(entry.Locals, costTable["locals"].(tool.JSON), DefaultCost)
+	cost += m.schedule.LocalsCost(entry.Locals)
 	sum := uint64(0)
 
-	for len(code) > 0 {
-		i := 0
+	var (
+		segments [][]tool.OP
+		costs    []uint64
+		falls    []bool
+		// the segment of the last charge, and whether the code since then
+		// always runs straight through.
+		charged  int
+		straight bool
+	)
+	if m.Opts.MergeCharges {
+		var err error
+		if falls, err = fallthroughOps(code); err != nil {
+			return tool.CodeBody{}, 0, err
+		}
+	}
+	for pos := 0; pos < len(code); {
+		i := pos
 
-		// meter a segment of wasm code.
-		for {
+		// meter a segment of wasm code, up to a branch or the end.
+		for i < len(code) {
 			op := &code[i]
-			remapOp(op, meterFuncIndex)
-			cost += m.getCost(code[i].Name, costTable["code"].(tool.JSON), DefaultCost)
+			cost += m.opCost(code[i])
 			i += 1
-			if _, exist := branchOps[op.Name]; exist {
+			if branchOpcodes[op.Opcode] {
 				break
 			}
 		}
 
+		// fold the cost into the last charge when the segment always runs
+		// right after the code charged by it.
+		if falls != nil && cost != 0 && straight {
+			costs[charged] += cost
+			cost = 0
+			m.addStats(0, len(meteringStatement(meterType, 0, meterFuncIndex)))
+		}
+		if cost != 0 {
+			charged, straight = len(costs), true
+		}
+		for j := pos; j < i && falls != nil; j++ {
+			straight = straight && falls[j]
+		}
+
+		segments = append(segments, code[pos:i])
+		costs = append(costs, cost)
+		cost = 0
+		pos = i
+	}
+
+	for i, segment := range segments {
+		cost := costs[i]
+
 		// add the metering statement.
 		if cost != 0 {
 			// add the cost of metering
 			cost += meteringCost
-			ops := meteringStatement(cost, meterFuncIndex)
+			ops := meteringStatement(meterType, cost, meterFuncIndex)
 			meteredCode = append(meteredCode, ops...)
+			m.addStats(len(ops), 0)
 		}
 		sum += cost
 
-		meteredCode = append(meteredCode, code[:i]...)
-		code = code[i:]
-		cost = 0
+		meteredCode = append(meteredCode, segment...)
+	}
+
+	entry.Code = meteredCode
+	return entry, sum, nil
+}
+
+// addStats counts instructions injected and saved, if stats are asked for.
+func (m *Metering) addStats(injected, saved int) {
+	if m.Opts.Stats != nil {
+		m.statsMu.Lock()
+		defer m.statsMu.Unlock()
+		m.Opts.Stats.Injected += injected
+		m.Opts.Stats.Saved += saved
+	}
+}
+
+// fallthroughOps returns whether the code after each instruction of `code`
+// only runs right after it: true unless it is a branch, an `if`, an `else`,
+// `unreachable`, the `end` of an `if` or of a block a branch targets, or a
+// loop a branch jumps back to.
+func fallthroughOps(code []tool.OP) ([]bool, error) {
+	var (
+		res      = make([]bool, len(code))
+		openers  []int
+		targeted = make(map[int]bool)
+	)
+	target := func(depth uint32) {
+		if depth < uint32(len(openers)) {
+			targeted[openers[len(openers)-1-int(depth)]] = true
+		}
+	}
+	for i, op := range code {
+		switch op.Name() {
+		case "block", "loop", "if":
+			openers = append(openers, i)
+		case "end":
+			// every branch to a block is inside of it.
+			if len(openers) > 0 {
+				opener := openers[len(openers)-1]
+				openers = openers[:len(openers)-1]
+				switch code[opener].Name() {
+				case "block":
+					res[i] = !targeted[opener]
+				case "loop":
+					res[i] = true
+					res[opener] = !targeted[opener]
+				}
+			}
+		case "br", "br_if", "br_table":
+			depths, err := branchTargets(op, i)
+			if err != nil {
+				return nil, err
+			}
+			for _, depth := range depths {
+				target(depth)
+			}
+		}
+		switch op.Name() {
+		case "loop", "end", "if", "else", "br", "br_if", "br_table", "return", "unreachable":
+		default:
+			res[i] = true
+		}
+	}
+	return res, nil
+}
+
+// branchTargets returns the depths of the blocks the branch at `i` targets,
+// none for other instructions.
+func branchTargets(op tool.OP, i int) ([]uint32, error) {
+	switch op.Name() {
+	case "br", "br_if":
+		depth, ok := op.Immediates.(uint32)
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: invalid %s immediate", i, op.Name())
+		}
+		return []uint32{depth}, nil
+	case "br_table":
+		imm, ok := op.Immediates.(tool.BrTable)
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: invalid %s immediate", i, op.Name())
+		}
+		return append(append([]uint32{}, imm.Targets...), imm.DefaultTarget), nil
+	}
+	return nil, nil
+}
+
+// meterLoopHeaders meters a code entry with a charge at its start for all of
+// its instructions outside of loops, and a charge at the header of every
+// loop for all of the instructions of its body outside of nested loops.
+// Every instruction is charged whether it runs or not, which overcharges
+// the branches not taken and the code skipped by branches.
+func (m *Metering) meterLoopHeaders(entry tool.CodeBody, meterType string, meterFuncIndex int, cost uint64) (tool.CodeBody, uint64) {
+	var (
+		meteringCost = m.meteringCost(meterType, meterFuncIndex)
+		code         = make([]tool.OP, len(entry.Code))
+		meteredCode  []tool.OP
+		// costs of the function body, then of every loop in order.
+		costs = []uint64{cost + m.schedule.LocalsCost(entry.Locals)}
+		// the entry of costs of the enclosing loops, and whether every
+		// enclosing block is a loop.
+		loops  = []int{0}
+		blocks []bool
+	)
+
+	// create a code copy.
+	copy(code, entry.Code)
+
+	for i := range code {
+		op := &code[i]
+		if op.Name() == "end" && len(blocks) > 0 {
+			if blocks[len(blocks)-1] {
+				loops = loops[:len(loops)-1]
+			}
+			blocks = blocks[:len(blocks)-1]
+		}
+		costs[loops[len(loops)-1]] += m.opCost(*op)
+		switch op.Name() {
+		case "block", "if":
+			blocks = append(blocks, false)
+		case "loop":
+			blocks = append(blocks, true)
+			loops = append(loops, len(costs))
+			costs = append(costs, 0)
+		}
+	}
+
+	sum := uint64(0)
+	charge := func(cost uint64) {
+		if cost != 0 {
+			cost += meteringCost
+			ops := meteringStatement(meterType, cost, meterFuncIndex)
+			meteredCode = append(meteredCode, ops...)
+			m.addStats(len(ops), 0)
+		}
+		sum += cost
+	}
+
+	charge(costs[0])
+	loop := 1
+	for _, op := range code {
+		meteredCode = append(meteredCode, op)
+		if op.Name() == "loop" {
+			charge(costs[loop])
+			loop++
+		}
 	}
 
 	entry.Code = meteredCode
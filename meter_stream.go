@@ -0,0 +1,383 @@
+package go_wasm_metering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+)
+
+// MeterStream injects metering into WebAssembly binary code read from `r`
+// and writes the metered code to `w`, like MeterWASM without decoding the
+// whole module. Sections metering does not change, like data or debug info,
+// are copied byte for byte, and function bodies are decoded and metered one
+// at a time. The metered code section is held in memory until it is written,
+// since its size comes before it. Only the options of metering itself and
+// ImportPolicy are supported.
+func MeterStream(r io.Reader, w io.Writer, opts *Options) (uint64, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := checkStreamOptions(opts); err != nil {
+		return 0, err
+	}
+	metering, err := newMetring(*opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := metering.prepare(); err != nil {
+		return 0, err
+	}
+	module := &tool.Module{}
+	s := &meterStream{
+		m:      metering,
+		r:      bufio.NewReader(r),
+		w:      w,
+		module: module,
+		state:  metering.newMeterState(module),
+	}
+	if err := s.run(); err != nil {
+		return 0, err
+	}
+	metering.GasCost = s.state.gasCost
+	return metering.GasCost, nil
+}
+
+// checkStreamOptions returns an error for the options needing the whole
+// module.
+func checkStreamOptions(opts *Options) error {
+	unsupported := map[string]bool{
+		"FloatPolicy":    opts.FloatPolicy != FloatsAllowed,
+		"SoftFloat":      opts.SoftFloat,
+		"MaxMemoryPages": opts.MaxMemoryPages != 0 || opts.CapMemoryMaximum,
+		"Passes":         len(opts.Passes) != 0,
+		"CustomSections": len(opts.CustomSections) != 0,
+		"Validate":       opts.Validate,
+	}
+	for _, name := range []string{"FloatPolicy", "SoftFloat", "MaxMemoryPages", "Passes", "CustomSections", "Validate"} {
+		if unsupported[name] {
+			return fmt.Errorf("option %s is not supported by MeterStream", name)
+		}
+	}
+	return nil
+}
+
+type meterStream struct {
+	m      *Metering
+	r      *bufio.Reader
+	w      io.Writer
+	module *tool.Module // the type, import and function sections.
+	state  *meterState
+
+	last     string // name of the last known section.
+	hasCode  bool
+	numFuncs int
+}
+
+func (s *meterStream) run() error {
+	preramble := make([]byte, 8)
+	n, _ := io.ReadFull(s.r, preramble)
+	if err := wasm2json.CheckPreramble(preramble[:n]); err != nil {
+		return err
+	}
+	if !bytes.Equal(preramble[4:], wasm2json.VERSION) {
+		return fmt.Errorf("unknown binary version %x", preramble[4:])
+	}
+	if err := s.write(preramble); err != nil {
+		return err
+	}
+
+	for {
+		id, err := s.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size, rawSize, err := readULEB128(s.r)
+		if err != nil {
+			return err
+		}
+		header := tool.SectionHeader{Id: id, Name: wasm2json.W2J_SECTION_IDS[id], Size: size}
+		if header.Name == "" {
+			return fmt.Errorf("unknown section id: %d", header.Id)
+		}
+		if header.Name != "custom" {
+			order := tool.SECTION_ORDER[header.Name]
+			switch {
+			case s.last == header.Name:
+				return fmt.Errorf("duplicate %s section", header.Name)
+			case s.last != "" && order < tool.SECTION_ORDER[s.last]:
+				return fmt.Errorf("%s section out of order after %s section", header.Name, s.last)
+			}
+			s.last = header.Name
+			if err := s.addSections(order); err != nil {
+				return err
+			}
+		}
+
+		section := &io.LimitedReader{R: s.r, N: int64(size)}
+		switch header.Name {
+		case "custom":
+			err = s.custom(header, rawSize, section)
+		case "type", "import", "function", "export", "start", "element":
+			err = s.section(header, rawSize, section)
+		case "code":
+			err = s.code(section)
+		default:
+			if err = s.write(append([]byte{id}, rawSize...)); err == nil {
+				err = s.copy(header, section)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if section.N != 0 {
+			return fmt.Errorf("%s section size mismatch: declared %d bytes, decoded %d", header.Name, header.Size, int64(header.Size)-section.N)
+		}
+	}
+
+	if err := s.addSections(len(tool.SECTION_ORDER) + 1); err != nil {
+		return err
+	}
+	if s.numFuncs != 0 && !s.hasCode {
+		return fmt.Errorf("function and code section have inconsistent lengths %d and %d", s.numFuncs, 0)
+	}
+	return nil
+}
+
+// addSections writes the type and import sections metering needs before the
+// first known section of a greater `order`, if the module has none.
+func (s *meterStream) addSections(order int) error {
+	if s.module.TypeSec() == nil && order > tool.SECTION_ORDER["type"] {
+		sec := &tool.TypeSec{Name: "type", Entries: []tool.TypeEntry{}}
+		s.module.AddSection(sec)
+		if err := s.meter(sec); err != nil {
+			return err
+		}
+	}
+	if s.module.ImportSec() == nil && order > tool.SECTION_ORDER["import"] {
+		sec := &tool.ImportSec{Name: "import", Entries: []tool.ImportEntry{}}
+		s.module.AddSection(sec)
+		if err := s.meter(sec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// section meters and writes a known section other than the code section.
+func (s *meterStream) section(header tool.SectionHeader, rawSize []byte, r *io.LimitedReader) error {
+	payload, err := s.read(header, r, int(header.Size))
+	if err != nil {
+		return err
+	}
+	stream := tool.NewStream(payload)
+	section, err := wasm2json.ParseSection(stream, header)
+	if err != nil {
+		return err
+	}
+	if stream.BytesRead != int(header.Size) {
+		return fmt.Errorf("%s section size mismatch: declared %d bytes, decoded %d", header.Name, header.Size, stream.BytesRead)
+	}
+
+	switch sec := section.(type) {
+	case *tool.TypeSec:
+		s.module.AddSection(sec)
+	case *tool.ImportSec:
+		s.module.AddSection(sec)
+		if s.m.Opts.ImportPolicy != nil {
+			if err := s.m.Opts.ImportPolicy.Check(s.module); err != nil {
+				return err
+			}
+		}
+	case *tool.FuncSec:
+		// metering does not change the function section.
+		s.module.AddSection(sec)
+		s.numFuncs = len(sec.Entries)
+		if err := s.m.meterSection(s.state, sec); err != nil {
+			return err
+		}
+		return s.write(append(append([]byte{header.Id}, rawSize...), payload...))
+	}
+	return s.meter(section)
+}
+
+// custom copies a custom section, metering the name section.
+func (s *meterStream) custom(header tool.SectionHeader, rawSize []byte, r *io.LimitedReader) error {
+	nameLen, rawNameLen, err := readULEB128(r)
+	if err != nil {
+		return fmt.Errorf("unexpected end of custom section")
+	}
+	name, err := s.read(header, r, int(nameLen))
+	if err != nil {
+		return fmt.Errorf("unexpected end of custom section")
+	}
+	prefix := append(append([]byte{}, rawNameLen...), name...)
+	if string(name) != "name" {
+		if err := s.write(append(append([]byte{header.Id}, rawSize...), prefix...)); err != nil {
+			return err
+		}
+		return s.copy(header, r)
+	}
+
+	rest, err := s.read(header, r, int(header.Size)-len(prefix))
+	if err != nil {
+		return err
+	}
+	section, err := wasm2json.ParseSection(tool.NewStream(append(prefix, rest...)), header)
+	if err != nil {
+		return err
+	}
+	return s.meter(section)
+}
+
+// code meters the function bodies one at a time and writes the code section.
+func (s *meterStream) code(r *io.LimitedReader) error {
+	s.hasCode = true
+	header := tool.SectionHeader{Id: 10, Name: "code"}
+	numBodies, _, err := readULEB128(r)
+	if err != nil {
+		return err
+	}
+	if err := s.m.prepareCode(s.state, int(numBodies)); err != nil {
+		return err
+	}
+
+	payload := tool.NewStream(nil)
+	if _, err := tool.EncodeULEB128(numBodies, payload); err != nil {
+		return err
+	}
+	// bodies are read in batches of Workers, metered concurrently.
+	batch := s.m.Opts.Workers
+	if batch < 1 {
+		batch = 1
+	}
+	for start := 0; start < int(numBodies); start += batch {
+		bodies := make([][]byte, batch)
+		if rest := int(numBodies) - start; rest < batch {
+			bodies = bodies[:rest]
+		}
+		for j := range bodies {
+			size, rawSize, err := readULEB128(r)
+			if err != nil {
+				return err
+			}
+			body, err := s.read(header, r, int(size))
+			if err != nil {
+				return err
+			}
+			bodies[j] = append(rawSize, body...)
+		}
+
+		encoded := make([]*tool.Stream, len(bodies))
+		costs := make([]uint64, len(bodies))
+		err := tool.Parallel(len(bodies), s.m.Opts.Workers, func(j int) error {
+			entry, err := wasm2json.ParseCodeBody(tool.NewStream(bodies[j]), uint32(start+j))
+			if err != nil {
+				return err
+			}
+			if entry, costs[j], err = s.m.meterFunc(s.state, start+j, entry); err != nil {
+				return err
+			}
+			encoded[j], err = json2wasm.GenerateCodeBody(entry, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for j, body := range encoded {
+			if _, err := payload.Write(body.Bytes()); err != nil {
+				return err
+			}
+			s.state.gasCost += costs[j]
+		}
+	}
+
+	sectionHeader := tool.NewStream([]byte{header.Id})
+	if _, err := tool.EncodeULEB128(uint32(payload.BytesWrote), sectionHeader); err != nil {
+		return err
+	}
+	if err := s.write(sectionHeader.Bytes()); err != nil {
+		return err
+	}
+	return s.write(payload.Bytes())
+}
+
+// meter meters a section and writes it.
+func (s *meterStream) meter(section tool.Section) error {
+	if err := s.m.meterSection(s.state, section); err != nil {
+		return err
+	}
+	stream, err := json2wasm.GenerateModuleSection(section, nil)
+	if err != nil {
+		return err
+	}
+	return s.write(stream.Bytes())
+}
+
+// read reads `n` bytes of a section. The buffer grows as the bytes arrive,
+// so a size declared by the input is not allocated up front.
+func (s *meterStream) read(header tool.SectionHeader, r *io.LimitedReader, n int) ([]byte, error) {
+	if n < 0 || int64(n) > r.N {
+		return nil, fmt.Errorf("unexpected end of section %d", header.Id)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, fmt.Errorf("unexpected end of section %d", header.Id)
+	}
+	return buf.Bytes(), nil
+}
+
+// copy copies the rest of a section.
+func (s *meterStream) copy(header tool.SectionHeader, r *io.LimitedReader) error {
+	n := r.N
+	written, err := io.Copy(s.w, r)
+	if err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	if written != n {
+		return fmt.Errorf("unexpected end of section %d", header.Id)
+	}
+	return nil
+}
+
+func (s *meterStream) write(buf []byte) error {
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// readULEB128 reads an unsigned LEB128 number, returning its bytes too.
+func readULEB128(r io.Reader) (uint32, []byte, error) {
+	var (
+		u     uint32
+		shift uint
+		raw   []byte
+		b     = make([]byte, 1)
+	)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, fmt.Errorf("unexpected end of LEB128 number")
+		}
+		raw = append(raw, b[0])
+		switch {
+		case shift == 28 && b[0]&0x80 != 0:
+			return 0, nil, fmt.Errorf("integer representation too long")
+		case shift == 28 && b[0]&0x70 != 0:
+			return 0, nil, fmt.Errorf("integer too large")
+		}
+		u |= uint32(b[0]&0x7f) << shift
+		if b[0]&0x80 == 0 {
+			return u, raw, nil
+		}
+		shift += 7
+	}
+}
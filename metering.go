@@ -1,8 +1,12 @@
 package go_wasm_metering
 
 import (
+	"fmt"
+
 	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/pass"
 	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/validate"
 	"github.com/meshplus/go-wasm-metering/wasm2json"
 )
 
@@ -18,13 +22,80 @@ type Options struct {
 	ModuleStr string    // the import string for metering function.
 	FieldStr  string    // the field string for the metering function.
 	MeterType string    // the register type that is used to meter. Can be `i64`, `i32`, `f64`, `f32`.
+
+	// CostSchedule is a cost table compiled by CompileCostTable, used
+	// instead of CostTable if set so that runs can share it.
+	CostSchedule *CostSchedule
+
+	// LoopHeadersOnly charges only at the start of functions and at loop
+	// headers, each time for all of the instructions of the function or loop
+	// body, whether they run or not. It overcharges instructions but makes
+	// far fewer metering calls, which is enough to bound the execution.
+	LoopHeadersOnly bool
+
+	// MergeCharges folds the charge of a segment into the previous one when
+	// the segment always runs right after the code charged by it, such as
+	// the code after the `end` of a block no branch targets.
+	MergeCharges bool
+	Stats        *Stats // filled with the instructions injected, if set.
+
+	// ImportCosts price calls to imported functions by `module.field`,
+	// instead of the cost of `call` in the cost table.
+	ImportCosts map[string]ImportCost
+
+	ImportPolicy *ImportPolicy // imports allowed in the module to meter, any if nil.
+	FloatPolicy  FloatPolicy   // how floats are treated before metering.
+
+	// SoftFloat replaces float arithmetic, comparison and conversion
+	// instructions by calls to deterministic implementations imported from
+	// SoftFloatModule, `softfloat` if empty, named after the instruction,
+	// e.g. `f32.add`.
+	SoftFloat       bool
+	SoftFloatModule string
+
+	// MaxMemoryPages rejects memories with an initial size above it, and
+	// data segments outside of the initial size of their memory or at
+	// offsets that are not constant, unless 0. CapMemoryMaximum lowers the
+	// maximum size of memories to it, setting one if they have none, so they
+	// can never grow past it; it is an error without MaxMemoryPages.
+	MaxMemoryPages   uint32
+	CapMemoryMaximum bool
+
+	Passes []pass.Pass // passes run on the module to meter, after the checks and rewrites of the options.
+
+	// Workers meters and encodes function bodies with up to that many
+	// goroutines, one after the other if at most 1. The output does not
+	// depend on it.
+	Workers int
+
+	CustomSections []CustomSection // custom sections placed into the metered module, replacing those with the same name.
+	Validate       bool            // validate the module before and after metering.
+}
+
+// Stats count the instructions injected by metering.
+type Stats struct {
+	Injected int // instructions injected.
+	Saved    int // instructions not injected thanks to MergeCharges.
+}
+
+// ImportCost is the cost of a call to an imported function.
+type ImportCost struct {
+	Cost   uint64 // cost of the call.
+	PerArg uint64 // cost added for every parameter of the function.
+}
+
+// CustomSection is a custom section to be placed into a module.
+type CustomSection struct {
+	Name      string
+	Payload   []byte
+	Placement tool.Placement
 }
 
 // MeterWASM injects metering into WebAssembly binary code.
 // This func is the real exported function used by outer callers.
 func MeterWASM(wasm []byte, opts *Options) ([]byte, uint64, error) {
-	// 1. covert wasm to json
-	module, err := wasm2json.Wasm2Json(wasm)
+	// 1. covert wasm to module
+	module, err := wasm2json.Wasm2Module(wasm)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -37,18 +108,49 @@ func MeterWASM(wasm []byte, opts *Options) ([]byte, uint64, error) {
 	if err != nil {
 		return nil, 0, err
 	}
-	module, gasCost, err := metering.MeterJSON(module)
-	if err != nil {
+	pipeline := pass.NewPipeline()
+	if opts.Validate {
+		pipeline.Add(validatePass("validate input"))
+	}
+	pipeline.Add(metering.Passes()...)
+	pipeline.Add(pass.Func("custom sections", func(module *tool.Module) error {
+		for _, custom := range opts.CustomSections {
+			module.RemoveCustoms(custom.Name)
+			err := module.InsertCustom(&tool.CustomSec{
+				Name:        "custom",
+				SectionName: custom.Name,
+				Custom:      append([]byte{}, custom.Payload...),
+			}, custom.Placement)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	if opts.Validate {
+		pipeline.Add(validatePass("validate output"))
+	}
+	if err := pipeline.Run(module); err != nil {
 		return nil, 0, err
 	}
 
-	// 3. covert json to wasm
-	meteredWasm, err := json2wasm.Json2Wasm(module)
+	// 3. covert module to wasm
+	meteredWasm, err := json2wasm.Module2WasmWithOptions(module, json2wasm.Options{Workers: opts.Workers})
 	if err != nil {
 		return nil, 0, err
 	}
 
-	return meteredWasm, gasCost, nil
+	return meteredWasm, metering.GasCost, nil
+}
+
+// validatePass returns a pass validating the module, called `name`.
+func validatePass(name string) pass.Pass {
+	return pass.Func(name, func(module *tool.Module) error {
+		if err := validate.Module(module); err != nil {
+			return fmt.Errorf("%s error: %w", name, err)
+		}
+		return nil
+	})
 }
 
 func newMetring(opts Options) (*Metering, error) {
@@ -0,0 +1,162 @@
+package pass
+
+import (
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// AddType returns the index of a type equal to `typ` in the type section,
+// adding it, and the section, if there is none.
+func AddType(module *tool.Module, typ tool.TypeEntry) uint32 {
+	sec := module.TypeSec()
+	if sec == nil {
+		sec = &tool.TypeSec{Name: "type", Entries: []tool.TypeEntry{}}
+		module.AddSection(sec)
+	}
+	for i, entry := range sec.Entries {
+		if entry.Equal(typ) {
+			return uint32(i)
+		}
+	}
+	if typ.Form == "" {
+		typ.Form = "func"
+	}
+	sec.Entries = append(sec.Entries, typ)
+	return uint32(len(sec.Entries) - 1)
+}
+
+// InsertFuncImport imports a function of type `typ` after the imported
+// functions and returns its index. The functions defined by the module move
+// up by one in the function index space.
+func InsertFuncImport(module *tool.Module, moduleStr, fieldStr string, typ tool.TypeEntry) (uint32, error) {
+	if err := module.DecodeCode(); err != nil {
+		return 0, err
+	}
+	typeIndex := AddType(module, typ)
+	sec := module.ImportSec()
+	if sec == nil {
+		sec = &tool.ImportSec{Name: "import", Entries: []tool.ImportEntry{}}
+		module.AddSection(sec)
+	}
+	index := uint32(module.ImportedFuncs())
+	err := RemapFuncs(module, func(i uint32) uint32 {
+		if i >= index {
+			return i + 1
+		}
+		return i
+	})
+	if err != nil {
+		return 0, err
+	}
+	sec.Entries = append(sec.Entries, tool.ImportEntry{
+		ModuleStr: moduleStr,
+		FieldStr:  fieldStr,
+		Kind:      "function",
+		Type:      typeIndex,
+	})
+	return index, nil
+}
+
+// AddGlobal appends a global to the module and returns its index in the
+// global index space.
+func AddGlobal(module *tool.Module, global tool.GlobalEntry) uint32 {
+	sec := module.GlobalSec()
+	if sec == nil {
+		sec = &tool.GlobalSec{Name: "global", Entries: []tool.GlobalEntry{}}
+		module.AddSection(sec)
+	}
+	imported := 0
+	if imports := module.ImportSec(); imports != nil {
+		for _, entry := range imports.Entries {
+			if entry.Kind == "global" {
+				imported++
+			}
+		}
+	}
+	sec.Entries = append(sec.Entries, global)
+	return uint32(imported + len(sec.Entries) - 1)
+}
+
+// RemapFuncs replaces the index of every function referenced by the module,
+// in calls, exports, elements, the start section and function and local
+// names, by `remap` of it. Function bodies decoded lazily are decoded.
+func RemapFuncs(module *tool.Module, remap func(index uint32) uint32) error {
+	for _, section := range module.Sections {
+		if err := RemapSection(section, remap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemapSection replaces the index of every function referenced by a section
+// by `remap` of it, see RemapFuncs, for tools handling a section at a time.
+func RemapSection(s tool.Section, remap func(index uint32) uint32) error {
+	switch section := s.(type) {
+	case *tool.ExportSec:
+		for i := range section.Entries {
+			if section.Entries[i].Kind == "function" {
+				section.Entries[i].Index = remap(section.Entries[i].Index)
+			}
+		}
+	case *tool.ElementSec:
+		for i := range section.Entries {
+			elements := make([]uint32, len(section.Entries[i].Elements))
+			for j, index := range section.Entries[i].Elements {
+				elements[j] = remap(index)
+			}
+			section.Entries[i].Elements = elements
+		}
+	case *tool.StartSec:
+		section.Index = remap(section.Index)
+	case *tool.CodeSec:
+		if err := section.Decode(); err != nil {
+			return err
+		}
+		for i := range section.Entries {
+			if err := RemapBody(&section.Entries[i], remap); err != nil {
+				return fmt.Errorf("function body %d: %w", i, err)
+			}
+		}
+	case *tool.CustomSec:
+		if section.SectionName != "name" {
+			return nil
+		}
+		customNames, ok := section.Custom.([]tool.CustomName)
+		if !ok {
+			return nil
+		}
+		for _, cusName := range customNames {
+			switch names := cusName.Names.(type) {
+			case []tool.NameAssoc:
+				if cusName.Kind == "function" {
+					for i := range names {
+						names[i].Index = remap(names[i].Index)
+					}
+				}
+			case []tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌:
+				for i := range names {
+					names[i].Index = remap(names[i].Index)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RemapBody replaces the index of every function called by a function body
+// by `remap` of it.
+func RemapBody(body *tool.CodeBody, remap func(index uint32) uint32) error {
+	for i, op := range body.Code {
+		if op.Name() != "call" {
+			continue
+		}
+		index, ok := op.Immediates.(uint32)
+		if !ok {
+			return fmt.Errorf("call at %d: invalid immediates type %T", i, op.Immediates)
+		}
+		body.Code[i].Immediates = remap(index)
+	}
+	return nil
+}
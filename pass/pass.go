@@ -0,0 +1,51 @@
+// Package pass runs ordered transformations of the module IR, like metering,
+// with helpers for the changes they commonly make.
+package pass
+
+import "github.com/meshplus/go-wasm-metering/tool"
+
+// Pass checks or transforms a module in place.
+type Pass interface {
+	Name() string
+	Run(module *tool.Module) error
+}
+
+type funcPass struct {
+	name string
+	run  func(module *tool.Module) error
+}
+
+func (p funcPass) Name() string                  { return p.name }
+func (p funcPass) Run(module *tool.Module) error { return p.run(module) }
+
+// Func returns a Pass called `name` running `run`.
+func Func(name string, run func(module *tool.Module) error) Pass {
+	return funcPass{name: name, run: run}
+}
+
+// Pipeline runs passes in order.
+type Pipeline struct {
+	Passes []Pass
+}
+
+// NewPipeline returns a Pipeline of the passes.
+func NewPipeline(passes ...Pass) *Pipeline {
+	return &Pipeline{Passes: passes}
+}
+
+// Add appends passes to the pipeline.
+func (p *Pipeline) Add(passes ...Pass) *Pipeline {
+	p.Passes = append(p.Passes, passes...)
+	return p
+}
+
+// Run runs the passes on a module in order. It stops at the first error and
+// returns it as is.
+func (p *Pipeline) Run(module *tool.Module) error {
+	for _, pass := range p.Passes {
+		if err := pass.Run(module); err != nil {
+			return err
+		}
+	}
+	return nil
+}
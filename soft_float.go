@@ -0,0 +1,81 @@
+package go_wasm_metering
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/meshplus/go-wasm-metering/pass"
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+const defaultSoftFloatModule = "softfloat"
+
+// SOFT_FLOAT_KEPT are the float instructions SoftFloat keeps, which only
+// move bits.
+var SOFT_FLOAT_KEPT = map[string]bool{
+	"f32.const": true, "f64.const": true,
+	"f32.load": true, "f64.load": true,
+	"f32.store": true, "f64.store": true,
+	"f32.reinterpret_i32": true, "f64.reinterpret_i64": true,
+	"i32.reinterpret_f32": true, "i64.reinterpret_f64": true,
+}
+
+// softFloat replaces the float arithmetic, comparison and conversion
+// instructions of a module by calls to functions imported from `moduleStr`,
+// named after the instruction, e.g. `f32.add`, with its signature. It returns
+// the name of the instruction replaced by each import, by `module.field`.
+func softFloat(module *tool.Module, moduleStr string) (map[string]tool.OP, error) {
+	if err := module.DecodeCode(); err != nil {
+		return nil, err
+	}
+	used := make(map[string]tool.OP)
+	if sec := module.CodeSec(); sec != nil {
+		for _, body := range sec.Entries {
+			for _, op := range body.Code {
+				name := op.FullName()
+				if _, ok := tool.OP_SIGNATURES[name]; ok && usesFloat(op) && !SOFT_FLOAT_KEPT[name] {
+					used[name] = tool.OP{Opcode: op.Opcode}
+				}
+			}
+		}
+	}
+	if len(used) == 0 {
+		return nil, nil
+	}
+	fields := make([]string, 0, len(used))
+	for name := range used {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+
+	if sec := module.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if _, ok := used[entry.FieldStr]; ok && entry.ModuleStr == moduleStr {
+				return nil, fmt.Errorf("importing soft float function %s.%s is not allowed", entry.ModuleStr, entry.FieldStr)
+			}
+		}
+	}
+
+	// the imports are added in the order of their names.
+	indices := make(map[string]uint32)
+	replaced := make(map[string]tool.OP)
+	for _, field := range fields {
+		sig := tool.OP_SIGNATURES[field]
+		typ := tool.TypeEntry{Form: "func", Params: sig.Params, Returns: sig.Results}
+		index, err := pass.InsertFuncImport(module, moduleStr, field, typ)
+		if err != nil {
+			return nil, err
+		}
+		indices[field] = index
+		replaced[fmt.Sprintf("%s.%s", moduleStr, field)] = used[field]
+	}
+
+	for _, body := range module.CodeSec().Entries {
+		for i, op := range body.Code {
+			if index, ok := indices[op.FullName()]; ok {
+				body.Code[i] = tool.NewOP("call", index)
+			}
+		}
+	}
+	return replaced, nil
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/analyze"
+	"github.com/meshplus/go-wasm-metering/interp"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+const analyzeWat = `
+(module
+  (import "env" "log" (func $log (param i32)))
+  (type $unary (func (param i32) (result i32)))
+  (table funcref (elem $double $square))
+  (func $double (param i32) (result i32) (i32.add (local.get 0) (local.get 0)))
+  (func $square (param i32) (result i32) (i32.mul (local.get 0) (local.get 0)))
+  (func (export "abs") (param i32) (result i32)
+    (if (result i32) (i32.lt_s (local.get 0) (i32.const 0))
+      (then (i32.sub (i32.const 0) (local.get 0)))
+      (else (call $log (local.get 0)) (local.get 0))))
+  (func (export "early") (param i32) (result i32)
+    (block (br_if 0 (local.get 0)) (return (call $square (i32.const 3))))
+    (i32.const 1))
+  (func (export "apply") (param i32 i32) (result i32)
+    (call_indirect (type $unary) (local.get 1) (local.get 0)))
+  (func (export "once") (loop (nop)))
+  (func (export "sum") (param $n i32) (result i32) (local $acc i32)
+    (block
+      (loop
+        (br_if 1 (i32.eqz (local.get $n)))
+        (local.set $acc (i32.add (local.get $acc) (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br 0)))
+    (local.get $acc))
+  (func $even (export "even") (param i32) (result i32)
+    (if (result i32) (i32.eqz (local.get 0))
+      (then (i32.const 1))
+      (else (call $odd (i32.sub (local.get 0) (i32.const 1))))))
+  (func $odd (param i32) (result i32)
+    (if (result i32) (i32.eqz (local.get 0))
+      (then (i32.const 0))
+      (else (call $even (i32.sub (local.get 0) (i32.const 1))))))
+  (func (export "callsSum") (result i32) (call 7 (i32.const 3))))`
+
+func TestAnalyzeExports(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(analyzeWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+	meteredWasm, _, err := metering.MeterWASM(wasm, &metering.Options{Validate: true})
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	bounds, err := analyze.Exports(module, analyze.Options{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, bounds, 7)
+
+	for _, name := range []string{"abs", "early", "apply", "once"} {
+		assert.True(t, bounds[name].Bounded(), name)
+		assert.True(t, bounds[name].Gas > 0, name)
+	}
+	assert.True(t, bounds["sum"].Loop)
+	assert.False(t, bounds["sum"].Recursion)
+	assert.True(t, bounds["callsSum"].Loop)
+	assert.True(t, bounds["even"].Recursion)
+	assert.False(t, bounds["even"].Bounded())
+
+	// the bound is the most gas used by a run of the function.
+	var used uint64
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				used += args[0]
+				return nil, nil
+			}},
+			"env": {"log": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return nil, nil
+			}},
+		},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	for _, c := range []struct {
+		name string
+		runs [][]uint64
+	}{
+		{"abs", [][]uint64{{interp.I32(-5)}, {5}}},
+		{"early", [][]uint64{{0}, {1}}},
+		{"apply", [][]uint64{{0, 4}, {1, 4}}},
+		{"once", [][]uint64{nil}},
+	} {
+		var max uint64
+		for _, args := range c.runs {
+			used = 0
+			_, err := inst.Call(c.name, args...)
+			assert.Nil(t, err, c.name)
+			assert.True(t, used <= bounds[c.name].Gas, c.name)
+			if used > max {
+				max = used
+			}
+		}
+		assert.Equal(t, max, bounds[c.name].Gas, c.name)
+	}
+}
+
+func TestAnalyzeErrors(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (import "metering" "usegas" (func $usegas (param i64)))
+  (func (export "f") (param i64) (call $usegas (local.get 0))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, err = analyze.Exports(module, analyze.Options{})
+	assert.EqualError(t, err, "function 1: instruction 1: metering call without a constant charge")
+
+	// without the metering import, nothing is charged.
+	module, err = wat.Wat2Module(`(module (func (export "f") (call 0)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	bounds, err := analyze.Exports(module, analyze.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, analyze.Bound{Func: 0, Recursion: true}, bounds["f"])
+
+	// immediates of the wrong type are errors, not panics.
+	for _, c := range []struct {
+		text string
+		imm  interface{}
+		err  string
+	}{
+		{`(func (call 0))`, "0", "function 0: instruction 0: invalid call immediate"},
+		{`(type (func)) (table 0 funcref) (func (call_indirect (type 0) (i32.const 0)))`, uint32(0), "function 0: instruction 1: invalid call_indirect immediate"},
+		{`(func (block (br 0)))`, int32(0), "function 0: instruction 1: invalid br immediate"},
+		{`(func (block (br_table 0 (i32.const 0))))`, uint32(0), "function 0: instruction 2: invalid br_table immediate"},
+	} {
+		module, err := wat.Wat2Module("(module "+c.text+")", wat.ParseOptions{})
+		if !assert.Nil(t, err, c.text) {
+			continue
+		}
+		code := module.CodeSec().Entries[0].Code
+		for i := range code {
+			if strings.Contains(c.err, "invalid "+code[i].Name()+" ") {
+				code[i].Immediates = c.imm
+			}
+		}
+		_, err = analyze.Funcs(module, analyze.Options{})
+		assert.EqualError(t, err, c.err, c.text)
+	}
+}
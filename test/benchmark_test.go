@@ -0,0 +1,194 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+)
+
+func readLedger(b *testing.B) []byte {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "ledger_test_gc.wasm"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return wasm
+}
+
+func newMetering() *metering.Metering {
+	return &metering.Metering{
+		Opts: metering.Options{
+			CostTable: metering.DefaultCostTable,
+			ModuleStr: defaultModuleStr,
+			FieldStr:  defaultFieldStr,
+			MeterType: defaultMeterType,
+		},
+	}
+}
+
+// BenchmarkMeterJSON meters the JSON form of a module with the map based
+// metering that predates the typed module, see legacyMetering. Decoding is
+// not timed.
+func BenchmarkMeterJSON(b *testing.B) {
+	wasm := readLedger(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		module, err := wasm2json.Wasm2Json(wasm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m := &legacyMetering{Opts: newMetering().Opts}
+		b.StartTimer()
+
+		if _, _, err := m.MeterJSON(module); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMeterModule meters the typed module. Decoding is not timed.
+func BenchmarkMeterModule(b *testing.B) {
+	wasm := readLedger(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		module, err := wasm2json.Wasm2Module(wasm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		m := newMetering()
+		b.StartTimer()
+
+		if _, err := m.MeterModule(module); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWasm2Json(b *testing.B) {
+	wasm := readLedger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := wasm2json.Wasm2Json(wasm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWasm2Module(b *testing.B) {
+	wasm := readLedger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := wasm2json.Wasm2Module(wasm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWasm2ModuleLazy decodes a module without its function bodies.
+func BenchmarkWasm2ModuleLazy(b *testing.B) {
+	wasm := readLedger(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// manyFuncs returns a module of `n` functions, each summing up to its
+// argument in a loop.
+func manyFuncs(b *testing.B, n int) []byte {
+	var text strings.Builder
+	text.WriteString("(module\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&text, `  (func (export "f%d") (param $n i32) (result i32) (local $acc i32)
+    (block
+      (loop
+        (br_if 1 (i32.eqz (local.get $n)))
+        (local.set $acc (i32.add (local.get $acc) (i32.mul (local.get $n) (i32.const %d))))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br 0)))
+    (local.get $acc))
+`, i, i)
+	}
+	text.WriteString(")")
+	wasm, err := wat.Wat2Wasm(text.String(), wat.ParseOptions{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return wasm
+}
+
+// BenchmarkMeterWorkers meters and encodes a module of thousands of
+// functions with more and more workers, decoding it outside of the timer.
+func BenchmarkMeterWorkers(b *testing.B) {
+	wasm := manyFuncs(b, 5000)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				module, err := wasm2json.Wasm2Module(wasm)
+				if err != nil {
+					b.Fatal(err)
+				}
+				m := newMetering()
+				m.Opts.Workers = workers
+				b.StartTimer()
+
+				if _, err := m.MeterModule(module); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := json2wasm.Module2WasmWithOptions(module, json2wasm.Options{Workers: workers}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCostTable prices the instructions of a module by walking the cost
+// table with reflection and with the compiled schedule.
+func BenchmarkCostTable(b *testing.B) {
+	module, err := wasm2json.Wasm2Module(readLedger(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ops := metering.DefaultCostTable["code"].(tool.JSON)["code"].(tool.JSON)
+	schedule, err := metering.CompileCostTable(metering.DefaultCostTable)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("reflection", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, body := range module.CodeSec().Entries {
+				for _, op := range body.Code {
+					tableCost(op.Name(), ops, metering.DefaultCost)
+				}
+			}
+		}
+	})
+	b.Run("schedule", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, body := range module.CodeSec().Entries {
+				for _, op := range body.Code {
+					schedule.OpCost(op)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostSchedule(t *testing.T) {
+	custom := tool.JSON{
+		"type": tool.JSON{
+			"DEFAULT": 3,
+			"form":    tool.JSON{"func": 2},
+			"params":  tool.JSON{"DEFAULT": 1, "i64": 5},
+		},
+		"code": tool.JSON{
+			"locals": tool.JSON{
+				"DEFAULT": 2,
+				"type":    tool.JSON{"i32": 7},
+			},
+			"code": tool.JSON{"DEFAULT": 4, "call": 9, "get_local": 0},
+		},
+	}
+	empty := tool.JSON{"type": tool.JSON{}, "code": tool.JSON{"locals": tool.JSON{}, "code": tool.JSON{}}}
+
+	dir := path.Join("testdata", "in", "wasm")
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, table := range []tool.JSON{metering.DefaultCostTable, custom, empty} {
+		schedule, err := metering.CompileCostTable(table)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		code := table["code"].(tool.JSON)
+		for _, file := range files {
+			wasm, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+			assert.Nil(t, err)
+			module, err := wasm2json.Wasm2Module(wasm)
+			assert.Nil(t, err)
+			if sec := module.TypeSec(); sec != nil {
+				for _, typ := range sec.Entries {
+					assert.Equal(t, tableCost(typ, table["type"].(tool.JSON), metering.DefaultCost), schedule.TypeCost(typ), file.Name())
+				}
+			}
+			if sec := module.CodeSec(); sec != nil {
+				for _, body := range sec.Entries {
+					assert.Equal(t, tableCost(body.Locals, code["locals"].(tool.JSON), metering.DefaultCost), schedule.LocalsCost(body.Locals), file.Name())
+					for _, op := range body.Code {
+						assert.Equal(t, tableCost(opCostKey(op), code["code"].(tool.JSON), metering.DefaultCost), schedule.OpCost(op), file.Name())
+					}
+				}
+			}
+		}
+		for opcode, name := range wasm2json.W2J_OPCODES {
+			if wasm2json.W2J_UNSUPPORTED_OPCODES[opcode] {
+				continue
+			}
+			op, err := wasm2json.ParseOp(tool.NewStream([]byte{opcode, 0, 0, 0, 0, 0, 0, 0, 0, 0}))
+			assert.Nil(t, err, name)
+			assert.Equal(t, tableCost(opCostKey(op), code["code"].(tool.JSON), metering.DefaultCost), schedule.OpcodeCost(opcode), name)
+			assert.Equal(t, schedule.OpcodeCost(opcode), schedule.OpCost(op), name)
+		}
+		assert.Equal(t, tableCost("unknown", code["code"].(tool.JSON), metering.DefaultCost), schedule.OpCost(tool.OP{Opcode: 0x06}))
+	}
+
+	_, err = metering.CompileCostTable(tool.JSON{"code": tool.JSON{"code": tool.JSON{"add": "x"}}})
+	assert.EqualError(t, err, "invalid cost table: code.code.add: invalid cost x")
+	_, err = metering.CompileCostTable(tool.JSON{"type": 1})
+	assert.EqualError(t, err, "invalid cost table: type: type is not a table")
+}
+
+// opCostKey returns the key of an instruction in the cost table, which names
+// the memory operators as the MVP did.
+func opCostKey(op tool.OP) string {
+	if op.ReturnType() == "memory" {
+		switch op.Name() {
+		case "size":
+			return "current_memory"
+		case "grow":
+			return "grow_memory"
+		}
+	}
+	return op.Name()
+}
+
+// tableCost returns the cost of a value by walking the cost table along it:
+// strings are looked up, slices sum the costs of their elements and structs
+// those of their fields, by their snake case name. Values missing from a
+// table cost its `DEFAULT`. It is how metering priced values before
+// CostSchedule.
+func tableCost(j interface{}, costTable tool.JSON, defaultCost uint64) (cost uint64) {
+	if dc, exist := costTable["DEFAULT"]; exist {
+		defaultCost = uint64(dc.(int))
+	}
+	rval := reflect.ValueOf(j)
+	kind := rval.Type().Kind()
+	if kind == reflect.Slice {
+		for i := 0; i < rval.Len(); i++ {
+			cost += tableCost(rval.Index(i).Interface(), costTable, 0)
+		}
+	} else if kind == reflect.Struct {
+		rtype := rval.Type()
+		for i := 0; i < rval.NumField(); i++ {
+			rv := rval.Field(i)
+			propCost, exist := costTable[tool.Lcfirst(rtype.Field(i).Name)]
+			if exist {
+				cost += tableCost(rv.Interface(), propCost.(tool.JSON), defaultCost)
+			} else {
+				cost += defaultCost
+			}
+		}
+	} else if kind == reflect.String {
+		key := j.(string)
+		if key == "" {
+			return 0
+		}
+		c, exist := costTable[key]
+		if exist {
+			cost = uint64(c.(int))
+		} else {
+			cost = defaultCost
+		}
+	} else {
+		cost = defaultCost
+	}
+	return
+}
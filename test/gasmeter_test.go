@@ -0,0 +1,109 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/gasmeter"
+	"github.com/meshplus/go-wasm-metering/interp"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGasMeter(t *testing.T) {
+	m := gasmeter.NewGasMeter(100)
+	assert.Nil(t, m.Consume(60))
+	assert.Equal(t, uint64(60), m.Used())
+	assert.Equal(t, uint64(40), m.Remaining())
+
+	m.Refund(10)
+	assert.Equal(t, uint64(50), m.Used())
+	assert.Nil(t, m.Consume(50))
+	assert.Equal(t, uint64(0), m.Remaining())
+
+	err := m.Consume(1)
+	assert.True(t, errors.Is(err, gasmeter.ErrOutOfGas))
+	var oog *gasmeter.OutOfGasError
+	if assert.True(t, errors.As(err, &oog)) {
+		assert.Equal(t, gasmeter.OutOfGasError{Limit: 100, Used: 100, Amount: 1}, *oog)
+	}
+	assert.EqualError(t, err, "out of gas: 1 charged with 0 of 100 remaining")
+
+	// a failed charge uses all the remaining gas.
+	m = gasmeter.NewGasMeter(100)
+	assert.Nil(t, m.Consume(30))
+	assert.EqualError(t, m.Consume(math.MaxUint64), "out of gas: 18446744073709551615 charged with 70 of 100 remaining")
+	assert.Equal(t, uint64(100), m.Used())
+	m.Refund(math.MaxUint64)
+	assert.Equal(t, uint64(0), m.Used())
+
+	m = gasmeter.NewInfiniteGasMeter()
+	assert.Nil(t, m.Consume(math.MaxUint64-1))
+	assert.Nil(t, m.Consume(1))
+	assert.NotNil(t, m.Consume(1))
+}
+
+func TestUseGas(t *testing.T) {
+	m := gasmeter.NewGasMeter(math.MaxUint64)
+	assert.Nil(t, gasmeter.UseGasI32(m, 5))
+	assert.Nil(t, gasmeter.UseGasI32(m, -1))
+	assert.Equal(t, uint64(5+math.MaxUint32), m.Used())
+
+	m = gasmeter.NewGasMeter(math.MaxUint64)
+	assert.Nil(t, gasmeter.UseGasI64(m, 5))
+	assert.Nil(t, gasmeter.UseGasF32(m, 2.5))
+	assert.Nil(t, gasmeter.UseGasF64(m, 4))
+	assert.Equal(t, uint64(5+3+4), m.Used())
+
+	assert.EqualError(t, gasmeter.UseGasF32(m, -1), "invalid gas amount -1")
+	assert.EqualError(t, gasmeter.UseGasF64(m, math.NaN()), "invalid gas amount NaN")
+	assert.True(t, errors.Is(gasmeter.UseGasF64(m, math.Inf(1)), gasmeter.ErrOutOfGas))
+
+	m = gasmeter.NewGasMeter(math.MaxUint64)
+	assert.Nil(t, gasmeter.UseGas(m, "i32", interp.I32(-1)))
+	assert.Nil(t, gasmeter.UseGas(m, "i64", 7))
+	assert.Nil(t, gasmeter.UseGas(m, "f32", interp.F32(8)))
+	assert.Nil(t, gasmeter.UseGas(m, "f64", interp.F64(9)))
+	assert.Equal(t, uint64(math.MaxUint32+7+8+9), m.Used())
+	assert.EqualError(t, gasmeter.UseGas(m, "v128", 0), "invalid meter type v128")
+}
+
+func TestGasMeterMeteredModule(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(sumWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	for _, meterType := range []string{"i32", "i64", "f32", "f64"} {
+		meteredWasm, gasCost, err := metering.MeterWASM(wasm, &metering.Options{MeterType: meterType})
+		assert.Nil(t, err, meterType)
+		module, err := wasm2json.Wasm2Module(meteredWasm)
+		assert.Nil(t, err, meterType)
+
+		var m gasmeter.GasMeter
+		inst, err := interp.Instantiate(module, interp.Imports{
+			Funcs: map[string]map[string]interp.HostFunc{
+				"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+					return nil, gasmeter.UseGas(m, meterType, args[0])
+				}},
+			},
+		})
+		assert.Nil(t, err, meterType)
+
+		// with n = 0 only the entry and the loop test run.
+		m = gasmeter.NewGasMeter(math.MaxUint64)
+		res, err := inst.Call("sum", 0)
+		assert.Nil(t, err, meterType)
+		assert.Equal(t, []uint64{0}, res, meterType)
+		entryAndTest := m.Used()
+		assert.True(t, entryAndTest > 0 && entryAndTest < gasCost, meterType)
+
+		m = gasmeter.NewGasMeter(entryAndTest)
+		_, err = inst.Call("sum", 0)
+		assert.Nil(t, err, meterType)
+		_, err = inst.Call("sum", 0)
+		assert.True(t, errors.Is(err, gasmeter.ErrOutOfGas), meterType)
+		assert.Equal(t, uint64(0), m.Remaining(), meterType)
+	}
+}
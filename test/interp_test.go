@@ -0,0 +1,198 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/interp"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+const sumWat = `
+(module
+  (func $sum (export "sum") (param $n i32) (result i32) (local $acc i32)
+    (block
+      (loop
+        (br_if 1 (i32.eqz (local.get $n)))
+        (local.set $acc (i32.add (local.get $acc) (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br 0)))
+    (local.get $acc)))`
+
+func instantiate(t *testing.T, text string, imports interp.Imports) *interp.Instance {
+	module, err := wat.Wat2Module(text, wat.ParseOptions{})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	inst, err := interp.Instantiate(module, imports)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return inst
+}
+
+func TestInterp(t *testing.T) {
+	inst := instantiate(t, sumWat, interp.Imports{})
+	res, err := inst.Call("sum", interp.I32(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{5050}, res)
+
+	inst = instantiate(t, `
+(module
+  (import "env" "double" (func $double (param i64) (result i64)))
+  (type $unary (func (param i64) (result i64)))
+  (table funcref (elem $fac $double $neg))
+  (memory 1)
+  (global $calls (export "calls") (mut i32) (i32.const 0))
+  (data (i32.const 8) "\01\02\03\04")
+  (func $fac (param i64) (result i64)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (if (result i64) (i64.eqz (local.get 0))
+      (then (i64.const 1))
+      (else (i64.mul (local.get 0) (call $fac (i64.sub (local.get 0) (i64.const 1)))))))
+  (func $neg (param f64) (result f64) (f64.neg (local.get 0)))
+  (func (export "apply") (param i32 i64) (result i64)
+    (call_indirect (type $unary) (local.get 1) (local.get 0)))
+  (func (export "load") (param i32) (result i32)
+    (i32.load16_s offset=8 (local.get 0)))
+  (func (export "grow") (result i32)
+    (drop (memory.grow (i32.const 2)))
+    (i32.store (i32.const 65536) (i32.const -1))
+    (memory.size))
+  (func (export "pick") (param i32) (result i32)
+    (block (block (block
+      (br_table 0 1 2 (local.get 0)))
+      (return (i32.const 10)))
+      (return (i32.const 11)))
+    (i32.const 12))
+  (func (export "convert") (param f64) (result i64)
+    (i64.trunc_f64_s (f64.sqrt (local.get 0)))))`, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"env": {"double": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return []uint64{args[0] * 2}, nil
+			}},
+		},
+	})
+
+	for _, c := range []struct {
+		name     string
+		args     []uint64
+		expected uint64
+	}{
+		{"apply", []uint64{0, 10}, 3628800},
+		{"apply", []uint64{1, 21}, 42},
+		{"load", []uint64{0}, 0x0201},
+		{"load", []uint64{2}, 0x0403},
+		{"grow", nil, 3},
+		{"pick", []uint64{0}, 10},
+		{"pick", []uint64{1}, 11},
+		{"pick", []uint64{7}, 12},
+		{"convert", []uint64{interp.F64(16.5)}, 4},
+	} {
+		res, err := inst.Call(c.name, c.args...)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, []uint64{c.expected}, res, c.name)
+	}
+	calls, ok := inst.Global("calls")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(11), calls)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff}, inst.Memory()[65536:65540])
+
+	// the function at index 2 of the table has another type.
+	_, err = inst.Call("apply", 2, 1)
+	assert.EqualError(t, err, "trap: indirect call type mismatch")
+	_, err = inst.Call("apply", 3, 1)
+	assert.EqualError(t, err, "trap: undefined element 3")
+	_, err = inst.Call("load", interp.I32(-1))
+	assert.EqualError(t, err, "trap: out of bounds memory access")
+}
+
+func TestInterpTraps(t *testing.T) {
+	inst := instantiate(t, `
+(module
+  (func (export "div") (param i32 i32) (result i32) (i32.div_s (local.get 0) (local.get 1)))
+  (func (export "trunc") (param f32) (result i32) (i32.trunc_f32_u (local.get 0)))
+  (func (export "unreachable") (unreachable))
+  (func $loop (export "loop") (call $loop)))`, interp.Imports{})
+
+	for _, c := range []struct {
+		name string
+		args []uint64
+		err  string
+	}{
+		{"div", []uint64{1, 0}, "integer divide by zero"},
+		{"div", []uint64{interp.I32(-1 << 31), interp.I32(-1)}, "integer overflow"},
+		{"trunc", []uint64{interp.F32(-1)}, "integer overflow"},
+		{"trunc", []uint64{interp.F32(float32(1 << 32))}, "integer overflow"},
+		{"unreachable", nil, "unreachable"},
+		{"loop", nil, "call stack exhausted"},
+	} {
+		_, err := inst.Call(c.name, c.args...)
+		var trap *interp.Trap
+		if assert.True(t, errors.As(err, &trap), c.name) {
+			assert.Equal(t, c.err, trap.Msg, c.name)
+		}
+	}
+
+	res, err := inst.Call("trunc", interp.F32(-0.5))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0}, res)
+
+	module, err := wat.Wat2Module(`(module (import "env" "f" (func)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, err = interp.Instantiate(module, interp.Imports{})
+	assert.EqualError(t, err, "instantiate error: unknown import env.f")
+}
+
+func TestMeteredGasTrace(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(sumWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+	meteredWasm, gasCost, err := metering.MeterWASM(wasm, &metering.Options{Validate: true})
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	// every segment is charged its cost plus the 91 of the metering call:
+	// the entry with its two local fields, block and loop; the loop test and
+	// the loop body. The segments made of `end` and `local.get` are free.
+	const (
+		entry = 2 + 1 + 1 + 91
+		test  = 45 + 90 + 91
+		body  = 45 + 1 + 45 + 90 + 91
+	)
+	assert.Equal(t, uint64(entry+test+body), gasCost)
+
+	var (
+		trace []uint64
+		limit uint64
+	)
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				trace = append(trace, args[0])
+				limit -= args[0]
+				if int64(limit) < 0 {
+					return nil, fmt.Errorf("out of gas")
+				}
+				return nil, nil
+			}},
+		},
+	})
+	assert.Nil(t, err)
+
+	limit = 10000
+	res, err := inst.Call("sum", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{6}, res)
+	assert.Equal(t, []uint64{entry, test, body, test, body, test, body, test}, trace)
+
+	// execution stops at the charge that exceeds the limit.
+	trace, limit = nil, entry+test+body
+	_, err = inst.Call("sum", 3)
+	assert.EqualError(t, err, "out of gas")
+	assert.Equal(t, []uint64{entry, test, body, test}, trace)
+}
@@ -1,13 +1,20 @@
 package test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"path"
 	"testing"
 
 	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/interp"
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
 	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -89,3 +96,608 @@ func TestBasicMeteringTests(t *testing.T) {
 		}
 	}
 }
+
+func TestMeterCustomSections(t *testing.T) {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "start.wasm"))
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+
+	// a payload that is not valid UTF-8 must survive metering byte for byte.
+	signature := []byte{0xff, 0xfe, 0x00, 0x80, 0xc3, 0x28}
+	err = module.InsertCustom(&tool.CustomSec{Name: "custom", SectionName: "signature", Custom: signature}, tool.Placement{Anchor: "code", Before: true})
+	assert.Nil(t, err)
+	wasm, err = json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+
+	meteredWasm, _, err := metering.MeterWASM(wasm, &metering.Options{
+		CustomSections: []metering.CustomSection{
+			{Name: "build_id", Payload: []byte{1, 2, 3}, Placement: tool.Placement{Anchor: "type"}},
+			{Name: "trailer", Payload: []byte{4}},
+		},
+	})
+	assert.Nil(t, err)
+	meteredModule, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	var names []string
+	for _, section := range meteredModule.Sections {
+		name := section.SecName()
+		if custom, ok := section.(*tool.CustomSec); ok {
+			name = custom.SectionName
+			if name == "signature" {
+				assert.Equal(t, signature, custom.Custom)
+			}
+		}
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"type", "build_id", "import", "function", "table", "memory", "export", "start", "signature", "code", "data", "trailer"}, names)
+
+	assert.Equal(t, 1, meteredModule.RemoveCustoms("signature"))
+	err = meteredModule.ReplaceCustom(&tool.CustomSec{Name: "custom", SectionName: "trailer", Custom: []byte{5}}, tool.Placement{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{5}, meteredModule.CustomSecs("trailer")[0].Custom)
+	err = meteredModule.InsertCustom(&tool.CustomSec{Name: "custom", SectionName: "bad"}, tool.Placement{Anchor: "custom"})
+	assert.NotNil(t, err)
+}
+
+func TestMeterInconsistentFunctions(t *testing.T) {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "basic.wasm"))
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+
+	// a code body without a function entry is not metered.
+	module.FuncSec().Entries = module.FuncSec().Entries[1:]
+	_, err = newMetering().MeterModule(module)
+	assert.EqualError(t, err, "function and code section have inconsistent lengths 0 and 1")
+}
+
+func TestMeterMissingEnd(t *testing.T) {
+	module, err := tool.UnmarshalModuleJSON([]byte(`[
+		{"name": "preramble", "magic": [0, 97, 115, 109], "version": [1, 0, 0, 0]},
+		{"name": "type", "entries": [{"form": "func", "params": [], "returns": []}]},
+		{"name": "function", "entries": [0]},
+		{"name": "code", "entries": [{"locals": [], "code": [{"name": "nop"}]}]}
+	]`))
+	assert.Nil(t, err)
+	expected, err := json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+
+	// a body without `end` is rejected before the module is changed.
+	for _, m := range []*metering.Metering{newMetering(), newMetering()} {
+		_, err = m.MeterModule(module)
+		assert.EqualError(t, err, "function body 0: END opcode expected")
+	}
+	m := newMetering()
+	m.Opts.LoopHeadersOnly = true
+	assert.NotNil(t, m.Run(module))
+	encoded, err := json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, encoded)
+}
+
+func TestMeterLoopHeaders(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(sumWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+	meteredWasm, gasCost, err := metering.MeterWASM(wasm, &metering.Options{LoopHeadersOnly: true, Validate: true})
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	calls := 0
+	for _, op := range module.CodeSec().Entries[0].Code {
+		if op.Name() == "call" {
+			calls++
+		}
+	}
+	assert.Equal(t, 2, calls)
+
+	// the entry charges the locals, block and loop, and every iteration the
+	// whole loop body, each with the 91 of the metering call.
+	const (
+		entry = 2 + 1 + 1 + 91
+		loop  = 45 + 90 + 45 + 1 + 45 + 90 + 91
+	)
+	assert.Equal(t, uint64(entry+loop), gasCost)
+
+	var trace []uint64
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				trace = append(trace, args[0])
+				return nil, nil
+			}},
+		},
+	})
+	assert.Nil(t, err)
+	res, err := inst.Call("sum", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{6}, res)
+	assert.Equal(t, []uint64{entry, loop, loop, loop, loop}, trace)
+}
+
+func TestMeterMergeCharges(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (func (export "f") (param i32) (result i32)
+    (block (drop (i32.const 1)))
+    (block (drop (i32.const 2)))
+    (if (local.get 0) (then (nop)))
+    (block (br_if 0 (local.get 0)) (nop))
+    (i32.add (local.get 0) (i32.const 3))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	run := func(opts *metering.Options, arg uint64) []uint64 {
+		meteredWasm, _, err := metering.MeterWASM(wasm, opts)
+		assert.Nil(t, err)
+		module, err := wasm2json.Wasm2Module(meteredWasm)
+		assert.Nil(t, err)
+		var trace []uint64
+		inst, err := interp.Instantiate(module, interp.Imports{
+			Funcs: map[string]map[string]interp.HostFunc{
+				"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+					trace = append(trace, args[0])
+					return nil, nil
+				}},
+			},
+		})
+		assert.Nil(t, err)
+		_, err = inst.Call("f", arg)
+		assert.Nil(t, err)
+		return trace
+	}
+
+	var plain, merged metering.Stats
+	for _, arg := range []uint64{0, 1} {
+		trace := run(&metering.Options{Validate: true, Stats: &plain}, arg)
+		mergedTrace := run(&metering.Options{Validate: true, MergeCharges: true, Stats: &merged}, arg)
+
+		// the blocks and the condition of the `if` are charged at once. The
+		// code after the `if`, after the `br_if` and after the block it
+		// targets keeps its charge.
+		assert.Len(t, trace, 6)
+		assert.Len(t, mergedTrace, 4)
+		assert.Equal(t, []uint64{trace[0] + trace[1] + trace[2] - 2*91}, mergedTrace[:1])
+		assert.Equal(t, trace[len(trace)-1], mergedTrace[len(mergedTrace)-1])
+		var sum, mergedSum uint64
+		for _, gas := range trace {
+			sum += gas
+		}
+		for _, gas := range mergedTrace {
+			mergedSum += gas
+		}
+		assert.Equal(t, sum-uint64(len(trace)-len(mergedTrace))*91, mergedSum)
+	}
+	// the stats add up over the two meterings.
+	assert.Equal(t, metering.Stats{Injected: 2 * 2 * 7}, plain)
+	assert.Equal(t, metering.Stats{Injected: 2 * 2 * 5, Saved: 2 * 2 * 2}, merged)
+
+	// a branch with invalid immediates is an error.
+	for _, op := range []tool.OP{tool.NewOP("br_if", int32(0)), tool.NewOP("br_table", uint32(0))} {
+		module, err := wasm2json.Wasm2Module(wasm)
+		assert.Nil(t, err)
+		code := module.CodeSec().Entries[0].Code
+		for i := range code {
+			if code[i].Name() == "br_if" {
+				code[i] = op
+				m := newMetering()
+				m.Opts.MergeCharges = true
+				_, err = m.MeterModule(module)
+				assert.EqualError(t, err, fmt.Sprintf("function body 0: instruction %d: invalid %s immediate", i, op.Name()))
+			}
+		}
+	}
+}
+
+func TestMeterImportCosts(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (import "env" "store" (func $store (param i32 i32)))
+  (import "env" "log" (func $log (param i32)))
+  (func (export "f")
+    (call $store (i32.const 1) (i32.const 2))
+    (call $log (i32.const 3))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	_, plain, err := metering.MeterWASM(wasm, nil)
+	assert.Nil(t, err)
+	_, priced, err := metering.MeterWASM(wasm, &metering.Options{
+		ImportCosts: map[string]metering.ImportCost{
+			"env.store": {Cost: 5000, PerArg: 10},
+			"env.none":  {Cost: 1},
+		},
+	})
+	assert.Nil(t, err)
+	// the call to store costs 5000 and 10 per argument instead of 90.
+	assert.Equal(t, plain-90+5000+2*10, priced)
+
+	_, priced, err = metering.MeterWASM(wasm, &metering.Options{
+		LoopHeadersOnly: true,
+		ImportCosts:     map[string]metering.ImportCost{"env.log": {Cost: 7}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, plain-90+7, priced)
+}
+
+func TestMeterImportPolicy(t *testing.T) {
+	policy := &metering.ImportPolicy{
+		Allowed: []metering.AllowedImport{
+			{Name: "env.storage_*", Type: &tool.TypeEntry{Params: []string{"i32", "i32"}}},
+			{Name: "env.log", Type: &tool.TypeEntry{Params: []string{"i32"}}},
+			{Name: "env.memory", Kind: "memory"},
+			{Name: "debug.*"},
+		},
+	}
+
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (import "env" "storage_write" (func (param i32 i32)))
+  (import "env" "storage_read" (func (param i32 i32)))
+  (import "env" "memory" (memory 1))
+  (import "debug" "print" (func (param i64)))
+  (func (export "f")))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, _, err = metering.MeterWASM(wasm, &metering.Options{ImportPolicy: policy})
+	assert.Nil(t, err)
+
+	wasm, err = wat.Wat2Wasm(`
+(module
+  (import "env" "storage_write" (func (param i64)))
+  (import "env" "log" (func (param i32) (result i32)))
+  (import "env" "exit" (func))
+  (import "env" "table" (table 1 funcref))
+  (import "debug" "counter" (global i32))
+  (func (export "f")))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, _, err = metering.MeterWASM(wasm, &metering.Options{ImportPolicy: policy})
+	var violations metering.ImportViolations
+	if assert.True(t, errors.As(err, &violations)) {
+		assert.Len(t, violations, 5)
+		assert.Equal(t, "exit", violations[2].FieldStr)
+		assert.Equal(t, "table", violations[3].Kind)
+	}
+	assert.EqualError(t, err, "import policy violation: "+
+		"import env.storage_write: signature [i64] -> [] does not match [i32 i32] -> []; "+
+		"import env.log: signature [i32] -> [i32] does not match [i32] -> []; "+
+		"import env.exit: function not allowed; "+
+		"import env.table: table not allowed; "+
+		"import debug.counter: global not allowed")
+}
+
+func TestMeterFloatPolicy(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (global (mut f64) (f64.const 0))
+  (func (export "nan") (param i32) (result i32)
+    (i32.reinterpret_f32 (f32.add (f32.reinterpret_i32 (local.get 0)) (f32.const 1))))
+  (func (export "sqrt") (param f64) (result i64) (local f32)
+    (i64.reinterpret_f64 (f64.sqrt (local.get 0))))
+  (func (export "int") (param i32) (result i32) (i32.add (local.get 0) (i32.const 1))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	_, _, err = metering.MeterWASM(wasm, &metering.Options{FloatPolicy: metering.FloatsRejected})
+	var violations metering.FloatViolations
+	if assert.True(t, errors.As(err, &violations)) {
+		assert.Equal(t, 1, violations[7].Func)
+		assert.Equal(t, 1, violations[7].Offset)
+	}
+	assert.EqualError(t, err, "float policy violation: "+
+		"type section: type 1 uses f64; "+
+		"global section: global 0 has type f64; "+
+		"function 0, instruction 1: f32.reinterpret_i32; "+
+		"function 0, instruction 2: f32.const; "+
+		"function 0, instruction 3: f32.add; "+
+		"function 0, instruction 4: i32.reinterpret_f32; "+
+		"function 1: local of type f32; "+
+		"function 1, instruction 1: f64.sqrt; "+
+		"function 1, instruction 2: i64.reinterpret_f64")
+
+	meteredWasm, _, err := metering.MeterWASM(wasm, &metering.Options{FloatPolicy: metering.FloatsCanonicalNaN, Validate: true})
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return nil, nil
+			}},
+		},
+	})
+	assert.Nil(t, err)
+
+	for _, c := range []struct {
+		name     string
+		arg      uint64
+		expected uint64
+	}{
+		// NaN payloads and signs are dropped.
+		{"nan", 0x7fa00001, 0x7fc00000},
+		{"nan", 0xffc00000, 0x7fc00000},
+		{"nan", interp.F32(1), interp.F32(2)},
+		{"sqrt", interp.F64(-1), 0x7ff8000000000000},
+		{"sqrt", interp.F64(4), interp.F64(2)},
+		{"int", 1, 2},
+	} {
+		res, err := inst.Call(c.name, c.arg)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, []uint64{c.expected}, res, c.name)
+	}
+}
+
+func TestMeterSoftFloat(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (import "env" "log" (func $log (param i32)))
+  (type $binary (func (param f64 f64) (result f64)))
+  (table funcref (elem $mul))
+  (func $mul (param f64 f64) (result f64) (f64.mul (local.get 0) (local.get 1)))
+  (func (export "hyp") (param f64 f64) (result i64)
+    (i64.trunc_f64_s (f64.sqrt (f64.add
+      (call $mul (local.get 0) (local.get 0))
+      (call_indirect (type $binary) (local.get 1) (local.get 1) (i32.const 0))))))
+  (func (export "lt") (param f32 f32) (result i32)
+    (call $log (i32.const 1))
+    (f32.lt (f32.neg (local.get 0)) (local.get 1))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	meteredWasm, gasCost, err := metering.MeterWASM(wasm, &metering.Options{SoftFloat: true, Validate: true})
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	var imports []string
+	for _, entry := range module.ImportSec().Entries {
+		imports = append(imports, entry.ModuleStr+"."+entry.FieldStr)
+	}
+	assert.Equal(t, []string{"env.log", "softfloat.f32.lt", "softfloat.f32.neg", "softfloat.f64.add", "softfloat.f64.mul", "softfloat.f64.sqrt", "softfloat.i64.trunc_f64_s", "metering.usegas"}, imports)
+	for _, body := range module.CodeSec().Entries {
+		for _, op := range body.Code {
+			assert.NotContains(t, []string{"f32", "f64"}, op.ReturnType())
+		}
+	}
+
+	// every soft float call costs the instruction it replaces on top of the
+	// call.
+	_, plainCost, err := metering.MeterWASM(wasm, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, plainCost+6*90, gasCost)
+
+	f64 := func(f func(a, b float64) float64) interp.HostFunc {
+		return func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+			var b float64
+			if len(args) > 1 {
+				b = math.Float64frombits(args[1])
+			}
+			return []uint64{interp.F64(f(math.Float64frombits(args[0]), b))}, nil
+		}
+	}
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"env": {"log": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return nil, nil
+			}},
+			"softfloat": {
+				"f64.add":  f64(func(a, b float64) float64 { return a + b }),
+				"f64.mul":  f64(func(a, b float64) float64 { return a * b }),
+				"f64.sqrt": f64(func(a, _ float64) float64 { return math.Sqrt(a) }),
+				"i64.trunc_f64_s": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+					return []uint64{uint64(int64(math.Float64frombits(args[0])))}, nil
+				},
+				"f32.neg": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+					return []uint64{args[0] ^ 0x80000000}, nil
+				},
+				"f32.lt": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+					if math.Float32frombits(uint32(args[0])) < math.Float32frombits(uint32(args[1])) {
+						return []uint64{1}, nil
+					}
+					return []uint64{0}, nil
+				},
+			},
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return nil, nil
+			}},
+		},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	res, err := inst.Call("hyp", interp.F64(3), interp.F64(4))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{5}, res)
+	res, err = inst.Call("lt", interp.F32(1), interp.F32(-2))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0}, res)
+}
+
+func TestMeterMemoryLimit(t *testing.T) {
+	meter := func(text string, opts *metering.Options) (*tool.Module, error) {
+		wasm, err := wat.Wat2Wasm(text, wat.ParseOptions{})
+		assert.Nil(t, err)
+		meteredWasm, _, err := metering.MeterWASM(wasm, opts)
+		if err != nil {
+			return nil, err
+		}
+		return wasm2json.Wasm2Module(meteredWasm)
+	}
+	growWat := `
+(module
+  (memory 2)
+  (data (i32.const 131000) "\01\02")
+  (func (export "grow") (param i32) (result i32) (memory.grow (local.get 0))))`
+
+	module, err := meter(growWat, &metering.Options{MaxMemoryPages: 4, CapMemoryMaximum: true, Validate: true})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, tool.MemLimits{Flags: 1, Intial: 2, Maximum: uint32(4)}, module.MemSec().Entries[0])
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				return nil, nil
+			}},
+		},
+	})
+	assert.Nil(t, err)
+	res, err := inst.Call("grow", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{0xffffffff}, res)
+	res, err = inst.Call("grow", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint64{2}, res)
+
+	// lower maximums are kept, and maximums are only set when asked for.
+	module, err = meter(`(module (memory 1 3))`, &metering.Options{MaxMemoryPages: 4, CapMemoryMaximum: true})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), module.MemSec().Entries[0].Maximum)
+	module, err = meter(`(module (memory 1 8))`, &metering.Options{MaxMemoryPages: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(8), module.MemSec().Entries[0].Maximum)
+	module, err = meter(`(module (import "env" "memory" (memory 1)))`, &metering.Options{MaxMemoryPages: 4, CapMemoryMaximum: true})
+	assert.Nil(t, err)
+	assert.Equal(t, tool.MemLimits{Flags: 1, Intial: 1, Maximum: uint32(4)}, module.ImportSec().Entries[0].Type)
+
+	_, err = meter(growWat, &metering.Options{MaxMemoryPages: 1})
+	assert.EqualError(t, err, "memory 0: initial size 2 pages exceeds the limit of 1 pages")
+	_, err = meter(`(module (import "env" "memory" (memory 5)))`, &metering.Options{MaxMemoryPages: 4})
+	assert.EqualError(t, err, "memory import env.memory: initial size 5 pages exceeds the limit of 4 pages")
+	_, err = meter(`(module (memory 1) (data (i32.const 65535) "\01\02"))`, &metering.Options{MaxMemoryPages: 4})
+	assert.EqualError(t, err, "data segment 0: bytes [65535, 65537) outside of the 1 initial pages of memory 0")
+	_, err = meter(`(module (import "env" "base" (global i32)) (memory 1) (data (global.get 0) "\01"))`, &metering.Options{MaxMemoryPages: 4})
+	assert.EqualError(t, err, "data segment 0: offset global.get is not a constant")
+	_, err = meter(`(module (memory 1))`, &metering.Options{CapMemoryMaximum: true})
+	assert.EqualError(t, err, "option CapMemoryMaximum needs MaxMemoryPages")
+
+	// capping keeps the other flags of a memory, such as shared.
+	module, err = wat.Wat2Module(`(module (memory 1))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	module.MemSec().Entries[0].Flags = 2
+	m := newMetering()
+	m.Opts.MaxMemoryPages, m.Opts.CapMemoryMaximum = 4, true
+	_, err = m.MeterModule(module)
+	assert.Nil(t, err)
+	assert.Equal(t, tool.MemLimits{Flags: 3, Intial: 1, Maximum: uint32(4)}, module.MemSec().Entries[0])
+}
+
+func TestMeterReusesType(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (func $use (param i64))
+  (func (export "f") (result i32) (i32.const 1)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	_, err = newMetering().MeterModule(module)
+	assert.Nil(t, err)
+	assert.Len(t, module.TypeSec().Entries, 2)
+	assert.Equal(t, uint32(0), module.ImportSec().Entries[0].Type)
+
+	// the type is added once for modules without it.
+	module, err = wat.Wat2Module(`(module (func (export "f") (result i32) (i32.const 1)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, err = newMetering().MeterModule(module)
+	assert.Nil(t, err)
+	assert.Equal(t, []tool.TypeEntry{
+		{Form: "func", Params: []string{}, Returns: []string{"i32"}},
+		{Form: "func", Params: []string{"i64"}},
+	}, module.TypeSec().Entries)
+	assert.Equal(t, uint32(1), module.ImportSec().Entries[0].Type)
+}
+
+func TestMeterMemoryOps(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (memory 1)
+  (func (export "f") (result i32) (drop (memory.grow (i32.const 1))) (memory.size)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	// memory.grow and memory.size cost grow_memory and current_memory, and a
+	// charge is split at memory.grow. Charges include the 91 of the metering
+	// statement.
+	_, err = newMetering().MeterModule(module)
+	assert.Nil(t, err)
+	var charges []interface{}
+	for _, op := range module.CodeSec().Entries[0].Code {
+		if op.FullName() == "i64.const" {
+			charges = append(charges, op.Immediates)
+		}
+	}
+	assert.Equal(t, []interface{}{int64(91 + 1 + 10000), int64(91 + 120 + 100)}, charges)
+}
+
+func TestMeterCostSchedule(t *testing.T) {
+	table := tool.JSON{"code": tool.JSON{"code": tool.JSON{"DEFAULT": 2, "call": 5}}}
+	schedule, err := metering.CompileCostTable(table)
+	assert.Nil(t, err)
+
+	// a compiled schedule meters as its table does, and a metering keeps the
+	// schedule of its first run.
+	wasm, err := wat.Wat2Wasm(`(module (func (drop (i32.const 1))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	_, gas, err := metering.MeterWASM(wasm, &metering.Options{CostTable: table})
+	assert.Nil(t, err)
+	_, scheduleGas, err := metering.MeterWASM(wasm, &metering.Options{CostSchedule: schedule})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2+2+2+5+2), gas)
+	assert.Equal(t, gas, scheduleGas)
+
+	m := newMetering()
+	for i := 0; i < 2; i++ {
+		module, err := wasm2json.Wasm2Module(wasm)
+		assert.Nil(t, err)
+		gas, err := m.MeterModule(module)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(120+1+91), gas)
+		m.Opts.CostTable = table
+	}
+}
+
+func TestMeterKeepsNames(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (import "env" "f" (func $f))
+  (func $g (param $x i32) (local $y i32) (call $f)))`, wat.ParseOptions{Names: true})
+	assert.Nil(t, err)
+
+	// function and local names follow their function.
+	meteredWasm, _, err := metering.MeterWASM(wasm, nil)
+	assert.Nil(t, err)
+	text, err := wat.Wasm2Wat(meteredWasm, wat.Options{})
+	assert.Nil(t, err)
+	assert.Contains(t, text, `(import "metering" "usegas" (func $metering.usegas (type 2)))`)
+	assert.Contains(t, text, "(func $g (type 1) (param $x i32)\n    (local $y i32)\n")
+}
+
+func TestMeterWorkers(t *testing.T) {
+	for _, dir := range []string{path.Join("testdata", "wasm"), path.Join("testdata", "in", "wasm")} {
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		for _, file := range files {
+			wasm, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+			assert.Nil(t, err)
+
+			// metering concurrently gives the same output, gas and stats.
+			for _, opts := range []metering.Options{{}, {LoopHeadersOnly: true}, {MergeCharges: true}} {
+				var stats, parallelStats metering.Stats
+				opts.Stats = &stats
+				expected, expectedGas, expectedErr := metering.MeterWASM(wasm, &opts)
+				opts.Stats, opts.Workers = &parallelStats, 4
+				meteredWasm, gasCost, err := metering.MeterWASM(wasm, &opts)
+				if expectedErr != nil {
+					assert.EqualError(t, err, expectedErr.Error(), file.Name())
+					continue
+				}
+				assert.Nil(t, err, file.Name())
+				assert.Equal(t, expectedGas, gasCost, file.Name())
+				assert.Equal(t, expected, meteredWasm, file.Name())
+				assert.Equal(t, stats, parallelStats, file.Name())
+
+				var out bytes.Buffer
+				opts.Stats = nil
+				gasCost, err = metering.MeterStream(bytes.NewReader(wasm), &out, &opts)
+				assert.Nil(t, err, file.Name())
+				assert.Equal(t, expectedGas, gasCost, file.Name())
+				assert.Equal(t, expected, out.Bytes(), file.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/interp"
+	"github.com/meshplus/go-wasm-metering/pass"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/validate"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassHelpers(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (import "env" "log" (func $log (param i32)))
+  (global $g (import "env" "g") i32)
+  (table funcref (elem $one))
+  (func $one (result i32) (i32.const 1))
+  (func $start (call $log (call $one)))
+  (start $start)
+  (export "one" (func $one)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	// types are deduplicated.
+	assert.Equal(t, uint32(0), pass.AddType(module, tool.TypeEntry{Params: []string{"i32"}}))
+	i64 := tool.TypeEntry{Form: "func", Params: []string{"i64"}}
+	typeIndex := pass.AddType(module, i64)
+	assert.Equal(t, typeIndex, pass.AddType(module, i64))
+
+	// defined functions move up after an imported one.
+	index, err := pass.InsertFuncImport(module, "env", "use", i64)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), index)
+	assert.Equal(t, typeIndex, module.ImportSec().Entries[2].Type)
+	assert.Equal(t, uint32(2), module.ExportSec().Entries[0].Index)
+	assert.Equal(t, []uint32{2}, module.ElementSec().Entries[0].Elements)
+	assert.Equal(t, uint32(3), module.StartSec().Index)
+	assert.Equal(t, []tool.OP{
+		tool.NewOP("call", uint32(2)),
+		tool.NewOP("call", uint32(0)),
+		tool.NewOP("end", nil),
+	}, module.CodeSec().Entries[1].Code)
+
+	// globals are indexed after the imported ones.
+	assert.Equal(t, uint32(1), pass.AddGlobal(module, tool.GlobalEntry{
+		Type: tool.Global{ContentType: "i64", Mutability: 1},
+		Init: tool.NewOP("i64.const", int64(0)),
+	}))
+	assert.Nil(t, validate.Module(module))
+
+	// calls of hand-built bodies must be indexed by uint32.
+	body := tool.CodeBody{Code: []tool.OP{tool.NewOP("call", 1), tool.NewOP("end", nil)}}
+	err = pass.RemapBody(&body, func(i uint32) uint32 { return i + 1 })
+	assert.EqualError(t, err, "call at 0: invalid immediates type int")
+}
+
+func TestPipeline(t *testing.T) {
+	var ran []string
+	record := func(name string, err error) pass.Pass {
+		return pass.Func(name, func(module *tool.Module) error {
+			ran = append(ran, name)
+			return err
+		})
+	}
+	errFail := errors.New("fail")
+	pipeline := pass.NewPipeline(record("a", nil), record("b", errFail))
+	pipeline.Add(record("c", nil))
+	assert.Equal(t, errFail, pipeline.Run(&tool.Module{}))
+	assert.Equal(t, []string{"a", "b"}, ran)
+
+	// passes of the options run before metering.
+	m := &metering.Metering{}
+	names := func(passes []pass.Pass) []string {
+		res := make([]string, len(passes))
+		for i, p := range passes {
+			res[i] = p.Name()
+		}
+		return res
+	}
+	assert.Equal(t, []string{"metering"}, names(m.Passes()))
+	m.Opts = metering.Options{
+		ImportPolicy:   &metering.ImportPolicy{},
+		FloatPolicy:    metering.FloatsRejected,
+		MaxMemoryPages: 1,
+		SoftFloat:      true,
+		Passes:         []pass.Pass{record("custom", nil)},
+	}
+	assert.Equal(t, []string{"import policy", "float policy", "memory limit", "soft float", "custom", "metering"}, names(m.Passes()))
+}
+
+func TestMeterPasses(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (func (export "count") (result i64)
+    (i64.const 0)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	// a pass making the function count its calls in a global, metered like
+	// the rest of the code.
+	counter := pass.Func("counter", func(module *tool.Module) error {
+		global := pass.AddGlobal(module, tool.GlobalEntry{
+			Type: tool.Global{ContentType: "i64", Mutability: 1},
+			Init: tool.NewOP("i64.const", int64(0)),
+		})
+		body := &module.CodeSec().Entries[0]
+		body.Code = []tool.OP{
+			tool.NewOP("global.get", global),
+			tool.NewOP("i64.const", int64(1)),
+			tool.NewOP("i64.add", nil),
+			tool.NewOP("global.set", global),
+			tool.NewOP("global.get", global),
+			tool.NewOP("end", nil),
+		}
+		return nil
+	})
+	meteredWasm, gasCost, err := metering.MeterWASM(wasm, &metering.Options{Passes: []pass.Pass{counter}, Validate: true})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	module, err := wasm2json.Wasm2Module(meteredWasm)
+	assert.Nil(t, err)
+
+	var used uint64
+	inst, err := interp.Instantiate(module, interp.Imports{
+		Funcs: map[string]map[string]interp.HostFunc{
+			"metering": {"usegas": func(inst *interp.Instance, args []uint64) ([]uint64, error) {
+				used += args[0]
+				return nil, nil
+			}},
+		},
+	})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	for i := uint64(1); i <= 2; i++ {
+		res, err := inst.Call("count")
+		assert.Nil(t, err)
+		assert.Equal(t, []uint64{i}, res)
+	}
+	assert.Equal(t, 2*gasCost, used)
+
+	// errors of passes are returned as is.
+	errFail := errors.New("fail")
+	_, _, err = metering.MeterWASM(wasm, &metering.Options{Passes: []pass.Pass{
+		pass.Func("fail", func(module *tool.Module) error { return errFail }),
+	}})
+	assert.Equal(t, errFail, err)
+}
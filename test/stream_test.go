@@ -0,0 +1,119 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeterStream(t *testing.T) {
+	for _, dir := range []string{path.Join("testdata", "wasm"), path.Join("testdata", "in", "wasm")} {
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		for _, file := range files {
+			wasm, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+			assert.Nil(t, err)
+			for _, opts := range []metering.Options{{}, {LoopHeadersOnly: true}, {MergeCharges: true, MeterType: "i32"}} {
+				expected, expectedGas, expectedErr := metering.MeterWASM(wasm, &opts)
+				var out bytes.Buffer
+				gasCost, err := metering.MeterStream(bytes.NewReader(wasm), &out, &opts)
+				if expectedErr != nil {
+					assert.EqualError(t, err, expectedErr.Error(), file.Name())
+					continue
+				}
+				if !assert.Nil(t, err, file.Name()) {
+					continue
+				}
+				assert.Equal(t, expectedGas, gasCost, file.Name())
+				assert.Equal(t, expected, out.Bytes(), file.Name())
+			}
+		}
+	}
+}
+
+func TestMeterStreamCopiesSections(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`
+(module
+  (memory 1)
+  (data (i32.const 0) "data")
+  (func (export "f") (result i32) (i32.const 1)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	// a large custom section, with a size padded to 5 bytes, is copied as it
+	// is.
+	debug := bytes.Repeat([]byte{0xab}, 1<<20)
+	payload := append([]byte{5}, "debug"...)
+	size := uint32(len(payload) + len(debug))
+	wasm = append(wasm, 0, byte(size)|0x80, byte(size>>7)|0x80, byte(size>>14)|0x80, byte(size>>21)|0x80, byte(size>>28))
+	wasm = append(append(wasm, payload...), debug...)
+
+	var out bytes.Buffer
+	gasCost, err := metering.MeterStream(bytes.NewReader(wasm), &out, nil)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, bytes.HasSuffix(out.Bytes(), wasm[len(wasm)-len(debug)-len(payload)-6:]))
+
+	module, err := wasm2json.Wasm2Module(out.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("data"), module.DataSec().Entries[0].Data)
+	assert.Equal(t, debug, module.CustomSecs("debug")[0].Custom)
+	assert.Equal(t, "metering", module.ImportSec().Entries[0].ModuleStr)
+	_, expectedGas, err := metering.MeterWASM(wasm, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedGas, gasCost)
+}
+
+func TestMeterStreamErrors(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`(module (import "env" "f" (func)) (func (call 0)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	_, err = metering.MeterStream(bytes.NewReader(wasm), &out, &metering.Options{Validate: true})
+	assert.EqualError(t, err, "option Validate is not supported by MeterStream")
+	_, err = metering.MeterStream(bytes.NewReader(wasm), &out, &metering.Options{ImportPolicy: &metering.ImportPolicy{}})
+	assert.EqualError(t, err, "import policy violation: import env.f: function not allowed")
+	_, err = metering.MeterStream(bytes.NewReader(wasm[:len(wasm)-2]), &out, nil)
+	assert.EqualError(t, err, "unexpected end of section 10")
+	_, err = metering.MeterStream(bytes.NewReader([]byte("\x00asm")), &out, nil)
+	assert.EqualError(t, err, "unexpected end of preramble")
+
+	// the sections metering needs are added to empty modules.
+	out.Reset()
+	_, err = metering.MeterStream(bytes.NewReader(append(append([]byte{}, wasm2json.MAGIC...), wasm2json.VERSION...)), &out, nil)
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(out.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, []tool.TypeEntry{{Form: "func", Params: []string{"i64"}}}, module.TypeSec().Entries)
+	assert.Len(t, module.ImportSec().Entries, 1)
+}
+
+func TestMeterStreamDeclaredSizes(t *testing.T) {
+	preamble := append(append([]byte{}, wasm2json.MAGIC...), wasm2json.VERSION...)
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0x0f}
+	for _, c := range []struct {
+		name string
+		wasm []byte
+		err  string
+	}{
+		{"section", append(append([]byte{1}, huge...), 1, 0x60), "unexpected end of section 1"},
+		{"custom name", append(append([]byte{0, 8}, huge...), 'a'), "unexpected end of custom section"},
+		{"code body", append(append([]byte{1, 4, 1, 0x60, 0, 0, 3, 2, 1, 0, 10, 8, 1}, huge...), 0), "unexpected end of section 10"},
+	} {
+		// a tiny input declaring sizes of gigabytes does not allocate them.
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := metering.MeterStream(bytes.NewReader(append(append([]byte{}, preamble...), c.wasm...)), ioutil.Discard, nil)
+		runtime.ReadMemStats(&after)
+		assert.EqualError(t, err, c.err, c.name)
+		assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20), c.name)
+	}
+}
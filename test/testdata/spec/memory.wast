@@ -0,0 +1,54 @@
+;; Hand-written excerpt of the memory.wast spec test, with export names of exports.wast.
+
+(module (memory 0 0))
+(module (memory 1 256))
+(module (memory 0 65536))
+(module (memory 0) (func (drop (memory.size)) (drop (memory.grow (i32.const 1)))))
+
+(assert_invalid (module (memory 0) (memory 0)) "multiple memories")
+(assert_invalid
+  (module (data (i32.const 0)))
+  "unknown memory"
+)
+(assert_invalid
+  (module (func $f (drop (i32.load (i32.const 0)))))
+  "unknown memory"
+)
+(assert_invalid
+  (module (func $f (drop (memory.size))))
+  "unknown memory"
+)
+(assert_invalid
+  (module (memory 1 0))
+  "size minimum must not be greater than maximum"
+)
+(assert_invalid
+  (module (memory 65537))
+  "memory size must be at most 65536 pages (4GiB)"
+)
+(assert_invalid
+  (module (memory 0 65537))
+  "memory size must be at most 65536 pages (4GiB)"
+)
+(assert_invalid
+  (module (memory 0) (func (drop (i64.load align=16 (i32.const 0)))))
+  "alignment must not be larger than natural"
+)
+(assert_invalid
+  (module (memory 0) (func (i32.store8 align=2 (i32.const 0) (i32.const 0))))
+  "alignment must not be larger than natural"
+)
+(assert_invalid
+  (module (memory 1) (data (i64.const 0)))
+  "type mismatch"
+)
+
+(assert_invalid
+  (module (func) (export "a" (func 0)) (export "a" (func 0)))
+  "duplicate export name"
+)
+(assert_invalid
+  (module (func) (global i32 (i32.const 0)) (export "a" (func 0)) (export "a" (global 0)))
+  "duplicate export name"
+)
+(assert_invalid (module (export "a" (func 0))) "unknown function")
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/validate"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTestdata(t *testing.T) {
+	for _, dir := range []string{path.Join("testdata", "wasm"), path.Join("testdata", "in", "wasm")} {
+		files, err := ioutil.ReadDir(dir)
+		assert.Nil(t, err)
+		for _, file := range files {
+			wasm, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+			assert.Nil(t, err)
+			module, err := wasm2json.Wasm2Module(wasm)
+			assert.Nil(t, err, file.Name())
+			assert.Nil(t, validate.Module(module), file.Name())
+
+			// metering keeps valid modules valid.
+			if _, _, err := metering.MeterWASM(wasm, nil); err == nil {
+				_, _, err = metering.MeterWASM(wasm, &metering.Options{Validate: true})
+				assert.Nil(t, err, file.Name())
+				_, _, err = metering.MeterWASM(wasm, &metering.Options{LoopHeadersOnly: true, Validate: true})
+				assert.Nil(t, err, file.Name())
+				_, _, err = metering.MeterWASM(wasm, &metering.Options{MergeCharges: true, Validate: true})
+				assert.Nil(t, err, file.Name())
+			}
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (global $g i32 (i32.const 0))
+  (func $ok (param i32) (result i32) (local.get 0))
+  (func $bad (result i32)
+    (drop (i64.add (i32.const 1) (i64.const 2)))
+    (global.set $g (i32.const 1))
+    (call $ok (local.get 3)))
+  (func $unreached (result i64)
+    (unreachable)
+    (i32.eqz)
+    (i64.extend_i32_u))
+  (export "f" (func $ok))
+  (export "f" (func $bad))
+  (export "g" (func 7)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	err = validate.Module(module)
+	var errs validate.Errors
+	if !assert.True(t, errors.As(err, &errs)) {
+		return
+	}
+	assert.Equal(t, []string{
+		`export section: duplicate export name "f"`,
+		`export section: export "g": unknown function 7`,
+		"function 1, instruction 2: i64.add: type mismatch: expected i64, got i32",
+		"function 1, instruction 5: global.set: global 0 is immutable",
+		"function 1, instruction 6: local.get: unknown local 3",
+	}, errorStrings(errs))
+	assert.Equal(t, 1, errs[3].Func)
+	assert.Equal(t, 5, errs[3].Offset)
+	assert.Equal(t, -1, errs[0].Func)
+	assert.Contains(t, err.Error(), "invalid module: ")
+
+	// polymorphic stack of unreachable code.
+	valid, err := wat.Wat2Module(`
+(module
+  (func (result i64)
+    (unreachable)
+    (i32.eqz)
+    (i64.extend_i32_u))
+  (func (result i32)
+    (block (result i32) (br 0 (i32.const 1)) (i32.add))))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, validate.Module(valid))
+}
+
+func TestValidateModuleStructure(t *testing.T) {
+	maximum := uint32(1)
+	module := &tool.Module{
+		Magic:   []byte{0x00, 0x61, 0x73, 0x6d},
+		Version: []byte{0x01, 0x00, 0x00, 0x00},
+		Sections: []tool.Section{
+			&tool.TypeSec{Name: "type", Entries: []tool.TypeEntry{{Form: "func", Params: []string{"i32"}}}},
+			&tool.FuncSec{Name: "function", Entries: []uint32{0, 2}},
+			&tool.MemSec{Name: "memory", Entries: []tool.MemLimits{{Flags: 1, Intial: 2, Maximum: maximum}}},
+			&tool.StartSec{Name: "start", Index: 0},
+			&tool.CodeSec{Name: "code", Entries: []tool.CodeBody{{Code: []tool.OP{tool.NewOP("end", nil)}}}},
+		},
+	}
+	err := validate.Module(module)
+	var errs validate.Errors
+	if assert.True(t, errors.As(err, &errs)) {
+		assert.Equal(t, []string{
+			"function section: function 1: unknown type 2",
+			"memory section: initial size 2 must not be greater than maximum size 1",
+			"start section: start function 0 must have type [] -> []",
+			"code section: function and code section have inconsistent lengths 2 and 1",
+		}, errorStrings(errs))
+	}
+}
+
+func TestMeterWASMValidate(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(`(module (func (result i32) (i64.const 1)))`, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	// invalid modules are metered unless validation is asked for.
+	_, _, err = metering.MeterWASM(wasm, nil)
+	assert.Nil(t, err)
+	_, _, err = metering.MeterWASM(wasm, &metering.Options{Validate: true})
+	assert.EqualError(t, err, "validate input error: invalid module: function 0, instruction 1: end: type mismatch: expected i32, got i64")
+}
+
+func errorStrings(errs validate.Errors) []string {
+	res := make([]string, len(errs))
+	for i, err := range errs {
+		res[i] = err.Error()
+	}
+	return res
+}
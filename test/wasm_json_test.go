@@ -2,11 +2,13 @@ package test
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"path"
 	"testing"
 
+	metering "github.com/meshplus/go-wasm-metering"
 	"github.com/meshplus/go-wasm-metering/json2wasm"
 	"github.com/meshplus/go-wasm-metering/tool"
 	"github.com/meshplus/go-wasm-metering/wasm2json"
@@ -22,7 +24,7 @@ var expectedJson = []tool.JSON{
 	{
 		"name":         "custom",
 		"section_name": "a custom section",
-		"custom":       "this is the payload",
+		"custom":       []byte("this is the payload"),
 	},
 }
 
@@ -39,6 +41,112 @@ func TestCustomSection(t *testing.T) {
 	assert.Equal(t, true, assert.ObjectsAreEqual(expectedJson, jsonObj))
 }
 
+func TestWellKnownCustomSections(t *testing.T) {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "ledger_test_gc.wasm"))
+	assert.Nil(t, err)
+	jsonObj, err := wasm2json.Wasm2Json(wasm)
+	assert.Nil(t, err)
+
+	var producers tool.Producers
+	for _, section := range jsonObj {
+		if section["section_name"] == "producers" {
+			producers = section["custom"].(tool.Producers)
+		}
+	}
+	assert.NotEmpty(t, producers.Fields)
+	assert.Equal(t, "language", producers.Fields[0].Name)
+
+	customs := []tool.JSON{
+		{
+			"name":    "preramble",
+			"magic":   []byte{0, 97, 115, 109},
+			"version": []byte{1, 0, 0, 0},
+		},
+		{
+			"name":         "custom",
+			"section_name": "dylink.0",
+			"custom": tool.Dylink{
+				{Id: 1, MemInfo: &tool.DylinkMemInfo{MemorySize: 16, MemoryAlign: 2}},
+				{Id: 2, Needed: []string{"libc.so"}},
+				{Id: 3, ExportInfo: []tool.DylinkExportInfo{{Name: "main", Flags: 1}}},
+			},
+		},
+		{
+			"name":         "custom",
+			"section_name": "target_features",
+			"custom": tool.TargetFeatures{
+				{Prefix: '+', Name: "atomics"},
+				{Prefix: '-', Name: "simd128"},
+			},
+		},
+		{
+			"name":         "custom",
+			"section_name": "sourceMappingURL",
+			"custom":       tool.SourceMappingURL("http://localhost/a.wasm.map"),
+		},
+		{
+			"name":         "custom",
+			"section_name": "external_debug_info",
+			"custom":       tool.ExternalDebugInfo("a.debug.wasm"),
+		},
+		{
+			"name":         "custom",
+			"section_name": "producers",
+			"custom":       []byte("malformed"),
+		},
+	}
+	wasm, err = json2wasm.Json2Wasm(customs)
+	assert.Nil(t, err)
+	jsonObj, err = wasm2json.Wasm2Json(wasm)
+	assert.Nil(t, err)
+	assert.Equal(t, customs, jsonObj)
+	assert.True(t, jsonObj[2]["custom"].(tool.TargetFeatures).Used("atomics"))
+	assert.False(t, jsonObj[2]["custom"].(tool.TargetFeatures).Used("simd128"))
+}
+
+func TestDylinkRoundTrip(t *testing.T) {
+	// subsections out of order, empty, repeated and unknown keep their order
+	// and bytes.
+	payload := []byte{
+		3, 1, 0, // export_info: none.
+		2, 5, 1, 3, 'a', '.', 's', // needed: a.s
+		1, 4, 16, 2, 0, 0, // mem_info.
+		9, 2, 0xaa, 0xbb, // unknown.
+		2, 1, 0, // needed: none.
+		4, 1, 0, // import_info: none.
+	}
+	wasm := []byte{0, 'a', 's', 'm', 1, 0, 0, 0, 0, byte(1 + 8 + len(payload)), 8}
+	wasm = append(append(wasm, "dylink.0"...), payload...)
+
+	module, err := wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+	dylink := module.Sections[0].(*tool.CustomSec).Custom
+	assert.Equal(t, tool.Dylink{
+		{Id: 3},
+		{Id: 2, Needed: []string{"a.s"}},
+		{Id: 1, MemInfo: &tool.DylinkMemInfo{MemorySize: 16, MemoryAlign: 2}},
+		{Id: 9, Payload: []byte{0xaa, 0xbb}},
+		{Id: 2},
+		{Id: 4},
+	}, dylink)
+	res, err := json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	assert.Equal(t, wasm, res)
+
+	text, err := tool.MarshalModuleJSON(module)
+	assert.Nil(t, err)
+	module, err = tool.UnmarshalModuleJSON(text)
+	assert.Nil(t, err)
+	assert.Equal(t, dylink, module.Sections[0].(*tool.CustomSec).Custom)
+	res, err = json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	assert.Equal(t, wasm, res)
+
+	module.Sections[0].(*tool.CustomSec).Custom = tool.Dylink{{Id: 1}}
+	_, err = json2wasm.Module2Wasm(module)
+	assert.EqualError(t, err, "module 2 wasm error: generate section error: custom generator dylink: mem_info subsection without mem_info")
+}
+
 //func readWasmModule(path string) ([]tool.JSON, error) {
 //	var jsonArr []tool.JSON
 //	jsonData, err := ioutil.ReadFile(path)
@@ -174,3 +282,291 @@ func TestText2Json(t *testing.T) {
 
 	assert.Equal(t, true, assert.ObjectsAreEqual(expected, json))
 }
+
+func TestModuleJSONRoundTrip(t *testing.T) {
+	for _, dirName := range []string{path.Join("testdata", "wasm"), path.Join("testdata", "in", "wasm")} {
+		dir, err := ioutil.ReadDir(dirName)
+		assert.Nil(t, err)
+		for _, fi := range dir {
+			if fi.IsDir() {
+				continue
+			}
+
+			wasm, err := ioutil.ReadFile(path.Join(dirName, fi.Name()))
+			assert.Nil(t, err)
+			module, err := wasm2json.Wasm2Module(wasm)
+			assert.Nil(t, err)
+
+			text, err := tool.MarshalModuleJSON(module)
+			assert.Nil(t, err)
+			module, err = tool.UnmarshalModuleJSON(text)
+			if !assert.Nil(t, err, fi.Name()) {
+				continue
+			}
+			wasmBin, err := json2wasm.Module2Wasm(module)
+			assert.Nil(t, err)
+			assert.Equal(t, wasm, wasmBin, fi.Name())
+		}
+	}
+}
+
+func TestUnmarshalToolkitJSON(t *testing.T) {
+	text, err := ioutil.ReadFile(path.Join("testdata", "json", "basic.wast.json"))
+	assert.Nil(t, err)
+	module, err := tool.UnmarshalModuleJSON(text)
+	assert.Nil(t, err)
+
+	code := module.CodeSec().Entries[0].Code
+	assert.Equal(t, tool.NewOP("local.get", uint32(0)), code[0])
+	assert.Equal(t, tool.NewOP("i32.add", nil), code[2])
+	assert.Equal(t, []string{"i32"}, module.TypeSec().Entries[0].Returns)
+}
+
+func TestDecodeLEB128(t *testing.T) {
+	u, err := tool.DecodeULEB128(tool.NewStream([]byte("\xff\xff\xff\xff\x0f")))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0xffffffff), u)
+	_, err = tool.DecodeULEB128(tool.NewStream([]byte("\x82\x80\x80\x80\x80\x00")))
+	assert.EqualError(t, err, "integer representation too long")
+	_, err = tool.DecodeULEB128(tool.NewStream([]byte("\x80\x80\x80\x80\x10")))
+	assert.EqualError(t, err, "integer too large")
+
+	s, err := tool.DecodeSLEB128(tool.NewStream([]byte("\xff\xff\xff\xff\x7f")))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(-1), s)
+	_, err = tool.DecodeSLEB128(tool.NewStream([]byte("\xff\xff\xff\xff\xff\x7f")))
+	assert.EqualError(t, err, "integer representation too long")
+	_, err = tool.DecodeSLEB128(tool.NewStream([]byte("\x80\x80\x80\x80\x70")))
+	assert.EqualError(t, err, "integer too large")
+
+	s64, err := tool.DecodeSLEB128Int64(tool.NewStream([]byte("\xff\xff\xff\xff\xff\xff\xff\xff\xff\x7f")))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), s64)
+	_, err = tool.DecodeSLEB128Int64(tool.NewStream([]byte("\x80\x80\x80\x80\x80\x80\x80\x80\x80\x02")))
+	assert.EqualError(t, err, "integer too large")
+}
+
+func TestOP(t *testing.T) {
+	// an instruction is its opcode and its immediate, named by OPCODES.
+	op := tool.NewOP("i64.const", int64(-1))
+	assert.Equal(t, tool.OP{Opcode: 0x42, Immediates: int64(-1)}, op)
+	assert.Equal(t, "const", op.Name())
+	assert.Equal(t, "i64", op.ReturnType())
+	assert.Equal(t, "i64.const", op.FullName())
+	assert.Equal(t, "", tool.NewOP("nop", nil).ReturnType())
+	for opcode, name := range tool.OPCODES {
+		if name != "select" {
+			assert.Equal(t, name, tool.OP{Opcode: opcode}.FullName())
+			assert.Equal(t, opcode, tool.NewOP(name, nil).Opcode)
+		}
+	}
+	opcode, ok := tool.Opcode("select")
+	assert.True(t, ok)
+	assert.Equal(t, byte(0x1b), opcode)
+	_, ok = tool.Opcode("i32.unknown")
+	assert.False(t, ok)
+	assert.Panics(t, func() { tool.NewOP("i32.unknown", nil) })
+
+	// instructions serialize by name, as in the JSON text form.
+	text, err := json.Marshal([]tool.OP{op, tool.NewOP("local.get", uint32(1)), tool.NewOP("i32.add", nil)})
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"name":"const","return_type":"i64","immediates":"-1"},{"name":"get","return_type":"local","immediates":"1"},{"name":"add","return_type":"i32"}]`, string(text))
+	var ops []tool.OP
+	assert.Nil(t, json.Unmarshal(text, &ops))
+	assert.Equal(t, []tool.OP{op, tool.NewOP("local.get", uint32(1)), tool.NewOP("i32.add", nil)}, ops)
+	assert.EqualError(t, json.Unmarshal([]byte(`[{"name":"unknown","return_type":"i32"}]`), &ops), "unknown operator i32.unknown")
+}
+
+func TestWasm2ModuleStructure(t *testing.T) {
+	const (
+		preramble = "\x00asm\x01\x00\x00\x00"
+		typeSec   = "\x01\x04\x01\x60\x00\x00"
+		funcSec   = "\x03\x02\x01\x00"
+		codeSec   = "\x0a\x04\x01\x02\x00\x0b"
+	)
+	for _, c := range []struct {
+		wasm string
+		err  string
+	}{
+		{"\x00as", "unexpected end of preramble"},
+		{"\x00asn\x01\x00\x00\x00", "magic header not detected"},
+		{"\x00asm\x01", "unexpected end of preramble"},
+		{"\x00asm\x02\x00\x00\x00", "unknown binary version 02000000"},
+		{preramble + typeSec + typeSec, "duplicate type section"},
+		{preramble + funcSec + typeSec, "type section out of order after function section"},
+		{preramble + "\x01\x05\x01\x60\x00\x00\x00", "type section size mismatch: declared 5 bytes, decoded 4"},
+		{preramble + "\x01\x08\x01\x60\x00\x00", "unexpected end of section 1"},
+		{preramble + "\x0e\x01\x00", "unknown section id: 14"},
+		{preramble + typeSec + funcSec, "function and code section have inconsistent lengths 1 and 0"},
+		{preramble + typeSec + funcSec + "\x0a\x04\x01\x02\x00\x01", "function body 0: END opcode expected"},
+		{preramble + typeSec + funcSec + "\x0a\x05\x01\x03\x00\x0b\x01", "function body 0: operators after the end of the body"},
+		{preramble + "\x00\x00", "unexpected end of custom section"},
+		{preramble + "\x01", "unexpected end of section header 1"},
+		{preramble + "\x01\x02\x01\x60", "unexpected end of type section"},
+		{preramble + "\x01\x03\x01\x60\x01", "length out of bounds: 1 entries, 0 bytes left"},
+		{preramble + "\x01\x06\xff\xff\xff\x7f\x60\x00", "length out of bounds: 268435455 entries, 2 bytes left"},
+		// a module of 30 bytes declaring 268435455 locals.
+		{preramble + typeSec + funcSec + "\x0a\x09\x01\x07\xff\xff\xff\x7f\x01\x7f\x0b", "length out of bounds: 268435455 entries, 3 bytes left"},
+		// opcodes without an instruction are not decoded as one.
+		{preramble + typeSec + funcSec + "\x0a\x05\x01\x03\x00\xff\x0b", "illegal opcode 0xff"},
+		{preramble + typeSec + funcSec + "\x0a\x05\x01\x03\x00\x06\x0b", "illegal opcode 0x6"},
+		{preramble + typeSec + funcSec + "\x0a\x06\x01\x04\x00\xd0\x70\x0b", "unsupported opcode 0xd0"},
+		{preramble + "\x05\x03\x01\x04\x00", "malformed limits flags 0x4"},
+		{preramble + "\x04\x04\x01\x70\x02\x00", "malformed limits flags 0x2"},
+	} {
+		_, err := wasm2json.Wasm2Module([]byte(c.wasm))
+		assert.EqualError(t, err, c.err)
+		_, _, err = metering.MeterWASM([]byte(c.wasm), nil)
+		assert.NotNil(t, err)
+	}
+
+	// custom sections may appear anywhere.
+	wasm := preramble + "\x00\x02\x01a" + typeSec + "\x00\x02\x01b" + funcSec + codeSec + "\x00\x02\x01c"
+	module, err := wasm2json.Wasm2Module([]byte(wasm))
+	assert.Nil(t, err)
+	assert.Len(t, module.Sections, 6)
+
+	// the lenient mode skips unknown sections.
+	wasm = preramble + typeSec + "\x0e\x02\xff\xff" + funcSec + codeSec
+	_, err = wasm2json.Wasm2Json([]byte(wasm))
+	assert.EqualError(t, err, "unknown section id: 14")
+	jsonObj, err := wasm2json.Wasm2JsonWithOptions([]byte(wasm), wasm2json.Options{Lenient: true})
+	assert.Nil(t, err)
+	assert.Len(t, jsonObj, 4)
+}
+
+func TestWasm2ModuleLazyCode(t *testing.T) {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "ledger_test_gc.wasm"))
+	assert.Nil(t, err)
+	module, err := wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+	lazy, err := wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+
+	// bodies are decoded when accessed, and copied as they are otherwise.
+	code := lazy.CodeSec()
+	assert.Equal(t, module.CodeSec().Len(), code.Len())
+	assert.False(t, code.Decoded(1))
+	body, err := code.Body(1)
+	assert.Nil(t, err)
+	assert.Equal(t, module.CodeSec().Entries[1], *body)
+	assert.True(t, code.Decoded(1))
+	assert.False(t, code.Decoded(2))
+
+	expected, err := json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	encoded, err := json2wasm.Module2Wasm(lazy)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, encoded)
+
+	// offsets point at the size of the bodies in the module.
+	raw, ok := code.RawBody(2)
+	assert.True(t, ok)
+	offset, ok := code.BodyOffset(2)
+	assert.True(t, ok)
+	stream := tool.NewStream(wasm[offset:])
+	size, err := tool.DecodeULEB128(stream)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(len(raw)), size)
+	assert.True(t, bytes.HasPrefix(wasm[offset+stream.BytesRead:], raw))
+
+	assert.Nil(t, lazy.DecodeCode())
+	assert.Equal(t, module.CodeSec().Entries, code.Entries)
+	_, ok = code.RawBody(2)
+	assert.False(t, ok)
+
+	// passes decode the bodies they need.
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	expectedGas, err := newMetering().MeterModule(module)
+	assert.Nil(t, err)
+	gasCost, err := newMetering().MeterModule(lazy)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedGas, gasCost)
+	assert.Equal(t, module.CodeSec().Entries, lazy.CodeSec().Entries)
+
+	// assigning an entry replaces the body, without decoding it.
+	module, err = wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	replacement := tool.CodeBody{Locals: []tool.LocalEntry{}, Code: []tool.OP{tool.NewOP("unreachable", nil), tool.NewOP("end", nil)}}
+	lazy.CodeSec().Entries[2] = replacement
+	assert.True(t, lazy.CodeSec().Decoded(2))
+	body, err = lazy.CodeSec().Body(2)
+	assert.Nil(t, err)
+	assert.Equal(t, replacement, *body)
+	encoded, err = json2wasm.Module2Wasm(lazy)
+	assert.Nil(t, err)
+	module.CodeSec().Entries[2] = replacement
+	expected, err = json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, encoded)
+
+	// an empty entry replaces the body too, and appended bodies are encoded.
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	code = lazy.CodeSec()
+	code.Entries[2] = tool.CodeBody{}
+	assert.True(t, code.Decoded(2))
+	code.Entries = append(code.Entries, replacement)
+	code.SetBody(code.Len(), replacement)
+	encoded, err = json2wasm.Module2Wasm(lazy)
+	assert.Nil(t, err)
+	eager, err := wasm2json.Wasm2Module(wasm)
+	assert.Nil(t, err)
+	eager.CodeSec().Entries[2] = tool.CodeBody{}
+	eager.CodeSec().Entries = append(eager.CodeSec().Entries, replacement, replacement)
+	expected, err = json2wasm.Module2Wasm(eager)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, encoded)
+
+	// moved bodies keep their bytes.
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	code = lazy.CodeSec()
+	raw, _ = code.RawBody(2)
+	code.Entries[1], code.Entries[2] = code.Entries[2], code.Entries[1]
+	moved, ok := code.RawBody(1)
+	assert.True(t, ok)
+	assert.Equal(t, raw, moved)
+	_, ok = code.RawBody(code.Len())
+	assert.False(t, ok)
+
+	// the JSON form of a module decoded lazily holds its bodies.
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	lazyJSON, err := tool.ModuleToJSON(lazy)
+	assert.Nil(t, err)
+	moduleJSON, err := wasm2json.Wasm2Json(wasm)
+	assert.Nil(t, err)
+	assert.Equal(t, moduleJSON, lazyJSON)
+
+	// bodies can be decoded concurrently.
+	lazy, err = wasm2json.Wasm2ModuleWithOptions(wasm, wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	code = lazy.CodeSec()
+	assert.Nil(t, tool.Parallel(2*code.Len(), 4, func(i int) error {
+		_, err := code.Body(i % code.Len())
+		return err
+	}))
+	assert.Equal(t, module.CodeSec().Entries[:2], code.Entries[:2])
+	assert.Equal(t, module.CodeSec().Entries[3:], code.Entries[3:])
+
+	// errors in a body show when it is decoded.
+	const (
+		preramble = "\x00asm\x01\x00\x00\x00"
+		typeSec   = "\x01\x04\x01\x60\x00\x00"
+		funcSec   = "\x03\x03\x02\x00\x00"
+		codeSec   = "\x0a\x07\x02\x02\x00\x0b\x02\x00\x01"
+	)
+	lazy, err = wasm2json.Wasm2ModuleWithOptions([]byte(preramble+typeSec+funcSec+codeSec), wasm2json.Options{LazyCode: true})
+	assert.Nil(t, err)
+	_, err = lazy.CodeSec().Body(0)
+	assert.Nil(t, err)
+	_, err = lazy.CodeSec().Body(1)
+	assert.EqualError(t, err, "function body 1: END opcode expected")
+	assert.EqualError(t, lazy.DecodeCode(), "function body 1: END opcode expected")
+	_, err = wasm2json.Wasm2ModuleWithOptions([]byte(preramble+typeSec+funcSec+"\x0a\x05\x02\x02\x00\x0b\x02"), wasm2json.Options{LazyCode: true})
+	assert.EqualError(t, err, "function body 1: unexpected end of code section")
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	"github.com/meshplus/go-wasm-metering/validate"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+// knownFailures lists, by script and line, the commands of the spec scripts
+// that do not pass yet.
+var knownFailures = map[string][]int{
+	"binary.wast": {
+		// missing end at the end of the code section, reported as such
+		// instead of a section size mismatch.
+		107,
+	},
+}
+
+func TestSpecScripts(t *testing.T) {
+	dir, err := ioutil.ReadDir(path.Join("testdata", "spec"))
+	assert.Nil(t, err)
+	for _, file := range dir {
+		text, err := ioutil.ReadFile(path.Join("testdata", "spec", file.Name()))
+		assert.Nil(t, err)
+		results, err := wat.RunScript(string(text), wat.ScriptOptions{Validate: validate.Module})
+		if !assert.Nil(t, err, file.Name()) {
+			continue
+		}
+
+		known := map[int]bool{}
+		for _, line := range knownFailures[file.Name()] {
+			known[line] = true
+		}
+		for _, res := range results {
+			switch {
+			case res.Skipped:
+			case res.Passed():
+				assert.False(t, known[res.Line], "%s:%d passes, remove it from the known failures", file.Name(), res.Line)
+			default:
+				assert.True(t, known[res.Line], "%s:%s", file.Name(), res)
+			}
+		}
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	results, err := wat.RunScript(`
+(module (func (export "f") (result i32) (i32.const 1)))
+(assert_return (invoke "f") (i32.const 1))
+(assert_malformed (module quote "(func (i32.const))") "unexpected token")
+(assert_malformed (module quote "(func)") "not malformed")
+(assert_invalid (module (func (result i32))) "type mismatch")
+(assert_malformed (module binary "\00asm\01\00\00\00\0e\01\00") "length out of bounds")`, wat.ScriptOptions{})
+	assert.Nil(t, err)
+	if assert.Len(t, results, 6) {
+		assert.True(t, results[0].Passed())
+		assert.True(t, results[1].Skipped)
+		assert.True(t, results[2].Passed())
+		assert.Equal(t, `5: assert_malformed: module is not malformed, expected "not malformed"`, results[3].String())
+		// assert_invalid needs a validator.
+		assert.True(t, results[4].Skipped)
+		// modules failing for another reason than expected do not pass.
+		assert.Equal(t, `7: assert_malformed: module is malformed, expected "length out of bounds": wat 2 module error: unknown section id: 14`, results[5].String())
+	}
+
+	_, err = wat.RunScript(`(assert_bogus (module))`, wat.ScriptOptions{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	metering "github.com/meshplus/go-wasm-metering"
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+	"github.com/meshplus/go-wasm-metering/wat"
+	"github.com/stretchr/testify/assert"
+)
+
+const addTwoWat = `
+(module
+  (func $addTwo (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add)
+  (export "addTwo" (func $addTwo)))`
+
+func TestWasm2Wat(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(addTwoWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+
+	flat, err := wat.Wasm2Wat(wasm, wat.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, `(module
+  (type (;0;) (func (param i32 i32) (result i32)))
+  (func (;0;) (type 0) (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add
+  )
+  (export "addTwo" (func 0))
+)
+`, flat)
+
+	folded, err := wat.Wasm2Wat(wasm, wat.Options{Folded: true})
+	assert.Nil(t, err)
+	assert.Contains(t, folded, "    (i32.add (local.get 0) (local.get 1))\n")
+}
+
+func TestMeteredWat(t *testing.T) {
+	wasm, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", "ledger_test_gc.wasm"))
+	assert.Nil(t, err)
+	metered, _, err := metering.MeterWASM(wasm, &metering.Options{
+		CostTable: metering.DefaultCostTable,
+	})
+	assert.Nil(t, err)
+
+	module, err := wasm2json.Wasm2Module(metered)
+	assert.Nil(t, err)
+	text, err := wat.Module2Wat(module, wat.Options{})
+	assert.Nil(t, err)
+	assert.Contains(t, text, `(import "metering" "usegas" (func $metering.usegas (type `)
+	assert.Contains(t, text, "(func $__rust_alloc (type 2) (param i32 i32) (result i32)\n")
+
+	// the metered function is printed on its own.
+	fn, err := wat.Func2Wat(module, uint32(module.ImportedFuncs()), wat.Options{Folded: true})
+	assert.Nil(t, err)
+	assert.Contains(t, fn, "(call $metering.usegas (i64.const ")
+
+	_, err = wat.Func2Wat(module, 0, wat.Options{})
+	assert.NotNil(t, err)
+}
+
+func TestWasm2WatTestdata(t *testing.T) {
+	dir, err := ioutil.ReadDir(path.Join("testdata", "wasm"))
+	assert.Nil(t, err)
+	for _, file := range dir {
+		wasm, err := ioutil.ReadFile(path.Join("testdata", "wasm", file.Name()))
+		assert.Nil(t, err)
+		for _, folded := range []bool{false, true} {
+			_, err = wat.Wasm2Wat(wasm, wat.Options{Folded: folded})
+			assert.Nil(t, err, file.Name())
+		}
+	}
+}
+
+func TestWat2Wasm(t *testing.T) {
+	wasm, err := wat.Wat2Wasm(addTwoWat, wat.ParseOptions{})
+	assert.Nil(t, err)
+	expected, err := ioutil.ReadFile(path.Join("testdata", "addTwo.wasm"))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, wasm)
+
+	dir, err := ioutil.ReadDir(path.Join("testdata", "in", "wast"))
+	assert.Nil(t, err)
+	for _, file := range dir {
+		text, err := ioutil.ReadFile(path.Join("testdata", "in", "wast", file.Name()))
+		assert.Nil(t, err)
+		wasm, err := wat.Wat2Wasm(string(text), wat.ParseOptions{})
+		assert.Nil(t, err, file.Name())
+
+		expected, err := ioutil.ReadFile(path.Join("testdata", "in", "wasm", strings.TrimSuffix(file.Name(), ".wast")+".wasm"))
+		if err == nil {
+			assert.Equal(t, expected, wasm, file.Name())
+		}
+	}
+}
+
+func TestWat2Module(t *testing.T) {
+	module, err := wat.Wat2Module(`
+(module
+  (type $sig (func (param i32) (result i32)))
+  (import "env" "log" (func $log (param i32)))
+  (memory (export "memory") 1)
+  (global $counter (mut i32) (i32.const 0))
+  (table 2 funcref)
+  (elem (i32.const 0) $double $double)
+  (data (i32.const 8) "hi\00")
+  (func $double (type $sig) (param $x i32) (result i32)
+    (local $tmp i32)
+    (block $exit
+      (loop $again
+        (br_if $exit (i32.eqz (local.get $x)))
+        (local.set $tmp (i32.add (local.get $tmp) (i32.const 2)))
+        (local.set $x (i32.sub (local.get $x) (i32.const 1)))
+        br $again))
+    (call $log (local.get $tmp))
+    (global.set $counter (local.get $tmp))
+    local.get $tmp)
+  (@custom "meta" (after type) "\01\02"))`, wat.ParseOptions{Names: true})
+	assert.Nil(t, err)
+
+	var names []string
+	for _, section := range module.Sections {
+		name := section.SecName()
+		if custom, ok := section.(*tool.CustomSec); ok {
+			name = custom.SectionName
+		}
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"type", "meta", "import", "function", "table", "memory", "global", "export", "element", "code", "data", "name"}, names)
+	assert.Equal(t, []byte{1, 2}, module.CustomSecs("meta")[0].Custom)
+	assert.Equal(t, []uint32{1, 1}, module.ElementSec().Entries[0].Elements)
+	assert.Equal(t, []byte("hi\x00"), module.DataSec().Entries[0].Data)
+
+	code := module.CodeSec().Entries[0]
+	assert.Equal(t, []tool.LocalEntry{{Count: 1, Type: "i32"}}, code.Locals)
+	assert.Equal(t, tool.NewOP("br_if", uint32(1)), code.Code[4])
+	assert.Equal(t, tool.NewOP("br", uint32(0)), code.Code[13])
+
+	// the module is valid wasm and prints back with its names.
+	wasm, err := json2wasm.Module2Wasm(module)
+	assert.Nil(t, err)
+	text, err := wat.Wasm2Wat(wasm, wat.Options{})
+	assert.Nil(t, err)
+	assert.Contains(t, text, "(func $double (type 0) (param $x i32) (result i32)\n    (local $tmp i32)\n")
+	assert.Contains(t, text, "call $log\n")
+	assert.Contains(t, text, `(@custom "meta" (after type) "\01\02")`)
+}
+
+func TestWat2ModuleErrors(t *testing.T) {
+	for text, msg := range map[string]string{
+		`(module (func i32.foo))`:                    "1:15: unknown operator i32.foo",
+		`(module (func br $missing))`:                "1:18: unknown label $missing",
+		`(module (func (call $f)))`:                  "1:21: unknown func $f",
+		`(module (func block i32.const 0))`:          "unclosed block",
+		`(module (func (i32.const 0x1_0000_0000)))`:  "1:26: invalid i32 literal 0x1_0000_0000",
+		`(module (data (i32.const 0) "\zz"))`:        "1:29: invalid escape \\zz",
+		`(module (func $f) (func $f))`:               "1:25: duplicate func $f",
+		`(module (@custom "x" (after nowhere) "y"))`: "1:22: unknown section nowhere",
+	} {
+		_, err := wat.Wat2Module(text, wat.ParseOptions{})
+		if assert.NotNil(t, err, text) {
+			assert.Contains(t, err.Error(), msg, text)
+		}
+	}
+}
+
+func TestWatRoundTrip(t *testing.T) {
+	dir, err := ioutil.ReadDir(path.Join("testdata", "wasm"))
+	assert.Nil(t, err)
+	for _, file := range dir {
+		wasm, err := ioutil.ReadFile(path.Join("testdata", "wasm", file.Name()))
+		assert.Nil(t, err)
+		// names are printed as identifiers, so compare without the name section.
+		module, err := wasm2json.Wasm2Module(wasm)
+		assert.Nil(t, err)
+		module.RemoveCustoms("name")
+		expected, err := json2wasm.Module2Wasm(module)
+		assert.Nil(t, err)
+
+		for _, folded := range []bool{false, true} {
+			text, err := wat.Module2Wat(module, wat.Options{Folded: folded})
+			assert.Nil(t, err, file.Name())
+			res, err := wat.Wat2Wasm(text, wat.ParseOptions{})
+			assert.Nil(t, err, file.Name())
+			assert.Equal(t, expected, res, file.Name())
+		}
+	}
+}
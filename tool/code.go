@@ -0,0 +1,130 @@
+package tool
+
+import "sync"
+
+// CodeDecoder decodes the function body at `index` in the code section from
+// its bytes, following its size.
+type CodeDecoder func(body []byte, index uint32) (CodeBody, error)
+
+// rawBody is a function body not decoded yet, kept by its entry so that it
+// follows the entry when bodies are appended or moved.
+type rawBody struct {
+	bytes  []byte
+	offset int
+	decode CodeDecoder
+}
+
+// NewLazyCodeSec returns a code section of the bodies in `raw`, each at
+// offset `offsets[i]` of the module, decoded by `decode` when accessed.
+func NewLazyCodeSec(raw [][]byte, offsets []int, decode CodeDecoder) *CodeSec {
+	entries := make([]CodeBody, len(raw))
+	for i := range raw {
+		entries[i].raw = &rawBody{bytes: raw[i], offset: offsets[i], decode: decode}
+	}
+	return &CodeSec{Name: "code", Entries: entries, mu: &sync.Mutex{}}
+}
+
+// Len returns the number of function bodies.
+func (s *CodeSec) Len() int {
+	return len(s.Entries)
+}
+
+// Decoded reports whether the function body at `i` is decoded, or was
+// replaced by assigning its entry.
+func (s *CodeSec) Decoded(i int) bool {
+	return s.rawBody(i) == nil
+}
+
+// RawBody returns the bytes of the function body at `i`, following its size,
+// if it is not decoded yet.
+func (s *CodeSec) RawBody(i int) ([]byte, bool) {
+	if raw := s.rawBody(i); raw != nil {
+		return raw.bytes, true
+	}
+	return nil, false
+}
+
+// BodyOffset returns the offset in the module of the function body at `i`,
+// starting with its size, if it is not decoded yet.
+func (s *CodeSec) BodyOffset(i int) (int, bool) {
+	if raw := s.rawBody(i); raw != nil {
+		return raw.offset, true
+	}
+	return 0, false
+}
+
+// SetBody replaces the function body at `i`, appending it at the end of the
+// section if `i` is its length.
+func (s *CodeSec) SetBody(i int, body CodeBody) {
+	s.lock()
+	defer s.unlock()
+	body.raw = nil
+	if i == len(s.Entries) {
+		s.Entries = append(s.Entries, body)
+		return
+	}
+	s.Entries[i] = body
+}
+
+func (s *CodeSec) lock() {
+	if s.mu != nil {
+		s.mu.Lock()
+	}
+}
+
+func (s *CodeSec) unlock() {
+	if s.mu != nil {
+		s.mu.Unlock()
+	}
+}
+
+func (s *CodeSec) rawBody(i int) *rawBody {
+	s.lock()
+	defer s.unlock()
+	return s.pending(i)
+}
+
+// pending returns the body at `i` if it is neither decoded nor replaced, with
+// the lock held. An entry copied with its bytes and then given locals or code
+// is replaced.
+func (s *CodeSec) pending(i int) *rawBody {
+	if i < 0 || i >= len(s.Entries) {
+		return nil
+	}
+	entry := s.Entries[i]
+	if entry.raw == nil || len(entry.Locals) != 0 || len(entry.Code) != 0 {
+		return nil
+	}
+	return entry.raw
+}
+
+// Body returns the function body at `i`, decoding it if needed. Body and
+// Decode are safe for concurrent use on a section decoded lazily, bodies
+// being decoded outside of the lock.
+func (s *CodeSec) Body(i int) (*CodeBody, error) {
+	raw := s.rawBody(i)
+	if raw == nil {
+		return &s.Entries[i], nil
+	}
+	body, err := raw.decode(raw.bytes, uint32(i))
+	if err != nil {
+		return nil, err
+	}
+	s.lock()
+	defer s.unlock()
+	if s.pending(i) == raw {
+		s.Entries[i] = body
+	}
+	return &s.Entries[i], nil
+}
+
+// Decode decodes all of the function bodies not decoded yet, after which
+// Entries can be used directly.
+func (s *CodeSec) Decode() error {
+	for i := range s.Entries {
+		if _, err := s.Body(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
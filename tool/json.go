@@ -0,0 +1,290 @@
+package tool
+
+import "fmt"
+
+// ModuleToJSON converts a module to the JSON array form of Wasm2Json,
+// decoding the function bodies of a code section decoded lazily.
+func ModuleToJSON(m *Module) ([]JSON, error) {
+	res := make([]JSON, 0, len(m.Sections)+1)
+	res = append(res, JSON{
+		"name":    "preramble",
+		"magic":   m.Magic,
+		"version": m.Version,
+	})
+	for _, section := range m.Sections {
+		j, err := SectionToJSON(section)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, j)
+	}
+	return res, nil
+}
+
+// SectionToJSON converts a typed section to its JSON object form.
+func SectionToJSON(section Section) (JSON, error) {
+	j := JSON{"name": section.SecName()}
+	switch sec := section.(type) {
+	case *CustomSec:
+		j["section_name"] = sec.SectionName
+		j["custom"] = sec.Custom
+	case *TypeSec:
+		j["entries"] = sec.Entries
+	case *ImportSec:
+		j["entries"] = sec.Entries
+	case *FuncSec:
+		j["entries"] = sec.Entries
+	case *TableSec:
+		j["entries"] = sec.Entries
+	case *MemSec:
+		j["entries"] = sec.Entries
+	case *GlobalSec:
+		entries := make([]GlobalEntry, len(sec.Entries))
+		for i, entry := range sec.Entries {
+			entry.Init = OPToJSON(entry.Init)
+			entries[i] = entry
+		}
+		j["entries"] = entries
+	case *ExportSec:
+		j["entries"] = sec.Entries
+	case *StartSec:
+		j["index"] = sec.Index
+	case *ElementSec:
+		entries := make([]ElementEntry, len(sec.Entries))
+		for i, entry := range sec.Entries {
+			entry.Offset = OPToJSON(entry.Offset)
+			entries[i] = entry
+		}
+		j["entries"] = entries
+	case *CodeSec:
+		if err := sec.Decode(); err != nil {
+			return nil, err
+		}
+		entries := make([]CodeBody, len(sec.Entries))
+		for i, entry := range sec.Entries {
+			code := make([]OP, len(entry.Code))
+			for k, op := range entry.Code {
+				code[k] = OPToJSON(op)
+			}
+			entry.Code = code
+			entries[i] = entry
+		}
+		j["entries"] = entries
+	case *DataSec:
+		entries := make([]DataSegment, len(sec.Entries))
+		for i, entry := range sec.Entries {
+			entry.Offset = OPToJSON(entry.Offset)
+			entries[i] = entry
+		}
+		j["entries"] = entries
+	case *DataCountSec:
+		j["count"] = sec.Count
+	}
+	return j, nil
+}
+
+// ModuleFromJSON converts the JSON array form of Wasm2Json to a module.
+func ModuleFromJSON(j []JSON) (*Module, error) {
+	if len(j) == 0 {
+		return nil, fmt.Errorf("module from json: missing preramble")
+	}
+	m := &Module{
+		Magic:    Interface2Bytes(j[0]["magic"]),
+		Version:  Interface2Bytes(j[0]["version"]),
+		Sections: make([]Section, 0, len(j)-1),
+	}
+	for _, item := range j[1:] {
+		section, err := SectionFromJSON(item)
+		if err != nil {
+			return nil, fmt.Errorf("module from json: %w", err)
+		}
+		m.Sections = append(m.Sections, section)
+	}
+	return m, nil
+}
+
+// SectionFromJSON converts a JSON object to its typed section.
+func SectionFromJSON(j JSON) (Section, error) {
+	name, _ := j["name"].(string)
+	ientries, hasEntries := j["entries"]
+
+	var (
+		section Section
+		ok      = true
+	)
+	switch name {
+	case "custom":
+		sec := &CustomSec{Name: name}
+		sec.SectionName, ok = j["section_name"].(string)
+		sec.Custom = j["custom"]
+		section = sec
+	case "type":
+		sec := &TypeSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]TypeEntry)
+		}
+		section = sec
+	case "import":
+		sec := &ImportSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]ImportEntry)
+		}
+		section = sec
+	case "function":
+		sec := &FuncSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]uint32)
+		}
+		section = sec
+	case "table":
+		sec := &TableSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]Table)
+		}
+		section = sec
+	case "memory":
+		sec := &MemSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]MemLimits)
+		}
+		section = sec
+	case "global":
+		sec := &GlobalSec{Name: name}
+		if hasEntries {
+			var entries []GlobalEntry
+			if entries, ok = ientries.([]GlobalEntry); ok {
+				sec.Entries = make([]GlobalEntry, len(entries))
+				for i, entry := range entries {
+					entry.Init, ok = OPFromJSON(entry.Init)
+					if !ok {
+						return nil, fmt.Errorf("malformed %s section", name)
+					}
+					sec.Entries[i] = entry
+				}
+			}
+		}
+		section = sec
+	case "export":
+		sec := &ExportSec{Name: name}
+		if hasEntries {
+			sec.Entries, ok = ientries.([]ExportEntry)
+		}
+		section = sec
+	case "start":
+		sec := &StartSec{Name: name}
+		sec.Index, ok = j["index"].(uint32)
+		section = sec
+	case "element":
+		sec := &ElementSec{Name: name}
+		if hasEntries {
+			var entries []ElementEntry
+			if entries, ok = ientries.([]ElementEntry); ok {
+				sec.Entries = make([]ElementEntry, len(entries))
+				for i, entry := range entries {
+					entry.Offset, ok = OPFromJSON(entry.Offset)
+					if !ok {
+						return nil, fmt.Errorf("malformed %s section", name)
+					}
+					sec.Entries[i] = entry
+				}
+			}
+		}
+		section = sec
+	case "code":
+		sec := &CodeSec{Name: name}
+		if hasEntries {
+			var entries []CodeBody
+			if entries, ok = ientries.([]CodeBody); ok {
+				sec.Entries = make([]CodeBody, len(entries))
+				for i, entry := range entries {
+					code := make([]OP, len(entry.Code))
+					for k, op := range entry.Code {
+						if code[k], ok = OPFromJSON(op); !ok {
+							return nil, fmt.Errorf("malformed %s section", name)
+						}
+					}
+					entry.Code = code
+					sec.Entries[i] = entry
+				}
+			}
+		}
+		section = sec
+	case "data":
+		sec := &DataSec{Name: name}
+		if hasEntries {
+			var entries []DataSegment
+			if entries, ok = ientries.([]DataSegment); ok {
+				sec.Entries = make([]DataSegment, len(entries))
+				for i, entry := range entries {
+					entry.Offset, ok = OPFromJSON(entry.Offset)
+					if !ok {
+						return nil, fmt.Errorf("malformed %s section", name)
+					}
+					sec.Entries[i] = entry
+				}
+			}
+		}
+		section = sec
+	case "data count":
+		sec := &DataCountSec{Name: name}
+		sec.Count, ok = j["count"].(uint32)
+		section = sec
+	default:
+		return nil, fmt.Errorf("invalid section name: %s", name)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("malformed %s section", name)
+	}
+	return section, nil
+}
+
+// OPToJSON converts the typed immediates of an operator to their JSON form.
+func OPToJSON(op OP) OP {
+	switch imm := op.Immediates.(type) {
+	case BrTable:
+		op.Immediates = JSON{
+			"targets":        imm.Targets,
+			"default_target": imm.DefaultTarget,
+		}
+	case CallIndirect:
+		op.Immediates = JSON{
+			"index":    imm.Index,
+			"reserved": imm.Reserved,
+		}
+	case MemoryImmediate:
+		op.Immediates = JSON{
+			"flags":  imm.Flags,
+			"offset": imm.Offset,
+		}
+	}
+	return op
+}
+
+// OPFromJSON converts the JSON immediates of an operator to their typed form.
+func OPFromJSON(op OP) (OP, bool) {
+	j, isJSON := op.Immediates.(JSON)
+	if !isJSON {
+		return op, true
+	}
+
+	var ok1, ok2 bool
+	switch OP_IMMEDIATES[op.Name()] {
+	case "br_table":
+		imm := BrTable{}
+		imm.Targets, ok1 = j["targets"].([]uint32)
+		imm.DefaultTarget, ok2 = j["default_target"].(uint32)
+		op.Immediates = imm
+	case "call_indirect":
+		imm := CallIndirect{}
+		imm.Index, ok1 = j["index"].(uint32)
+		imm.Reserved, ok2 = j["reserved"].(byte)
+		op.Immediates = imm
+	case "memory_immediate":
+		imm := MemoryImmediate{}
+		imm.Flags, ok1 = j["flags"].(uint32)
+		imm.Offset, ok2 = j["offset"].(uint32)
+		op.Immediates = imm
+	}
+	return op, ok1 && ok2
+}
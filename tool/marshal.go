@@ -0,0 +1,736 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MarshalModuleJSON serializes a module to JSON text that UnmarshalModuleJSON
+// converts back to the same module. The schema follows the JSON produced by
+// ewasm's wasm-json-toolkit: a module is an array of section objects, the
+// first one being the preramble, and every object carries the `name` of its
+// section. See the README for the full schema.
+func MarshalModuleJSON(m *Module) ([]byte, error) {
+	sections := make([]jsonSection, 0, len(m.Sections)+1)
+	sections = append(sections, jsonSection{
+		Name:    "preramble",
+		Magic:   jsonBytes(m.Magic),
+		Version: jsonBytes(m.Version),
+	})
+	for _, section := range m.Sections {
+		sec, err := marshalSection(section)
+		if err != nil {
+			return nil, fmt.Errorf("marshal module json: %w", err)
+		}
+		sections = append(sections, sec)
+	}
+	return json.Marshal(sections)
+}
+
+// UnmarshalModuleJSON parses JSON text produced by MarshalModuleJSON.
+func UnmarshalModuleJSON(data []byte) (*Module, error) {
+	var sections []jsonSection
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("unmarshal module json: %w", err)
+	}
+	if len(sections) == 0 || sections[0].Name != "preramble" {
+		return nil, fmt.Errorf("unmarshal module json: missing preramble")
+	}
+
+	m := &Module{
+		Magic:    []byte(sections[0].Magic),
+		Version:  []byte(sections[0].Version),
+		Sections: make([]Section, 0, len(sections)-1),
+	}
+	for i, sec := range sections[1:] {
+		section, err := unmarshalSection(sec)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal module json: section %d: %w", i+1, err)
+		}
+		m.Sections = append(m.Sections, section)
+	}
+	return m, nil
+}
+
+// jsonBytes is a byte slice serialized as an array of numbers instead of base64.
+type jsonBytes []byte
+
+func (b jsonBytes) MarshalJSON() ([]byte, error) {
+	ints := make([]uint16, len(b))
+	for i, c := range b {
+		ints[i] = uint16(c)
+	}
+	return json.Marshal(ints)
+}
+
+func (b *jsonBytes) UnmarshalJSON(data []byte) error {
+	var ints []uint8Number
+	if err := json.Unmarshal(data, &ints); err != nil {
+		return err
+	}
+	*b = make(jsonBytes, len(ints))
+	for i, c := range ints {
+		(*b)[i] = byte(c)
+	}
+	return nil
+}
+
+// uint8Number is a byte decoded from a JSON number.
+type uint8Number uint8
+
+func (n *uint8Number) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseUint(string(data), 10, 8)
+	if err != nil {
+		return err
+	}
+	*n = uint8Number(v)
+	return nil
+}
+
+type jsonSection struct {
+	Name        string          `json:"name"`
+	Magic       jsonBytes       `json:"magic,omitempty"`
+	Version     jsonBytes       `json:"version,omitempty"`
+	SectionName string          `json:"section_name,omitempty"`
+	Custom      json.RawMessage `json:"custom,omitempty"`
+	Payload     *jsonBytes      `json:"payload,omitempty"`
+	Entries     json.RawMessage `json:"entries,omitempty"`
+	Index       *uint32         `json:"index,omitempty"`
+	Count       *uint32         `json:"count,omitempty"`
+}
+
+type jsonOP struct {
+	Name       string          `json:"name"`
+	ReturnType string          `json:"return_type,omitempty"`
+	Immediates json.RawMessage `json:"immediates,omitempty"`
+}
+
+type jsonLimits struct {
+	Flags   uint32  `json:"flags"`
+	Intial  uint32  `json:"intial"`
+	Maximum *uint32 `json:"maximum,omitempty"`
+}
+
+type jsonTable struct {
+	ElementType string     `json:"element_type"`
+	Limits      jsonLimits `json:"limits"`
+}
+
+type jsonImportEntry struct {
+	ModuleStr string          `json:"module_str"`
+	FieldStr  string          `json:"field_str"`
+	Kind      string          `json:"kind"`
+	Type      json.RawMessage `json:"type"`
+}
+
+type jsonGlobalEntry struct {
+	Type Global `json:"type"`
+	Init jsonOP `json:"init"`
+}
+
+type jsonElementEntry struct {
+	Index    uint32   `json:"index"`
+	Offset   jsonOP   `json:"offset"`
+	Elements []uint32 `json:"elements"`
+}
+
+type jsonCodeBody struct {
+	Locals []LocalEntry `json:"locals"`
+	Code   []jsonOP     `json:"code"`
+}
+
+type jsonDataSegment struct {
+	Index  uint32    `json:"index"`
+	Offset jsonOP    `json:"offset"`
+	Data   jsonBytes `json:"data"`
+}
+
+type jsonCustomName struct {
+	Kind  string          `json:"kind"`
+	Names json.RawMessage `json:"names"`
+}
+
+type jsonTargetFeature struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+}
+
+type jsonDylinkSubsection struct {
+	Id         byte               `json:"id"`
+	MemInfo    *DylinkMemInfo     `json:"mem_info,omitempty"`
+	Needed     []string           `json:"needed,omitempty"`
+	ExportInfo []DylinkExportInfo `json:"export_info,omitempty"`
+	ImportInfo []DylinkImportInfo `json:"import_info,omitempty"`
+	Payload    jsonBytes          `json:"payload,omitempty"`
+}
+
+func marshalSection(section Section) (jsonSection, error) {
+	sec := jsonSection{Name: section.SecName()}
+
+	var (
+		entries interface{}
+		err     error
+	)
+	switch s := section.(type) {
+	case *CustomSec:
+		sec.SectionName = s.SectionName
+		switch raw := s.Custom.(type) {
+		case []byte:
+			payload := jsonBytes(raw)
+			sec.Payload = &payload
+			return sec, nil
+		case string:
+			payload := jsonBytes(raw)
+			sec.Payload = &payload
+			return sec, nil
+		}
+		sec.Custom, err = marshalCustom(s.Custom)
+		return sec, err
+	case *StartSec:
+		sec.Index = &s.Index
+		return sec, nil
+	case *DataCountSec:
+		sec.Count = &s.Count
+		return sec, nil
+	case *TypeSec:
+		entries = s.Entries
+	case *ImportSec:
+		imports := make([]jsonImportEntry, len(s.Entries))
+		for i, entry := range s.Entries {
+			imports[i] = jsonImportEntry{
+				ModuleStr: entry.ModuleStr,
+				FieldStr:  entry.FieldStr,
+				Kind:      entry.Kind,
+			}
+			var typ interface{}
+			switch t := entry.Type.(type) {
+			case Table:
+				typ = marshalTable(t)
+			case MemLimits:
+				typ = marshalLimits(t)
+			default:
+				typ = t
+			}
+			if imports[i].Type, err = json.Marshal(typ); err != nil {
+				return jsonSection{}, err
+			}
+		}
+		entries = imports
+	case *FuncSec:
+		entries = s.Entries
+	case *TableSec:
+		tables := make([]jsonTable, len(s.Entries))
+		for i, entry := range s.Entries {
+			tables[i] = marshalTable(entry)
+		}
+		entries = tables
+	case *MemSec:
+		limits := make([]jsonLimits, len(s.Entries))
+		for i, entry := range s.Entries {
+			limits[i] = marshalLimits(entry)
+		}
+		entries = limits
+	case *GlobalSec:
+		globals := make([]jsonGlobalEntry, len(s.Entries))
+		for i, entry := range s.Entries {
+			globals[i].Type = entry.Type
+			if globals[i].Init, err = marshalOP(entry.Init); err != nil {
+				return jsonSection{}, err
+			}
+		}
+		entries = globals
+	case *ExportSec:
+		entries = s.Entries
+	case *ElementSec:
+		elements := make([]jsonElementEntry, len(s.Entries))
+		for i, entry := range s.Entries {
+			elements[i].Index = entry.Index
+			elements[i].Elements = entry.Elements
+			if elements[i].Offset, err = marshalOP(entry.Offset); err != nil {
+				return jsonSection{}, err
+			}
+		}
+		entries = elements
+	case *CodeSec:
+		if err := s.Decode(); err != nil {
+			return jsonSection{}, err
+		}
+		bodies := make([]jsonCodeBody, len(s.Entries))
+		for i, entry := range s.Entries {
+			bodies[i].Locals = entry.Locals
+			bodies[i].Code = make([]jsonOP, len(entry.Code))
+			for k, op := range entry.Code {
+				if bodies[i].Code[k], err = marshalOP(op); err != nil {
+					return jsonSection{}, fmt.Errorf("function %d: %w", i, err)
+				}
+			}
+		}
+		entries = bodies
+	case *DataSec:
+		segments := make([]jsonDataSegment, len(s.Entries))
+		for i, entry := range s.Entries {
+			segments[i].Index = entry.Index
+			segments[i].Data = entry.Data
+			if segments[i].Offset, err = marshalOP(entry.Offset); err != nil {
+				return jsonSection{}, err
+			}
+		}
+		entries = segments
+	default:
+		return jsonSection{}, fmt.Errorf("invalid section %s", section.SecName())
+	}
+
+	sec.Entries, err = json.Marshal(entries)
+	return sec, err
+}
+
+func unmarshalSection(sec jsonSection) (Section, error) {
+	var err error
+	switch sec.Name {
+	case "custom":
+		s := &CustomSec{Name: sec.Name, SectionName: sec.SectionName}
+		if sec.Payload != nil {
+			s.Custom = []byte(*sec.Payload)
+		} else {
+			s.Custom, err = unmarshalCustom(sec.SectionName, sec.Custom)
+		}
+		return s, err
+	case "start":
+		if sec.Index == nil {
+			return nil, fmt.Errorf("start section without index")
+		}
+		return &StartSec{Name: sec.Name, Index: *sec.Index}, nil
+	case "data count":
+		if sec.Count == nil {
+			return nil, fmt.Errorf("data count section without count")
+		}
+		return &DataCountSec{Name: sec.Name, Count: *sec.Count}, nil
+	case "type":
+		s := &TypeSec{Name: sec.Name, Entries: []TypeEntry{}}
+		return s, unmarshalEntries(sec.Entries, &s.Entries)
+	case "import":
+		var imports []jsonImportEntry
+		if err := unmarshalEntries(sec.Entries, &imports); err != nil {
+			return nil, err
+		}
+		s := &ImportSec{Name: sec.Name, Entries: make([]ImportEntry, len(imports))}
+		for i, entry := range imports {
+			s.Entries[i] = ImportEntry{
+				ModuleStr: entry.ModuleStr,
+				FieldStr:  entry.FieldStr,
+				Kind:      entry.Kind,
+			}
+			switch entry.Kind {
+			case "function":
+				var typ uint32
+				err = json.Unmarshal(entry.Type, &typ)
+				s.Entries[i].Type = typ
+			case "table":
+				var typ jsonTable
+				err = json.Unmarshal(entry.Type, &typ)
+				s.Entries[i].Type = unmarshalTable(typ)
+			case "memory":
+				var typ jsonLimits
+				err = json.Unmarshal(entry.Type, &typ)
+				s.Entries[i].Type = unmarshalLimits(typ)
+			case "global":
+				var typ Global
+				err = json.Unmarshal(entry.Type, &typ)
+				s.Entries[i].Type = typ
+			default:
+				return nil, fmt.Errorf("invalid import kind %s", entry.Kind)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case "function":
+		s := &FuncSec{Name: sec.Name, Entries: []uint32{}}
+		return s, unmarshalEntries(sec.Entries, &s.Entries)
+	case "table":
+		var tables []jsonTable
+		if err := unmarshalEntries(sec.Entries, &tables); err != nil {
+			return nil, err
+		}
+		s := &TableSec{Name: sec.Name, Entries: make([]Table, len(tables))}
+		for i, entry := range tables {
+			s.Entries[i] = unmarshalTable(entry)
+		}
+		return s, nil
+	case "memory":
+		var limits []jsonLimits
+		if err := unmarshalEntries(sec.Entries, &limits); err != nil {
+			return nil, err
+		}
+		s := &MemSec{Name: sec.Name, Entries: make([]MemLimits, len(limits))}
+		for i, entry := range limits {
+			s.Entries[i] = unmarshalLimits(entry)
+		}
+		return s, nil
+	case "global":
+		var globals []jsonGlobalEntry
+		if err := unmarshalEntries(sec.Entries, &globals); err != nil {
+			return nil, err
+		}
+		s := &GlobalSec{Name: sec.Name, Entries: make([]GlobalEntry, len(globals))}
+		for i, entry := range globals {
+			s.Entries[i].Type = entry.Type
+			if s.Entries[i].Init, err = unmarshalOP(entry.Init); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case "export":
+		s := &ExportSec{Name: sec.Name, Entries: []ExportEntry{}}
+		return s, unmarshalEntries(sec.Entries, &s.Entries)
+	case "element":
+		var elements []jsonElementEntry
+		if err := unmarshalEntries(sec.Entries, &elements); err != nil {
+			return nil, err
+		}
+		s := &ElementSec{Name: sec.Name, Entries: make([]ElementEntry, len(elements))}
+		for i, entry := range elements {
+			s.Entries[i].Index = entry.Index
+			s.Entries[i].Elements = entry.Elements
+			if s.Entries[i].Offset, err = unmarshalOP(entry.Offset); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case "code":
+		var bodies []jsonCodeBody
+		if err := unmarshalEntries(sec.Entries, &bodies); err != nil {
+			return nil, err
+		}
+		s := &CodeSec{Name: sec.Name, Entries: make([]CodeBody, len(bodies))}
+		for i, entry := range bodies {
+			s.Entries[i].Locals = entry.Locals
+			if s.Entries[i].Locals == nil {
+				s.Entries[i].Locals = []LocalEntry{}
+			}
+			s.Entries[i].Code = make([]OP, len(entry.Code))
+			for k, op := range entry.Code {
+				if s.Entries[i].Code[k], err = unmarshalOP(op); err != nil {
+					return nil, fmt.Errorf("function %d: %w", i, err)
+				}
+			}
+		}
+		return s, nil
+	case "data":
+		var segments []jsonDataSegment
+		if err := unmarshalEntries(sec.Entries, &segments); err != nil {
+			return nil, err
+		}
+		s := &DataSec{Name: sec.Name, Entries: make([]DataSegment, len(segments))}
+		for i, entry := range segments {
+			s.Entries[i].Index = entry.Index
+			s.Entries[i].Data = []byte(entry.Data)
+			if s.Entries[i].Offset, err = unmarshalOP(entry.Offset); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("invalid section name: %s", sec.Name)
+	}
+}
+
+func unmarshalEntries(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func marshalTable(t Table) jsonTable {
+	return jsonTable{ElementType: t.ElementType, Limits: marshalLimits(t.Limits)}
+}
+
+func unmarshalTable(t jsonTable) Table {
+	return Table{ElementType: t.ElementType, Limits: unmarshalLimits(t.Limits)}
+}
+
+func marshalLimits(l MemLimits) jsonLimits {
+	limits := jsonLimits{Flags: l.Flags, Intial: l.Intial}
+	if maximum, ok := l.Maximum.(uint32); ok {
+		limits.Maximum = &maximum
+	}
+	return limits
+}
+
+func unmarshalLimits(l jsonLimits) MemLimits {
+	limits := MemLimits{Flags: l.Flags, Intial: l.Intial}
+	if l.Maximum != nil {
+		limits.Maximum = *l.Maximum
+	}
+	return limits
+}
+
+// immediatesKey returns the key of the operator in OP_IMMEDIATES.
+func immediatesKey(op OP) string {
+	if op.Name() == "const" {
+		return op.ReturnType()
+	}
+	return op.Name()
+}
+
+// MarshalJSON serializes an instruction by name, as in the JSON text form.
+func (op OP) MarshalJSON() ([]byte, error) {
+	jop, err := marshalOP(op)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jop)
+}
+
+// UnmarshalJSON parses an instruction of the JSON text form.
+func (op *OP) UnmarshalJSON(data []byte) error {
+	var jop jsonOP
+	if err := json.Unmarshal(data, &jop); err != nil {
+		return err
+	}
+	parsed, err := unmarshalOP(jop)
+	if err != nil {
+		return err
+	}
+	*op = parsed
+	return nil
+}
+
+// marshalOP serializes integer immediates as decimal strings, like
+// Text2Json and wasm-json-toolkit do, so 64 bit values are not rounded.
+func marshalOP(op OP) (jsonOP, error) {
+	jop := jsonOP{
+		Name:       op.Name(),
+		ReturnType: op.ReturnType(),
+	}
+	if op.Immediates == nil {
+		return jop, nil
+	}
+
+	op, ok := OPFromJSON(op)
+	if !ok {
+		return jsonOP{}, fmt.Errorf("invalid immediates of %s", op.FullName())
+	}
+
+	var imm interface{}
+	switch v := op.Immediates.(type) {
+	case uint32:
+		imm = strconv.FormatUint(uint64(v), 10)
+	case int32:
+		imm = strconv.FormatInt(int64(v), 10)
+	case int64:
+		imm = strconv.FormatInt(v, 10)
+	case []byte:
+		imm = jsonBytes(v)
+	default:
+		imm = v
+	}
+
+	var err error
+	jop.Immediates, err = json.Marshal(imm)
+	return jop, err
+}
+
+func unmarshalOP(jop jsonOP) (OP, error) {
+	// the toolkit spells out some operators in full, e.g. `local.get`.
+	name := jop.Name
+	if jop.ReturnType != "" {
+		name = jop.ReturnType + "." + jop.Name
+	}
+	opcode, ok := Opcode(name)
+	if !ok {
+		return OP{}, fmt.Errorf("unknown operator %s", name)
+	}
+	op := OP{Opcode: opcode}
+	if len(jop.Immediates) == 0 {
+		return op, nil
+	}
+
+	var err error
+	switch OP_IMMEDIATES[immediatesKey(op)] {
+	case "block_type":
+		var imm string
+		err = json.Unmarshal(jop.Immediates, &imm)
+		op.Immediates = imm
+	case "varuint1":
+		var imm int8
+		err = json.Unmarshal(jop.Immediates, &imm)
+		op.Immediates = imm
+	case "varuint32":
+		var imm uint64
+		imm, err = parseJSONInt(jop.Immediates, func(s string) (uint64, error) {
+			return strconv.ParseUint(s, 10, 32)
+		})
+		op.Immediates = uint32(imm)
+	case "varint32":
+		var imm uint64
+		imm, err = parseJSONInt(jop.Immediates, func(s string) (uint64, error) {
+			v, err := strconv.ParseInt(s, 10, 32)
+			return uint64(v), err
+		})
+		op.Immediates = int32(imm)
+	case "varint64":
+		var imm uint64
+		imm, err = parseJSONInt(jop.Immediates, func(s string) (uint64, error) {
+			v, err := strconv.ParseInt(s, 10, 64)
+			return uint64(v), err
+		})
+		op.Immediates = int64(imm)
+	case "uint32", "uint64":
+		var imm jsonBytes
+		err = json.Unmarshal(jop.Immediates, &imm)
+		op.Immediates = []byte(imm)
+	case "br_table":
+		imm := BrTable{}
+		err = json.Unmarshal(jop.Immediates, &imm)
+		if imm.Targets == nil {
+			imm.Targets = []uint32{}
+		}
+		op.Immediates = imm
+	case "call_indirect":
+		imm := CallIndirect{}
+		err = json.Unmarshal(jop.Immediates, &imm)
+		op.Immediates = imm
+	case "memory_immediate":
+		imm := MemoryImmediate{}
+		err = json.Unmarshal(jop.Immediates, &imm)
+		op.Immediates = imm
+	default:
+		return OP{}, fmt.Errorf("unexpected immediates of %s", name)
+	}
+	if err != nil {
+		return OP{}, fmt.Errorf("invalid immediates of %s: %w", name, err)
+	}
+	return op, nil
+}
+
+// parseJSONInt parses an integer given as a JSON string or number.
+func parseJSONInt(data json.RawMessage, parse func(string) (uint64, error)) (uint64, error) {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		str = string(data)
+	}
+	return parse(str)
+}
+
+func marshalCustom(custom interface{}) (json.RawMessage, error) {
+	var v interface{}
+	switch c := custom.(type) {
+	case []CustomName:
+		names := make([]jsonCustomName, len(c))
+		for i, name := range c {
+			data, err := json.Marshal(name.Names)
+			if err != nil {
+				return nil, err
+			}
+			names[i] = jsonCustomName{Kind: name.Kind, Names: data}
+		}
+		v = names
+	case TargetFeatures:
+		features := make([]jsonTargetFeature, len(c))
+		for i, feature := range c {
+			features[i] = jsonTargetFeature{Prefix: string(feature.Prefix), Name: feature.Name}
+		}
+		v = features
+	case Dylink:
+		dylink := make([]jsonDylinkSubsection, len(c))
+		for i, sub := range c {
+			dylink[i] = jsonDylinkSubsection{
+				Id:         sub.Id,
+				MemInfo:    sub.MemInfo,
+				Needed:     sub.Needed,
+				ExportInfo: sub.ExportInfo,
+				ImportInfo: sub.ImportInfo,
+				Payload:    sub.Payload,
+			}
+		}
+		v = dylink
+	case Producers, SourceMappingURL, ExternalDebugInfo:
+		v = c
+	default:
+		return nil, fmt.Errorf("invalid custom section payload %T", custom)
+	}
+	return json.Marshal(v)
+}
+
+func unmarshalCustom(sectionName string, data json.RawMessage) (interface{}, error) {
+	var err error
+	switch sectionName {
+	case "name":
+		var names []jsonCustomName
+		if err := json.Unmarshal(data, &names); err != nil {
+			return nil, err
+		}
+		customNames := make([]CustomName, len(names))
+		for i, name := range names {
+			customNames[i].Kind = name.Kind
+			switch name.Kind {
+			case "module":
+				var names string
+				err = json.Unmarshal(name.Names, &names)
+				customNames[i].Names = names
+			case "function":
+				names := []NameAssoc{}
+				err = json.Unmarshal(name.Names, &names)
+				customNames[i].Names = names
+			case "local":
+				var names []I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌
+				err = json.Unmarshal(name.Names, &names)
+				customNames[i].Names = names
+			default:
+				return nil, fmt.Errorf("invalid name kind %s", name.Kind)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return customNames, nil
+	case "producers":
+		producers := Producers{}
+		err = json.Unmarshal(data, &producers)
+		return producers, err
+	case "target_features":
+		var features []jsonTargetFeature
+		if err := json.Unmarshal(data, &features); err != nil {
+			return nil, err
+		}
+		targetFeatures := make(TargetFeatures, len(features))
+		for i, feature := range features {
+			if len(feature.Prefix) != 1 {
+				return nil, fmt.Errorf("invalid target feature prefix %q", feature.Prefix)
+			}
+			targetFeatures[i] = TargetFeature{Prefix: feature.Prefix[0], Name: feature.Name}
+		}
+		return targetFeatures, nil
+	case "sourceMappingURL":
+		var url SourceMappingURL
+		err = json.Unmarshal(data, &url)
+		return url, err
+	case "external_debug_info":
+		var url ExternalDebugInfo
+		err = json.Unmarshal(data, &url)
+		return url, err
+	case "dylink.0":
+		var dylink []jsonDylinkSubsection
+		if err := json.Unmarshal(data, &dylink); err != nil {
+			return nil, err
+		}
+		res := make(Dylink, len(dylink))
+		for i, sub := range dylink {
+			res[i] = DylinkSubsection{
+				Id:         sub.Id,
+				MemInfo:    sub.MemInfo,
+				Needed:     sub.Needed,
+				ExportInfo: sub.ExportInfo,
+				ImportInfo: sub.ImportInfo,
+				Payload:    sub.Payload,
+			}
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("custom section %s must carry a raw payload", sectionName)
+	}
+}
@@ -0,0 +1,282 @@
+package tool
+
+import "fmt"
+
+// Section is implemented by the typed sections of a Module.
+type Section interface {
+	SecName() string
+}
+
+func (*CustomSec) SecName() string    { return "custom" }
+func (*TypeSec) SecName() string      { return "type" }
+func (*ImportSec) SecName() string    { return "import" }
+func (*FuncSec) SecName() string      { return "function" }
+func (*TableSec) SecName() string     { return "table" }
+func (*MemSec) SecName() string       { return "memory" }
+func (*GlobalSec) SecName() string    { return "global" }
+func (*ExportSec) SecName() string    { return "export" }
+func (*StartSec) SecName() string     { return "start" }
+func (*ElementSec) SecName() string   { return "element" }
+func (*CodeSec) SecName() string      { return "code" }
+func (*DataSec) SecName() string      { return "data" }
+func (*DataCountSec) SecName() string { return "data count" }
+
+// Module is the typed representation of a wasm module. Sections are kept in
+// the order they appear in the binary so custom sections keep their placement.
+type Module struct {
+	Magic    []byte
+	Version  []byte
+	Sections []Section
+}
+
+var (
+	defaultMagic   = []byte{0x00, 0x61, 0x73, 0x6d}
+	defaultVersion = []byte{0x01, 0x00, 0x00, 0x00}
+)
+
+// NewModule returns an empty module with the default preramble.
+func NewModule() *Module {
+	return &Module{
+		Magic:   append([]byte{}, defaultMagic...),
+		Version: append([]byte{}, defaultVersion...),
+	}
+}
+
+// Section returns the first section called `name`, or nil.
+func (m *Module) Section(name string) Section {
+	for _, section := range m.Sections {
+		if section.SecName() == name {
+			return section
+		}
+	}
+	return nil
+}
+
+func (m *Module) TypeSec() *TypeSec {
+	sec, _ := m.Section("type").(*TypeSec)
+	return sec
+}
+
+func (m *Module) ImportSec() *ImportSec {
+	sec, _ := m.Section("import").(*ImportSec)
+	return sec
+}
+
+func (m *Module) FuncSec() *FuncSec {
+	sec, _ := m.Section("function").(*FuncSec)
+	return sec
+}
+
+func (m *Module) TableSec() *TableSec {
+	sec, _ := m.Section("table").(*TableSec)
+	return sec
+}
+
+func (m *Module) MemSec() *MemSec {
+	sec, _ := m.Section("memory").(*MemSec)
+	return sec
+}
+
+func (m *Module) GlobalSec() *GlobalSec {
+	sec, _ := m.Section("global").(*GlobalSec)
+	return sec
+}
+
+func (m *Module) ExportSec() *ExportSec {
+	sec, _ := m.Section("export").(*ExportSec)
+	return sec
+}
+
+func (m *Module) StartSec() *StartSec {
+	sec, _ := m.Section("start").(*StartSec)
+	return sec
+}
+
+func (m *Module) ElementSec() *ElementSec {
+	sec, _ := m.Section("element").(*ElementSec)
+	return sec
+}
+
+func (m *Module) CodeSec() *CodeSec {
+	sec, _ := m.Section("code").(*CodeSec)
+	return sec
+}
+
+func (m *Module) DataSec() *DataSec {
+	sec, _ := m.Section("data").(*DataSec)
+	return sec
+}
+
+func (m *Module) DataCountSec() *DataCountSec {
+	sec, _ := m.Section("data count").(*DataCountSec)
+	return sec
+}
+
+// DecodeCode decodes the function bodies of a code section decoded lazily.
+func (m *Module) DecodeCode() error {
+	if sec := m.CodeSec(); sec != nil {
+		return sec.Decode()
+	}
+	return nil
+}
+
+// CustomSecs returns the custom sections called `name`.
+func (m *Module) CustomSecs(name string) []*CustomSec {
+	var secs []*CustomSec
+	for _, section := range m.Sections {
+		if sec, ok := section.(*CustomSec); ok && sec.SectionName == name {
+			secs = append(secs, sec)
+		}
+	}
+	return secs
+}
+
+// AddSection inserts a known section in front of the first known section that
+// must follow it. Custom sections are appended to the end of the module.
+func (m *Module) AddSection(sec Section) {
+	order, exist := SECTION_ORDER[sec.SecName()]
+	pos := len(m.Sections)
+	if exist {
+		for i, section := range m.Sections {
+			if o, exist := SECTION_ORDER[section.SecName()]; exist && o > order {
+				pos = i
+				break
+			}
+		}
+	}
+	m.insertSection(pos, sec)
+}
+
+func (m *Module) insertSection(pos int, sec Section) {
+	m.Sections = append(m.Sections, nil)
+	copy(m.Sections[pos+1:], m.Sections[pos:])
+	m.Sections[pos] = sec
+}
+
+// Placement locates a custom section relative to a known section.
+type Placement struct {
+	Anchor string // name of the known section, e.g. `code`. Empty means the end (or the start with Before) of the module.
+	Before bool   // place the custom section before the anchor instead of after it.
+}
+
+// InsertCustom inserts a custom section at the given placement. A section
+// inserted after an anchor also follows the custom sections already trailing
+// that anchor, so repeated insertions keep their order. If the anchor section
+// does not exist, the custom section goes where the anchor would be.
+func (m *Module) InsertCustom(sec *CustomSec, placement Placement) error {
+	pos, err := m.customPos(placement)
+	if err != nil {
+		return err
+	}
+	m.insertSection(pos, sec)
+	return nil
+}
+
+// RemoveCustoms removes every custom section called `name` and returns the
+// number of removed sections.
+func (m *Module) RemoveCustoms(name string) int {
+	sections := make([]Section, 0, len(m.Sections))
+	for _, section := range m.Sections {
+		if sec, ok := section.(*CustomSec); ok && sec.SectionName == name {
+			continue
+		}
+		sections = append(sections, section)
+	}
+	removed := len(m.Sections) - len(sections)
+	m.Sections = sections
+	return removed
+}
+
+// ReplaceCustom replaces the first custom section with the same name in place,
+// or inserts it at the given placement if there is none.
+func (m *Module) ReplaceCustom(sec *CustomSec, placement Placement) error {
+	for i, section := range m.Sections {
+		if old, ok := section.(*CustomSec); ok && old.SectionName == sec.SectionName {
+			m.Sections[i] = sec
+			return nil
+		}
+	}
+	return m.InsertCustom(sec, placement)
+}
+
+// customPos returns the index in Sections a custom section is inserted at.
+func (m *Module) customPos(placement Placement) (int, error) {
+	if placement.Anchor == "" {
+		if placement.Before {
+			return 0, nil
+		}
+		return len(m.Sections), nil
+	}
+
+	anchorOrder, exist := SECTION_ORDER[placement.Anchor]
+	if !exist {
+		return 0, fmt.Errorf("invalid anchor section: %s", placement.Anchor)
+	}
+
+	for i, section := range m.Sections {
+		order, exist := SECTION_ORDER[section.SecName()]
+		if !exist {
+			continue
+		}
+		if placement.Before && order >= anchorOrder || !placement.Before && order > anchorOrder {
+			return i, nil
+		}
+	}
+	return len(m.Sections), nil
+}
+
+// ImportedFuncs returns the number of imported functions, which come first in
+// the function index space.
+func (m *Module) ImportedFuncs() int {
+	num := 0
+	if sec := m.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if entry.Kind == "function" {
+				num++
+			}
+		}
+	}
+	return num
+}
+
+// NumFuncs returns the size of the function index space.
+func (m *Module) NumFuncs() int {
+	num := m.ImportedFuncs()
+	if sec := m.FuncSec(); sec != nil {
+		num += len(sec.Entries)
+	}
+	return num
+}
+
+// FuncTypeIndex returns the type index of a function in the function index space.
+func (m *Module) FuncTypeIndex(index uint32) (uint32, bool) {
+	if sec := m.ImportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			if entry.Kind != "function" {
+				continue
+			}
+			if index == 0 {
+				typ, ok := entry.Type.(uint32)
+				return typ, ok
+			}
+			index--
+		}
+	}
+	if sec := m.FuncSec(); sec != nil && index < uint32(len(sec.Entries)) {
+		return sec.Entries[index], true
+	}
+	return 0, false
+}
+
+// FuncType returns the signature of a function in the function index space.
+func (m *Module) FuncType(index uint32) (TypeEntry, bool) {
+	typeIndex, ok := m.FuncTypeIndex(index)
+	if !ok {
+		return TypeEntry{}, false
+	}
+	sec := m.TypeSec()
+	if sec == nil || typeIndex >= uint32(len(sec.Entries)) {
+		return TypeEntry{}, false
+	}
+	return sec.Entries[typeIndex], true
+}
@@ -16,9 +16,13 @@ func NewStream(buf []byte) *Stream {
 	}
 }
 
-// ReadBytes reads and returns the next byte from the buffer.
+// ReadByte reads and returns the next byte from the buffer, or io.EOF if
+// there is none.
 func (s *Stream) ReadByte() (b byte, err error) {
 	b, err = s.buffer.ReadByte()
+	if err != nil {
+		return 0, err
+	}
 	s.BytesRead += 1
 	return b, nil
 }
@@ -1,5 +1,7 @@
 package tool
 
+import "sync"
+
 type JSON = map[string]interface{}
 
 type SectionHeader struct {
@@ -8,11 +10,47 @@ type SectionHeader struct {
 	Size uint32 `json:"size,omitempty"`
 }
 
+// OP is an instruction: its opcode, as in OPCODES, and its immediate, typed
+// as OP_IMMEDIATES lists it, e.g. uint32 for `call` or BrTable for
+// `br_table`.
 type OP struct {
-	Name       string      `json:"name,omitempty"`
-	ReturnType string      `json:"return_type,omitempty"`
-	Type       string      `json:"type,omitempty"`
-	Immediates interface{} `json:"immediates,omitempty"`
+	Opcode     byte
+	Immediates interface{}
+}
+
+// Name returns the name of the operator without its return type, e.g. `add`
+// for `i32.add`.
+func (op OP) Name() string {
+	return opShortNames[op.Opcode]
+}
+
+// ReturnType returns the type before the name of the operator, e.g. `i32`
+// for `i32.add` or `local` for `local.get`.
+func (op OP) ReturnType() string {
+	return opReturnTypes[op.Opcode]
+}
+
+// FullName returns the name of the operator as in OPCODES, e.g. `i32.add`.
+func (op OP) FullName() string {
+	return opNames[op.Opcode]
+}
+
+// BrTable is the immediate of `br_table`.
+type BrTable struct {
+	Targets       []uint32 `json:"targets"`
+	DefaultTarget uint32   `json:"default_target"`
+}
+
+// CallIndirect is the immediate of `call_indirect`.
+type CallIndirect struct {
+	Index    uint32 `json:"index"`
+	Reserved byte   `json:"reserved"`
+}
+
+// MemoryImmediate is the immediate of load and store operators.
+type MemoryImmediate struct {
+	Flags  uint32 `json:"flags"`
+	Offset uint32 `json:"offset"`
 }
 
 type Table struct {
@@ -50,7 +88,83 @@ type CustomName struct {
 type CustomSec struct {
 	Name        string      `json:"name,omitempty"`
 	SectionName string      `json:"section_name,omitempty"`
-	Custom      interface{} `json:"custom,omitempty"`
+	Custom      interface{} `json:"custom,omitempty"` // decoded payload of well-known sections, raw []byte otherwise.
+}
+
+// Producers is the payload of the `producers` custom section, recording the
+// toolchain that produced the module.
+// https://github.com/WebAssembly/tool-conventions/blob/main/ProducersSection.md
+type Producers struct {
+	Fields []ProducerField `json:"fields"`
+}
+
+type ProducerField struct {
+	Name   string            `json:"name,omitempty"`
+	Values []ProducerVersion `json:"values"`
+}
+
+type ProducerVersion struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// TargetFeatures is the payload of the `target_features` custom section.
+// https://github.com/WebAssembly/tool-conventions/blob/main/Linking.md#target-features-section
+type TargetFeatures []TargetFeature
+
+type TargetFeature struct {
+	Prefix byte   `json:"prefix,omitempty"` // one of '+' (used), '-' (disallowed) or '=' (required).
+	Name   string `json:"name,omitempty"`
+}
+
+// Used reports whether the feature is declared as used or required by the module.
+func (t TargetFeatures) Used(name string) bool {
+	for _, feature := range t {
+		if feature.Name == name && (feature.Prefix == '+' || feature.Prefix == '=') {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceMappingURL is the payload of the `sourceMappingURL` custom section.
+type SourceMappingURL string
+
+// ExternalDebugInfo is the payload of the `external_debug_info` custom section.
+type ExternalDebugInfo string
+
+// Dylink is the payload of the `dylink.0` custom section, its subsections in
+// the order they appear.
+// https://github.com/WebAssembly/tool-conventions/blob/main/DynamicLinking.md
+type Dylink []DylinkSubsection
+
+type DylinkMemInfo struct {
+	MemorySize  uint32 `json:"memory_size,omitempty"`
+	MemoryAlign uint32 `json:"memory_align,omitempty"`
+	TableSize   uint32 `json:"table_size,omitempty"`
+	TableAlign  uint32 `json:"table_align,omitempty"`
+}
+
+type DylinkExportInfo struct {
+	Name  string `json:"name,omitempty"`
+	Flags uint32 `json:"flags,omitempty"`
+}
+
+type DylinkImportInfo struct {
+	ModuleStr string `json:"module_str,omitempty"`
+	FieldStr  string `json:"field_str,omitempty"`
+	Flags     uint32 `json:"flags,omitempty"`
+}
+
+// DylinkSubsection is a subsection of `dylink.0`. Known subsections are
+// decoded into the field of their id, others kept as raw bytes in Payload.
+type DylinkSubsection struct {
+	Id         byte               `json:"id,omitempty"`
+	MemInfo    *DylinkMemInfo     `json:"mem_info,omitempty"`    // 0x01.
+	Needed     []string           `json:"needed,omitempty"`      // 0x02.
+	ExportInfo []DylinkExportInfo `json:"export_info,omitempty"` // 0x03.
+	ImportInfo []DylinkImportInfo `json:"import_info,omitempty"` // 0x04.
+	Payload    []byte             `json:"payload,omitempty"`
 }
 
 type TypeEntry struct {
@@ -59,6 +173,24 @@ type TypeEntry struct {
 	Returns []string `json:"returns,omitempty"`
 }
 
+// Equal reports whether two function types have the same params and returns.
+func (t TypeEntry) Equal(other TypeEntry) bool {
+	if len(t.Params) != len(other.Params) || len(t.Returns) != len(other.Returns) {
+		return false
+	}
+	for i := range t.Params {
+		if t.Params[i] != other.Params[i] {
+			return false
+		}
+	}
+	for i := range t.Returns {
+		if t.Returns[i] != other.Returns[i] {
+			return false
+		}
+	}
+	return true
+}
+
 type TypeSec struct {
 	Name    string      `json:"name,omitempty"`
 	Entries []TypeEntry `json:"entries"`
@@ -136,11 +268,19 @@ type LocalEntry struct {
 type CodeBody struct {
 	Locals []LocalEntry `json:"locals"`
 	Code   []OP         `json:"code"`
+
+	raw *rawBody
 }
 
+// CodeSec is the code section. A code section decoded lazily holds empty
+// Entries until they are decoded by Body or Decode. Assigning an entry, even
+// an empty one, or calling SetBody replaces the body, which is then no longer
+// decoded nor copied; entries keep their bytes when appended or moved.
 type CodeSec struct {
 	Name    string     `json:"name,omitempty"`
 	Entries []CodeBody `json:"entries"`
+
+	mu *sync.Mutex // guards Entries decoded lazily.
 }
 
 type DataSegment struct {
@@ -184,8 +324,213 @@ var OP_IMMEDIATES = map[string]string{
 	"store32":        "memory_immediate",
 	"current_memory": "varuint1",
 	"grow_memory":    "varuint1",
+	"size":           "varuint1",
+	"grow":           "varuint1",
 	"i32":            "varint32",
 	"i64":            "varint64",
 	"f32":            "uint32",
 	"f64":            "uint64",
 }
+
+// SECTION_ORDER is the order known sections must appear in a module. Custom
+// sections may appear anywhere and are not listed.
+var SECTION_ORDER = map[string]int{
+	"type":       1,
+	"import":     2,
+	"function":   3,
+	"table":      4,
+	"memory":     5,
+	"global":     6,
+	"export":     7,
+	"start":      8,
+	"element":    9,
+	"data count": 10,
+	"code":       11,
+	"data":       12,
+}
+
+// OPSignature is the operand and result types of an operator with a fixed signature.
+type OPSignature struct {
+	Params  []string
+	Results []string
+}
+
+// OP_SIGNATURES maps the full name of operators with a fixed signature to it.
+var OP_SIGNATURES = map[string]OPSignature{
+	// Memory-related operators
+	"i32.load":     {[]string{"i32"}, []string{"i32"}},
+	"i32.load8_s":  {[]string{"i32"}, []string{"i32"}},
+	"i32.load8_u":  {[]string{"i32"}, []string{"i32"}},
+	"i32.load16_s": {[]string{"i32"}, []string{"i32"}},
+	"i32.load16_u": {[]string{"i32"}, []string{"i32"}},
+	"i64.load":     {[]string{"i32"}, []string{"i64"}},
+	"i64.load8_s":  {[]string{"i32"}, []string{"i64"}},
+	"i64.load8_u":  {[]string{"i32"}, []string{"i64"}},
+	"i64.load16_s": {[]string{"i32"}, []string{"i64"}},
+	"i64.load16_u": {[]string{"i32"}, []string{"i64"}},
+	"i64.load32_s": {[]string{"i32"}, []string{"i64"}},
+	"i64.load32_u": {[]string{"i32"}, []string{"i64"}},
+	"f32.load":     {[]string{"i32"}, []string{"f32"}},
+	"f64.load":     {[]string{"i32"}, []string{"f64"}},
+	"i32.store":    {[]string{"i32", "i32"}, nil},
+	"i32.store8":   {[]string{"i32", "i32"}, nil},
+	"i32.store16":  {[]string{"i32", "i32"}, nil},
+	"i64.store":    {[]string{"i32", "i64"}, nil},
+	"i64.store8":   {[]string{"i32", "i64"}, nil},
+	"i64.store16":  {[]string{"i32", "i64"}, nil},
+	"i64.store32":  {[]string{"i32", "i64"}, nil},
+	"f32.store":    {[]string{"i32", "f32"}, nil},
+	"f64.store":    {[]string{"i32", "f64"}, nil},
+	"memory.size":  {nil, []string{"i32"}},
+	"memory.grow":  {[]string{"i32"}, []string{"i32"}},
+
+	// Constants
+	"i32.const": {nil, []string{"i32"}},
+	"i64.const": {nil, []string{"i64"}},
+	"f32.const": {nil, []string{"f32"}},
+	"f64.const": {nil, []string{"f64"}},
+
+	// Comparison operators
+	"i32.eqz":  {[]string{"i32"}, []string{"i32"}},
+	"i32.eq":   {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.ne":   {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.lt_s": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.lt_u": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.gt_s": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.gt_u": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.le_s": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.le_u": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.ge_s": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.ge_u": {[]string{"i32", "i32"}, []string{"i32"}},
+	"i64.eqz":  {[]string{"i64"}, []string{"i32"}},
+	"i64.eq":   {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.ne":   {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.lt_s": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.lt_u": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.gt_s": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.gt_u": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.le_s": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.le_u": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.ge_s": {[]string{"i64", "i64"}, []string{"i32"}},
+	"i64.ge_u": {[]string{"i64", "i64"}, []string{"i32"}},
+	"f32.eq":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f32.ne":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f32.lt":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f32.gt":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f32.le":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f32.ge":   {[]string{"f32", "f32"}, []string{"i32"}},
+	"f64.eq":   {[]string{"f64", "f64"}, []string{"i32"}},
+	"f64.ne":   {[]string{"f64", "f64"}, []string{"i32"}},
+	"f64.lt":   {[]string{"f64", "f64"}, []string{"i32"}},
+	"f64.gt":   {[]string{"f64", "f64"}, []string{"i32"}},
+	"f64.le":   {[]string{"f64", "f64"}, []string{"i32"}},
+	"f64.ge":   {[]string{"f64", "f64"}, []string{"i32"}},
+
+	// Numeric operators
+	"i32.clz":      {[]string{"i32"}, []string{"i32"}},
+	"i32.ctz":      {[]string{"i32"}, []string{"i32"}},
+	"i32.popcnt":   {[]string{"i32"}, []string{"i32"}},
+	"i32.add":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.sub":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.mul":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.div_s":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.div_u":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.rem_s":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.rem_u":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.and":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.or":       {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.xor":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.shl":      {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.shr_s":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.shr_u":    {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.rotl":     {[]string{"i32", "i32"}, []string{"i32"}},
+	"i32.rotr":     {[]string{"i32", "i32"}, []string{"i32"}},
+	"i64.clz":      {[]string{"i64"}, []string{"i64"}},
+	"i64.ctz":      {[]string{"i64"}, []string{"i64"}},
+	"i64.popcnt":   {[]string{"i64"}, []string{"i64"}},
+	"i64.add":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.sub":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.mul":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.div_s":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.div_u":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.rem_s":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.rem_u":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.and":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.or":       {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.xor":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.shl":      {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.shr_s":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.shr_u":    {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.rotl":     {[]string{"i64", "i64"}, []string{"i64"}},
+	"i64.rotr":     {[]string{"i64", "i64"}, []string{"i64"}},
+	"f32.abs":      {[]string{"f32"}, []string{"f32"}},
+	"f32.neg":      {[]string{"f32"}, []string{"f32"}},
+	"f32.ceil":     {[]string{"f32"}, []string{"f32"}},
+	"f32.floor":    {[]string{"f32"}, []string{"f32"}},
+	"f32.trunc":    {[]string{"f32"}, []string{"f32"}},
+	"f32.nearest":  {[]string{"f32"}, []string{"f32"}},
+	"f32.sqrt":     {[]string{"f32"}, []string{"f32"}},
+	"f32.add":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.sub":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.mul":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.div":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.min":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.max":      {[]string{"f32", "f32"}, []string{"f32"}},
+	"f32.copysign": {[]string{"f32", "f32"}, []string{"f32"}},
+	"f64.abs":      {[]string{"f64"}, []string{"f64"}},
+	"f64.neg":      {[]string{"f64"}, []string{"f64"}},
+	"f64.ceil":     {[]string{"f64"}, []string{"f64"}},
+	"f64.floor":    {[]string{"f64"}, []string{"f64"}},
+	"f64.trunc":    {[]string{"f64"}, []string{"f64"}},
+	"f64.nearest":  {[]string{"f64"}, []string{"f64"}},
+	"f64.sqrt":     {[]string{"f64"}, []string{"f64"}},
+	"f64.add":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.sub":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.mul":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.div":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.min":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.max":      {[]string{"f64", "f64"}, []string{"f64"}},
+	"f64.copysign": {[]string{"f64", "f64"}, []string{"f64"}},
+
+	// Conversions
+	"i32.wrap_i64":        {[]string{"i64"}, []string{"i32"}},
+	"i32.trunc_f32_s":     {[]string{"f32"}, []string{"i32"}},
+	"i32.trunc_f32_u":     {[]string{"f32"}, []string{"i32"}},
+	"i32.trunc_f64_s":     {[]string{"f64"}, []string{"i32"}},
+	"i32.trunc_f64_u":     {[]string{"f64"}, []string{"i32"}},
+	"i64.extend_i32_s":    {[]string{"i32"}, []string{"i64"}},
+	"i64.extend_i32_u":    {[]string{"i32"}, []string{"i64"}},
+	"i64.trunc_f32_s":     {[]string{"f32"}, []string{"i64"}},
+	"i64.trunc_f32_u":     {[]string{"f32"}, []string{"i64"}},
+	"i64.trunc_f64_s":     {[]string{"f64"}, []string{"i64"}},
+	"i64.trunc_f64_u":     {[]string{"f64"}, []string{"i64"}},
+	"f32.convert_i32_s":   {[]string{"i32"}, []string{"f32"}},
+	"f32.convert_i32_u":   {[]string{"i32"}, []string{"f32"}},
+	"f32.convert_i64_s":   {[]string{"i64"}, []string{"f32"}},
+	"f32.convert_i64_u":   {[]string{"i64"}, []string{"f32"}},
+	"f32.demote_f64":      {[]string{"f64"}, []string{"f32"}},
+	"f64.convert_i32_s":   {[]string{"i32"}, []string{"f64"}},
+	"f64.convert_i32_u":   {[]string{"i32"}, []string{"f64"}},
+	"f64.convert_i64_s":   {[]string{"i64"}, []string{"f64"}},
+	"f64.convert_i64_u":   {[]string{"i64"}, []string{"f64"}},
+	"f64.promote_f32":     {[]string{"f32"}, []string{"f64"}},
+	"i32.reinterpret_f32": {[]string{"f32"}, []string{"i32"}},
+	"i64.reinterpret_f64": {[]string{"f64"}, []string{"i64"}},
+	"f32.reinterpret_i32": {[]string{"i32"}, []string{"f32"}},
+	"f64.reinterpret_i64": {[]string{"i64"}, []string{"f64"}},
+	"i32.trunc_sat_f32_s": {[]string{"f32"}, []string{"i32"}},
+	"i32.trunc_sat_f32_u": {[]string{"f32"}, []string{"i32"}},
+	"i32.trunc_sat_f64_s": {[]string{"f64"}, []string{"i32"}},
+	"i32.trunc_sat_f64_u": {[]string{"f64"}, []string{"i32"}},
+	"i64.trunc_sat_f32_s": {[]string{"f32"}, []string{"i64"}},
+	"i64.trunc_sat_f32_u": {[]string{"f32"}, []string{"i64"}},
+	"i64.trunc_sat_f64_s": {[]string{"f64"}, []string{"i64"}},
+	"i64.trunc_sat_f64_u": {[]string{"f64"}, []string{"i64"}},
+
+	// Sign extension
+	"i32.extend8_s":  {[]string{"i32"}, []string{"i32"}},
+	"i32.extend16_s": {[]string{"i32"}, []string{"i32"}},
+	"i64.extend8_s":  {[]string{"i64"}, []string{"i64"}},
+	"i64.extend16_s": {[]string{"i64"}, []string{"i64"}},
+	"i64.extend32_s": {[]string{"i64"}, []string{"i64"}},
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"unicode"
 )
 
@@ -52,7 +54,33 @@ func EncodeSLEB128(v int32, stream *Stream) (out []byte, err error) {
 	return out, nil
 }
 
-// DecodeULEB128 decodes bytes from stream with unsigned LEB128 encoding.
+// EncodeSLEB128Int64 appends v to b using signed LEB128 encoding.
+func EncodeSLEB128Int64(v int64, stream *Stream) (out []byte, err error) {
+	for {
+		c := uint8(v & 0x7f)
+		s := uint8(v & 0x40)
+		v >>= 7
+
+		if (v != -1 || s == 0) && (v != 0 || s != 0) {
+			c |= 0x80
+		}
+
+		out = append(out, c)
+
+		if c&0x80 == 0 {
+			break
+		}
+	}
+
+	_, err = stream.Write(out)
+	if err != nil {
+		return nil, fmt.Errorf("EncodeSLEB128Int64 error: %w", err)
+	}
+	return out, nil
+}
+
+// DecodeULEB128 decodes bytes from stream with unsigned LEB128 encoding, of
+// at most 5 bytes.
 func DecodeULEB128(stream *Stream) (u uint32, err error) {
 	var shift uint
 	for {
@@ -60,6 +88,11 @@ func DecodeULEB128(stream *Stream) (u uint32, err error) {
 		if err != nil {
 			return 0, err
 		}
+		if shift == 28 {
+			if err := checkLastLEB128Byte(b, 0x70, false); err != nil {
+				return 0, err
+			}
+		}
 		u |= uint32(b&0x7f) << shift
 		if b&0x80 == 0 {
 			break
@@ -70,7 +103,8 @@ func DecodeULEB128(stream *Stream) (u uint32, err error) {
 	return
 }
 
-// DecodeSLEB128 decodes bytes from stream with signed LEB128 encoding.
+// DecodeSLEB128 decodes bytes from stream with signed LEB128 encoding, of at
+// most 5 bytes.
 func DecodeSLEB128(stream *Stream) (s int32, err error) {
 	var shift uint
 	for {
@@ -78,6 +112,11 @@ func DecodeSLEB128(stream *Stream) (s int32, err error) {
 		if err != nil {
 			return 0, err
 		}
+		if shift == 28 {
+			if err := checkLastLEB128Byte(b, 0x78, true); err != nil {
+				return 0, err
+			}
+		}
 		s |= int32(b&0x7f) << shift
 		shift += 7
 		if b&0x80 == 0 {
@@ -92,6 +131,47 @@ func DecodeSLEB128(stream *Stream) (s int32, err error) {
 	return
 }
 
+// DecodeSLEB128Int64 decodes bytes from stream with signed LEB128 encoding,
+// of at most 10 bytes.
+func DecodeSLEB128Int64(stream *Stream) (s int64, err error) {
+	var shift uint
+	for {
+		b, err := stream.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if shift == 63 {
+			if err := checkLastLEB128Byte(b, 0x7f, true); err != nil {
+				return 0, err
+			}
+		}
+		s |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			// If it's signed
+			if shift < 64 && b&0x40 != 0 {
+				s |= ^0 << shift
+			}
+			break
+		}
+	}
+
+	return
+}
+
+// checkLastLEB128Byte checks the last byte a LEB128 number can have: it
+// must end the number, and its bits in `unused`, past the size of the number
+// or its sign bit, must be all clear, or all set for a negative signed one.
+func checkLastLEB128Byte(b, unused byte, signed bool) error {
+	if b&0x80 != 0 {
+		return fmt.Errorf("integer representation too long")
+	}
+	if bits := b & unused; bits != 0 && (!signed || bits != unused) {
+		return fmt.Errorf("integer too large")
+	}
+	return nil
+}
+
 func ReadFromFile(path string) (JSON, error) {
 	obj := make(JSON)
 	file, err := os.Open(path)
@@ -166,3 +246,44 @@ func Interface2Bytes(arr interface{}) (out []byte) {
 	}
 	return
 }
+
+// Parallel runs `fn` for every index below `n` with up to `workers`
+// goroutines, or one after the other if `workers` is at most 1. It returns
+// the error of the lowest index failing, like running them in order would.
+func Parallel(n, workers int, fn func(i int) error) error {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make([]error, n)
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
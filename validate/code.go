@@ -0,0 +1,433 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// unknown is the type of operands popped from the polymorphic stack of
+// unreachable code, which matches any type.
+const unknown = ""
+
+// frame is an entry of the control stack.
+type frame struct {
+	op          string // block, loop, if, else or function.
+	results     []string
+	height      int // height of the operand stack when the frame was entered.
+	unreachable bool
+}
+
+// labelTypes returns the operand types a branch to the frame takes.
+func (f *frame) labelTypes() []string {
+	if f.op == "loop" {
+		return nil
+	}
+	return f.results
+}
+
+// funcChecker type checks a function body.
+// https://webassembly.github.io/spec/core/appendix/algorithm.html
+type funcChecker struct {
+	v       *validator
+	fn      int
+	params  []string
+	locals  []tool.LocalEntry
+	results []string
+
+	vals  []string
+	ctrls []frame
+	errs  Errors
+}
+
+// checkFunc returns the errors of the body of function `fn`. After an error
+// the current block is treated as unreachable, so that checking goes on
+// without reporting follow-up errors.
+func checkFunc(v *validator, fn int, typ tool.TypeEntry, body tool.CodeBody) Errors {
+	c := &funcChecker{
+		v:       v,
+		fn:      fn,
+		params:  typ.Params,
+		locals:  body.Locals,
+		results: typ.Returns,
+		ctrls:   []frame{{op: "function", results: typ.Returns}},
+	}
+	for _, local := range body.Locals {
+		if !VALUE_TYPES[local.Type] {
+			c.errorf(-1, "invalid local type %s", local.Type)
+		}
+	}
+
+	for offset, op := range body.Code {
+		if len(c.ctrls) == 0 {
+			c.errorf(offset, "instruction %s after the end of the function", op.FullName())
+			break
+		}
+		if err := c.step(op); err != nil {
+			c.errorf(offset, "%s: %v", op.FullName(), err)
+			if len(c.ctrls) != 0 {
+				c.setUnreachable()
+			}
+		}
+	}
+	if len(c.ctrls) != 0 {
+		c.errorf(len(body.Code), "missing end of the function")
+	}
+	return c.errs
+}
+
+func (c *funcChecker) errorf(offset int, format string, args ...interface{}) {
+	c.errs = append(c.errs, &Error{Section: "code", Func: c.fn, Offset: offset, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *funcChecker) push(types ...string) {
+	c.vals = append(c.vals, types...)
+}
+
+func (c *funcChecker) pop(expect string) (string, error) {
+	top := &c.ctrls[len(c.ctrls)-1]
+	if len(c.vals) == top.height {
+		if top.unreachable {
+			return expect, nil
+		}
+		if expect == unknown {
+			return "", fmt.Errorf("type mismatch: expected a value, the stack is empty")
+		}
+		return "", fmt.Errorf("type mismatch: expected %s, the stack is empty", expect)
+	}
+	actual := c.vals[len(c.vals)-1]
+	c.vals = c.vals[:len(c.vals)-1]
+	switch {
+	case actual == unknown:
+		return expect, nil
+	case expect == unknown:
+		return actual, nil
+	case actual != expect:
+		return "", fmt.Errorf("type mismatch: expected %s, got %s", expect, actual)
+	}
+	return actual, nil
+}
+
+func (c *funcChecker) popAll(types []string) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		if _, err := c.pop(types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *funcChecker) enter(op string, results []string) {
+	c.ctrls = append(c.ctrls, frame{op: op, results: results, height: len(c.vals)})
+}
+
+// exit pops the current frame, checking the operand stack holds exactly its
+// results. The frame is popped even if the check fails.
+func (c *funcChecker) exit() (frame, error) {
+	top := c.ctrls[len(c.ctrls)-1]
+	err := c.popAll(top.results)
+	if err == nil && len(c.vals) != top.height {
+		err = fmt.Errorf("type mismatch: %d values remain on the stack", len(c.vals)-top.height)
+	}
+	c.vals = c.vals[:top.height]
+	c.ctrls = c.ctrls[:len(c.ctrls)-1]
+	return top, err
+}
+
+func (c *funcChecker) setUnreachable() {
+	top := &c.ctrls[len(c.ctrls)-1]
+	c.vals = c.vals[:top.height]
+	top.unreachable = true
+}
+
+func (c *funcChecker) label(depth uint32) (*frame, error) {
+	if depth >= uint32(len(c.ctrls)) {
+		return nil, fmt.Errorf("unknown label %d", depth)
+	}
+	return &c.ctrls[len(c.ctrls)-1-int(depth)], nil
+}
+
+func (c *funcChecker) local(index uint32) (string, error) {
+	if index < uint32(len(c.params)) {
+		return c.params[index], nil
+	}
+	rest := index - uint32(len(c.params))
+	for _, local := range c.locals {
+		if rest < local.Count {
+			return local.Type, nil
+		}
+		rest -= local.Count
+	}
+	return "", fmt.Errorf("unknown local %d", index)
+}
+
+func (c *funcChecker) global(index uint32) (tool.Global, error) {
+	if index >= uint32(len(c.v.globals)) {
+		return tool.Global{}, fmt.Errorf("unknown global %d", index)
+	}
+	return c.v.globals[index], nil
+}
+
+// step checks an instruction.
+func (c *funcChecker) step(op tool.OP) error {
+	name := op.FullName()
+	switch name {
+	case "unreachable":
+		c.setUnreachable()
+	case "nop":
+	case "block", "loop", "if":
+		results, err := blockType(op)
+		if err != nil {
+			return err
+		}
+		if name == "if" {
+			if _, err := c.pop("i32"); err != nil {
+				return err
+			}
+		}
+		c.enter(name, results)
+	case "else":
+		if c.ctrls[len(c.ctrls)-1].op != "if" {
+			return fmt.Errorf("else without if")
+		}
+		top, err := c.exit()
+		c.enter("else", top.results)
+		return err
+	case "end":
+		top, err := c.exit()
+		c.push(top.results...)
+		if err == nil && top.op == "if" && len(top.results) != 0 {
+			err = fmt.Errorf("type mismatch: if without else must not have results")
+		}
+		return err
+	case "br":
+		depth, err := index(op)
+		if err != nil {
+			return err
+		}
+		label, err := c.label(depth)
+		if err != nil {
+			return err
+		}
+		if err := c.popAll(label.labelTypes()); err != nil {
+			return err
+		}
+		c.setUnreachable()
+	case "br_if":
+		depth, err := index(op)
+		if err != nil {
+			return err
+		}
+		label, err := c.label(depth)
+		if err != nil {
+			return err
+		}
+		if _, err := c.pop("i32"); err != nil {
+			return err
+		}
+		if err := c.popAll(label.labelTypes()); err != nil {
+			return err
+		}
+		c.push(label.labelTypes()...)
+	case "br_table":
+		imm, ok := op.Immediates.(tool.BrTable)
+		if !ok {
+			return fmt.Errorf("invalid immediate")
+		}
+		def, err := c.label(imm.DefaultTarget)
+		if err != nil {
+			return err
+		}
+		for _, target := range imm.Targets {
+			label, err := c.label(target)
+			if err != nil {
+				return err
+			}
+			if !equal(label.labelTypes(), def.labelTypes()) {
+				return fmt.Errorf("type mismatch: label %d and default label %d take different types", target, imm.DefaultTarget)
+			}
+		}
+		if _, err := c.pop("i32"); err != nil {
+			return err
+		}
+		if err := c.popAll(def.labelTypes()); err != nil {
+			return err
+		}
+		c.setUnreachable()
+	case "return":
+		if err := c.popAll(c.results); err != nil {
+			return err
+		}
+		c.setUnreachable()
+	case "call":
+		fn, err := index(op)
+		if err != nil {
+			return err
+		}
+		typ, ok := c.v.funcType(fn)
+		if !ok {
+			return fmt.Errorf("unknown function %d", fn)
+		}
+		if err := c.popAll(typ.Params); err != nil {
+			return err
+		}
+		c.push(typ.Returns...)
+	case "call_indirect":
+		imm, ok := op.Immediates.(tool.CallIndirect)
+		if !ok {
+			return fmt.Errorf("invalid immediate")
+		}
+		if len(c.v.tables) == 0 {
+			return fmt.Errorf("unknown table 0")
+		}
+		if imm.Index >= uint32(len(c.v.types)) {
+			return fmt.Errorf("unknown type %d", imm.Index)
+		}
+		typ := c.v.types[imm.Index]
+		if _, err := c.pop("i32"); err != nil {
+			return err
+		}
+		if err := c.popAll(typ.Params); err != nil {
+			return err
+		}
+		c.push(typ.Returns...)
+	case "drop":
+		if _, err := c.pop(unknown); err != nil {
+			return err
+		}
+	case "select":
+		if op.Immediates != nil {
+			return fmt.Errorf("unsupported typed select")
+		}
+		if _, err := c.pop("i32"); err != nil {
+			return err
+		}
+		first, err := c.pop(unknown)
+		if err != nil {
+			return err
+		}
+		second, err := c.pop(first)
+		if err != nil {
+			return err
+		}
+		c.push(second)
+	case "local.get", "local.set", "local.tee":
+		local, err := index(op)
+		if err != nil {
+			return err
+		}
+		typ, err := c.local(local)
+		if err != nil {
+			return err
+		}
+		if op.Name() != "get" {
+			if _, err := c.pop(typ); err != nil {
+				return err
+			}
+		}
+		if op.Name() != "set" {
+			c.push(typ)
+		}
+	case "global.get", "global.set":
+		globalIndex, err := index(op)
+		if err != nil {
+			return err
+		}
+		global, err := c.global(globalIndex)
+		if err != nil {
+			return err
+		}
+		if op.Name() == "get" {
+			c.push(global.ContentType)
+			break
+		}
+		if global.Mutability == 0 {
+			return fmt.Errorf("global %d is immutable", globalIndex)
+		}
+		if _, err := c.pop(global.ContentType); err != nil {
+			return err
+		}
+	default:
+		sig, ok := tool.OP_SIGNATURES[name]
+		if !ok {
+			if name == "" {
+				return fmt.Errorf("unknown opcode")
+			}
+			return fmt.Errorf("unsupported instruction")
+		}
+		if err := c.memory(op); err != nil {
+			return err
+		}
+		if err := c.popAll(sig.Params); err != nil {
+			return err
+		}
+		c.push(sig.Results...)
+	}
+	return nil
+}
+
+// memory checks the memory and the alignment of memory operators.
+func (c *funcChecker) memory(op tool.OP) error {
+	imm, isAccess := op.Immediates.(tool.MemoryImmediate)
+	if !isAccess && op.ReturnType() != "memory" {
+		return nil
+	}
+	if len(c.v.mems) == 0 {
+		return fmt.Errorf("unknown memory 0")
+	}
+	if isAccess && imm.Flags > naturalAlign(op) {
+		return fmt.Errorf("alignment 2**%d must not be larger than natural alignment 2**%d", imm.Flags, naturalAlign(op))
+	}
+	return nil
+}
+
+// naturalAlign returns the log2 of the size of a memory access.
+func naturalAlign(op tool.OP) uint32 {
+	switch {
+	case strings.HasSuffix(op.Name(), "8") || strings.Contains(op.Name(), "8_"):
+		return 0
+	case strings.HasSuffix(op.Name(), "16") || strings.Contains(op.Name(), "16_"):
+		return 1
+	case strings.HasSuffix(op.Name(), "32") || strings.Contains(op.Name(), "32_"):
+		return 2
+	case op.ReturnType() == "i64" || op.ReturnType() == "f64":
+		return 3
+	}
+	return 2
+}
+
+// blockType returns the result types of a block.
+func blockType(op tool.OP) ([]string, error) {
+	typ, ok := op.Immediates.(string)
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("invalid block type %v", op.Immediates)
+	case typ == "block_type":
+		return nil, nil
+	case !VALUE_TYPES[typ]:
+		return nil, fmt.Errorf("invalid block type %s", typ)
+	}
+	return []string{typ}, nil
+}
+
+// index returns the index immediate of an operator.
+func index(op tool.OP) (uint32, error) {
+	index, ok := op.Immediates.(uint32)
+	if !ok {
+		return 0, fmt.Errorf("invalid immediate %v", op.Immediates)
+	}
+	return index, nil
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
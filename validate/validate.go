@@ -0,0 +1,396 @@
+// Package validate implements the validation algorithm of the WebAssembly
+// specification over the module IR.
+// https://webassembly.github.io/spec/core/valid/index.html
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// MAX_PAGES is the maximum number of 64KiB pages of a memory.
+const MAX_PAGES = 65536
+
+// VALUE_TYPES are the types of values on the operand stack.
+var VALUE_TYPES = map[string]bool{
+	"i32": true,
+	"i64": true,
+	"f32": true,
+	"f64": true,
+}
+
+// Error is a validation error. Func and Offset locate errors in function
+// bodies, the index of the function in the function index space and the
+// index of the instruction in its code, which is -1 for errors in the
+// locals. Both are -1 elsewhere.
+type Error struct {
+	Section string
+	Func    int
+	Offset  int
+	Msg     string
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Func < 0:
+		return fmt.Sprintf("%s section: %s", e.Section, e.Msg)
+	case e.Offset < 0:
+		return fmt.Sprintf("function %d: %s", e.Func, e.Msg)
+	}
+	return fmt.Sprintf("function %d, instruction %d: %s", e.Func, e.Offset, e.Msg)
+}
+
+// Errors are all the validation errors of a module.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid module: %s", strings.Join(msgs, "; "))
+}
+
+// validator holds the index spaces of the module being validated.
+type validator struct {
+	module  *tool.Module
+	types   []tool.TypeEntry
+	funcs   []uint32 // type index of every function.
+	tables  []tool.Table
+	mems    []tool.MemLimits
+	globals []tool.Global
+
+	importedFuncs   int
+	importedGlobals int
+	errs            Errors
+}
+
+// Module validates a module. It returns nil if the module is valid and the
+// Errors found otherwise.
+func Module(module *tool.Module) error {
+	v := &validator{module: module}
+	v.typeSec()
+	v.importSec()
+	v.funcSec()
+	v.tableSec()
+	v.memSec()
+	v.globalSec()
+	v.exportSec()
+	v.startSec()
+	v.elementSec()
+	v.dataCountSec()
+	v.codeSec()
+	v.dataSec()
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (v *validator) errorf(section string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &Error{Section: section, Func: -1, Offset: -1, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) typeSec() {
+	sec := v.module.TypeSec()
+	if sec == nil {
+		return
+	}
+	for i, entry := range sec.Entries {
+		if entry.Form != "func" {
+			v.errorf("type", "type %d: invalid form %s", i, entry.Form)
+		}
+		for _, typ := range append(append([]string{}, entry.Params...), entry.Returns...) {
+			if !VALUE_TYPES[typ] {
+				v.errorf("type", "type %d: invalid value type %s", i, typ)
+			}
+		}
+	}
+	v.types = sec.Entries
+}
+
+func (v *validator) importSec() {
+	sec := v.module.ImportSec()
+	if sec == nil {
+		return
+	}
+	for i, entry := range sec.Entries {
+		switch typ := entry.Type.(type) {
+		case uint32:
+			if entry.Kind != "function" {
+				break
+			}
+			if typ >= uint32(len(v.types)) {
+				v.errorf("import", "import %d: unknown type %d", i, typ)
+			}
+			v.funcs = append(v.funcs, typ)
+			v.importedFuncs++
+			continue
+		case tool.Table:
+			if entry.Kind != "table" {
+				break
+			}
+			v.table("import", typ)
+			continue
+		case tool.MemLimits:
+			if entry.Kind != "memory" {
+				break
+			}
+			v.mem("import", typ)
+			continue
+		case tool.Global:
+			if entry.Kind != "global" {
+				break
+			}
+			if !VALUE_TYPES[typ.ContentType] {
+				v.errorf("import", "import %d: invalid global type %s", i, typ.ContentType)
+			}
+			v.globals = append(v.globals, typ)
+			v.importedGlobals++
+			continue
+		}
+		v.errorf("import", "import %d: invalid %s import", i, entry.Kind)
+	}
+}
+
+func (v *validator) funcSec() {
+	sec := v.module.FuncSec()
+	if sec == nil {
+		return
+	}
+	for i, typ := range sec.Entries {
+		if typ >= uint32(len(v.types)) {
+			v.errorf("function", "function %d: unknown type %d", v.importedFuncs+i, typ)
+		}
+		v.funcs = append(v.funcs, typ)
+	}
+}
+
+func (v *validator) tableSec() {
+	if sec := v.module.TableSec(); sec != nil {
+		for _, table := range sec.Entries {
+			v.table("table", table)
+		}
+	}
+}
+
+func (v *validator) memSec() {
+	if sec := v.module.MemSec(); sec != nil {
+		for _, mem := range sec.Entries {
+			v.mem("memory", mem)
+		}
+	}
+}
+
+// table adds a table to the table index space.
+func (v *validator) table(section string, table tool.Table) {
+	if table.ElementType != "funcref" {
+		v.errorf(section, "invalid table element type %s", table.ElementType)
+	}
+	v.limits(section, table.Limits, 1<<32-1)
+	v.tables = append(v.tables, table)
+	if len(v.tables) > 1 {
+		v.errorf(section, "multiple tables")
+	}
+}
+
+// mem adds a memory to the memory index space.
+func (v *validator) mem(section string, mem tool.MemLimits) {
+	v.limits(section, mem, MAX_PAGES)
+	v.mems = append(v.mems, mem)
+	if len(v.mems) > 1 {
+		v.errorf(section, "multiple memories")
+	}
+}
+
+func (v *validator) limits(section string, limits tool.MemLimits, bound uint64) {
+	if uint64(limits.Intial) > bound {
+		v.errorf(section, "initial size %d must be at most %d", limits.Intial, bound)
+	}
+	if limits.Maximum == nil {
+		return
+	}
+	max, ok := limits.Maximum.(uint32)
+	switch {
+	case !ok:
+		v.errorf(section, "invalid maximum size %v", limits.Maximum)
+	case uint64(max) > bound:
+		v.errorf(section, "maximum size %d must be at most %d", max, bound)
+	case max < limits.Intial:
+		v.errorf(section, "initial size %d must not be greater than maximum size %d", limits.Intial, max)
+	}
+}
+
+func (v *validator) globalSec() {
+	sec := v.module.GlobalSec()
+	if sec == nil {
+		return
+	}
+	for i, entry := range sec.Entries {
+		if !VALUE_TYPES[entry.Type.ContentType] {
+			v.errorf("global", "global %d: invalid type %s", v.importedGlobals+i, entry.Type.ContentType)
+		}
+		if err := v.constExpr(entry.Init, entry.Type.ContentType); err != nil {
+			v.errorf("global", "global %d: %v", v.importedGlobals+i, err)
+		}
+		v.globals = append(v.globals, entry.Type)
+	}
+}
+
+// constExpr checks an initializer expression: a constant or the value of an
+// imported immutable global.
+func (v *validator) constExpr(op tool.OP, typ string) error {
+	var actual string
+	switch op.FullName() {
+	case "i32.const", "i64.const", "f32.const", "f64.const":
+		actual = op.ReturnType()
+	case "global.get":
+		index, ok := op.Immediates.(uint32)
+		switch {
+		case !ok:
+			return fmt.Errorf("invalid immediate of %s", op.FullName())
+		case index >= uint32(v.importedGlobals):
+			return fmt.Errorf("unknown imported global %d", index)
+		case v.globals[index].Mutability != 0:
+			return fmt.Errorf("constant expression required, global %d is mutable", index)
+		}
+		actual = v.globals[index].ContentType
+	default:
+		return fmt.Errorf("constant expression required, got %s", op.FullName())
+	}
+	if actual != typ {
+		return fmt.Errorf("type mismatch in constant expression: expected %s, got %s", typ, actual)
+	}
+	return nil
+}
+
+func (v *validator) exportSec() {
+	sec := v.module.ExportSec()
+	if sec == nil {
+		return
+	}
+	names := map[string]bool{}
+	for _, entry := range sec.Entries {
+		if names[entry.FieldStr] {
+			v.errorf("export", "duplicate export name %q", entry.FieldStr)
+		}
+		names[entry.FieldStr] = true
+
+		var size int
+		switch entry.Kind {
+		case "function":
+			size = len(v.funcs)
+		case "table":
+			size = len(v.tables)
+		case "memory":
+			size = len(v.mems)
+		case "global":
+			size = len(v.globals)
+		default:
+			v.errorf("export", "export %q: invalid kind %s", entry.FieldStr, entry.Kind)
+			continue
+		}
+		if entry.Index >= uint32(size) {
+			v.errorf("export", "export %q: unknown %s %d", entry.FieldStr, entry.Kind, entry.Index)
+		}
+	}
+}
+
+func (v *validator) startSec() {
+	sec := v.module.StartSec()
+	if sec == nil {
+		return
+	}
+	typ, ok := v.funcType(sec.Index)
+	switch {
+	case !ok:
+		v.errorf("start", "unknown function %d", sec.Index)
+	case len(typ.Params) != 0 || len(typ.Returns) != 0:
+		v.errorf("start", "start function %d must have type [] -> []", sec.Index)
+	}
+}
+
+func (v *validator) elementSec() {
+	sec := v.module.ElementSec()
+	if sec == nil {
+		return
+	}
+	for i, entry := range sec.Entries {
+		if entry.Index >= uint32(len(v.tables)) {
+			v.errorf("element", "segment %d: unknown table %d", i, entry.Index)
+		}
+		if err := v.constExpr(entry.Offset, "i32"); err != nil {
+			v.errorf("element", "segment %d: %v", i, err)
+		}
+		for _, index := range entry.Elements {
+			if index >= uint32(len(v.funcs)) {
+				v.errorf("element", "segment %d: unknown function %d", i, index)
+			}
+		}
+	}
+}
+
+func (v *validator) dataCountSec() {
+	sec := v.module.DataCountSec()
+	if sec == nil {
+		return
+	}
+	num := 0
+	if data := v.module.DataSec(); data != nil {
+		num = len(data.Entries)
+	}
+	if sec.Count != uint32(num) {
+		v.errorf("data count", "data count %d does not match %d data segments", sec.Count, num)
+	}
+}
+
+func (v *validator) codeSec() {
+	defined := len(v.funcs) - v.importedFuncs
+	var bodies []tool.CodeBody
+	if sec := v.module.CodeSec(); sec != nil {
+		if err := sec.Decode(); err != nil {
+			v.errorf("code", "%s", err)
+			return
+		}
+		bodies = sec.Entries
+	}
+	if len(bodies) != defined {
+		v.errorf("code", "function and code section have inconsistent lengths %d and %d", defined, len(bodies))
+	}
+	for i, body := range bodies {
+		if i >= defined {
+			break
+		}
+		typeIndex := v.funcs[v.importedFuncs+i]
+		if typeIndex >= uint32(len(v.types)) {
+			// reported by the function section.
+			continue
+		}
+		v.errs = append(v.errs, checkFunc(v, v.importedFuncs+i, v.types[typeIndex], body)...)
+	}
+}
+
+func (v *validator) dataSec() {
+	sec := v.module.DataSec()
+	if sec == nil {
+		return
+	}
+	for i, entry := range sec.Entries {
+		if entry.Index >= uint32(len(v.mems)) {
+			v.errorf("data", "segment %d: unknown memory %d", i, entry.Index)
+		}
+		if err := v.constExpr(entry.Offset, "i32"); err != nil {
+			v.errorf("data", "segment %d: %v", i, err)
+		}
+	}
+}
+
+// funcType returns the signature of a function in the function index space.
+func (v *validator) funcType(index uint32) (tool.TypeEntry, bool) {
+	if index >= uint32(len(v.funcs)) || v.funcs[index] >= uint32(len(v.types)) {
+		return tool.TypeEntry{}, false
+	}
+	return v.types[v.funcs[index]], true
+}
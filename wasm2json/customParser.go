@@ -1,6 +1,10 @@
 package wasm2json
 
-import "github.com/meshplus/go-wasm-metering/tool"
+import (
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
 
 type customParser struct{}
 
@@ -22,12 +26,12 @@ func (customParser) FunctionNames(stream *tool.Stream) ([]tool.NameAssoc, error)
 	nameMap := []tool.NameAssoc{}
 
 	for stream.BytesRead < endBytes {
-		num, err := tool.DecodeULEB128(stream)
+		num, err := readCount(stream)
 		if err != nil {
 			return nil, err
 		}
 		for i := 0; i < int(num); i++ {
-			index, err := stream.ReadByte()
+			index, err := tool.DecodeULEB128(stream)
 			if err != nil {
 				return nil, err
 			}
@@ -37,7 +41,7 @@ func (customParser) FunctionNames(stream *tool.Stream) ([]tool.NameAssoc, error)
 			}
 			nameStr := stream.Read(int(nameLen))
 			name := tool.NameAssoc{
-				Index:   uint32(index),
+				Index:   index,
 				NameStr: string(nameStr),
 			}
 			nameMap = append(nameMap, name)
@@ -57,26 +61,26 @@ func (customParser) LocalNames(stream *tool.Stream) ([]tool.I𝚗𝚍𝚒𝚛
 	var i𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌Map []tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌
 
 	for stream.BytesRead < endBytes {
-		num, err := tool.DecodeULEB128(stream)
+		num, err := readCount(stream)
 		if err != nil {
 			return nil, err
 		}
 		for i := 0; i < int(num); i++ {
-			index, err := stream.ReadByte()
+			index, err := tool.DecodeULEB128(stream)
 			if err != nil {
 				return nil, err
 			}
 			inName := tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌{
-				Index:   uint32(index),
+				Index:   index,
 				NameMap: []tool.NameAssoc{},
 			}
 
-			inNameNum, err := tool.DecodeULEB128(stream)
+			inNameNum, err := readCount(stream)
 			if err != nil {
 				return nil, err
 			}
 			for i := 0; i < int(inNameNum); i++ {
-				index, err := stream.ReadByte()
+				index, err := tool.DecodeULEB128(stream)
 				if err != nil {
 					return nil, err
 				}
@@ -86,7 +90,7 @@ func (customParser) LocalNames(stream *tool.Stream) ([]tool.I𝚗𝚍𝚒𝚛
 				}
 				nameStr := stream.Read(int(nameLen))
 				name := tool.NameAssoc{
-					Index:   uint32(index),
+					Index:   index,
 					NameStr: string(nameStr),
 				}
 				inName.NameMap = append(inName.NameMap, name)
@@ -135,3 +139,190 @@ func (customParser) CustomNames(stream *tool.Stream) ([]tool.CustomName, error)
 
 	return cusNames, nil
 }
+
+// readString reads a length-prefixed UTF-8 string.
+func (customParser) readString(stream *tool.Stream) (string, error) {
+	strLen, err := tool.DecodeULEB128(stream)
+	if err != nil {
+		return "", err
+	}
+	if int(strLen) > stream.Len() {
+		return "", fmt.Errorf("string length %d out of bounds", strLen)
+	}
+	return string(stream.Read(int(strLen))), nil
+}
+
+func (c customParser) Producers(stream *tool.Stream) (tool.Producers, error) {
+	producers := tool.Producers{Fields: []tool.ProducerField{}}
+
+	fieldCount, err := readCount(stream)
+	if err != nil {
+		return tool.Producers{}, err
+	}
+	for i := uint32(0); i < fieldCount; i++ {
+		field := tool.ProducerField{Values: []tool.ProducerVersion{}}
+		field.Name, err = c.readString(stream)
+		if err != nil {
+			return tool.Producers{}, err
+		}
+		valueCount, err := readCount(stream)
+		if err != nil {
+			return tool.Producers{}, err
+		}
+		for j := uint32(0); j < valueCount; j++ {
+			value := tool.ProducerVersion{}
+			value.Name, err = c.readString(stream)
+			if err != nil {
+				return tool.Producers{}, err
+			}
+			value.Version, err = c.readString(stream)
+			if err != nil {
+				return tool.Producers{}, err
+			}
+			field.Values = append(field.Values, value)
+		}
+		producers.Fields = append(producers.Fields, field)
+	}
+	if stream.Len() != 0 {
+		return tool.Producers{}, fmt.Errorf("unexpected %d trailing bytes", stream.Len())
+	}
+
+	return producers, nil
+}
+
+func (c customParser) TargetFeatures(stream *tool.Stream) (tool.TargetFeatures, error) {
+	features := tool.TargetFeatures{}
+
+	num, err := readCount(stream)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint32(0); i < num; i++ {
+		if stream.Len() == 0 {
+			return nil, fmt.Errorf("unexpected end of target features")
+		}
+		prefix, err := stream.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if prefix != '+' && prefix != '-' && prefix != '=' {
+			return nil, fmt.Errorf("invalid target feature prefix %#x", prefix)
+		}
+		name, err := c.readString(stream)
+		if err != nil {
+			return nil, err
+		}
+		features = append(features, tool.TargetFeature{
+			Prefix: prefix,
+			Name:   name,
+		})
+	}
+	if stream.Len() != 0 {
+		return nil, fmt.Errorf("unexpected %d trailing bytes", stream.Len())
+	}
+
+	return features, nil
+}
+
+// URL parses the payload shared by `sourceMappingURL` and `external_debug_info`.
+func (c customParser) URL(stream *tool.Stream) (string, error) {
+	url, err := c.readString(stream)
+	if err != nil {
+		return "", err
+	}
+	if stream.Len() != 0 {
+		return "", fmt.Errorf("unexpected %d trailing bytes", stream.Len())
+	}
+	return url, nil
+}
+
+func (c customParser) Dylink(stream *tool.Stream) (tool.Dylink, error) {
+	dylink := tool.Dylink{}
+
+	for stream.Len() != 0 {
+		id, err := stream.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := tool.DecodeULEB128(stream)
+		if err != nil {
+			return nil, err
+		}
+		if int(size) > stream.Len() {
+			return nil, fmt.Errorf("dylink subsection size %d out of bounds", size)
+		}
+		sub := tool.NewStream(stream.Read(int(size)))
+		entry := tool.DylinkSubsection{Id: id}
+
+		switch W2J_DYLINK_SUBSECTION_TYPES[id] {
+		case "mem_info":
+			info := &tool.DylinkMemInfo{}
+			for _, field := range []*uint32{&info.MemorySize, &info.MemoryAlign, &info.TableSize, &info.TableAlign} {
+				*field, err = tool.DecodeULEB128(sub)
+				if err != nil {
+					return nil, err
+				}
+			}
+			entry.MemInfo = info
+		case "needed":
+			num, err := readCount(sub)
+			if err != nil {
+				return nil, err
+			}
+			for i := uint32(0); i < num; i++ {
+				needed, err := c.readString(sub)
+				if err != nil {
+					return nil, err
+				}
+				entry.Needed = append(entry.Needed, needed)
+			}
+		case "export_info":
+			num, err := readCount(sub)
+			if err != nil {
+				return nil, err
+			}
+			for i := uint32(0); i < num; i++ {
+				info := tool.DylinkExportInfo{}
+				info.Name, err = c.readString(sub)
+				if err != nil {
+					return nil, err
+				}
+				info.Flags, err = tool.DecodeULEB128(sub)
+				if err != nil {
+					return nil, err
+				}
+				entry.ExportInfo = append(entry.ExportInfo, info)
+			}
+		case "import_info":
+			num, err := readCount(sub)
+			if err != nil {
+				return nil, err
+			}
+			for i := uint32(0); i < num; i++ {
+				info := tool.DylinkImportInfo{}
+				info.ModuleStr, err = c.readString(sub)
+				if err != nil {
+					return nil, err
+				}
+				info.FieldStr, err = c.readString(sub)
+				if err != nil {
+					return nil, err
+				}
+				info.Flags, err = tool.DecodeULEB128(sub)
+				if err != nil {
+					return nil, err
+				}
+				entry.ImportInfo = append(entry.ImportInfo, info)
+			}
+		default:
+			entry.Payload = append([]byte{}, sub.Bytes()...)
+			sub.Read(sub.Len())
+		}
+		if sub.Len() != 0 {
+			return nil, fmt.Errorf("unexpected %d trailing bytes in dylink subsection %d", sub.Len(), id)
+		}
+		dylink = append(dylink, entry)
+	}
+
+	return dylink, nil
+}
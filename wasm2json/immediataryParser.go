@@ -29,11 +29,11 @@ func (immediataryParser) Varint32(stream *tool.Stream) (int32, error) {
 }
 
 func (immediataryParser) Varint64(stream *tool.Stream) (int64, error) {
-	ret, err := tool.DecodeSLEB128(stream)
+	ret, err := tool.DecodeSLEB128Int64(stream)
 	if err != nil {
 		return 0, err
 	}
-	return int64(ret), nil
+	return ret, nil
 }
 
 func (immediataryParser) Uint32(stream *tool.Stream) []byte {
@@ -52,54 +52,52 @@ func (immediataryParser) BlockType(stream *tool.Stream) (string, error) {
 	return W2J_LANGUAGE_TYPES[ret], nil
 }
 
-func (immediataryParser) BrTable(stream *tool.Stream) (tool.JSON, error) {
-	jsonObj := make(tool.JSON)
-	targets := []uint32{}
+func (immediataryParser) BrTable(stream *tool.Stream) (tool.BrTable, error) {
+	brTable := tool.BrTable{Targets: []uint32{}}
 
-	num, err := tool.DecodeULEB128(stream)
+	num, err := readCount(stream)
 	if err != nil {
-		return nil, err
+		return tool.BrTable{}, err
 	}
 	for i := uint32(0); i < num; i++ {
 		target, err := tool.DecodeULEB128(stream)
 		if err != nil {
-			return nil, err
+			return tool.BrTable{}, err
 		}
-		targets = append(targets, target)
+		brTable.Targets = append(brTable.Targets, target)
 	}
 
-	jsonObj["targets"] = targets
-	jsonObj["default_target"], err = tool.DecodeULEB128(stream)
+	brTable.DefaultTarget, err = tool.DecodeULEB128(stream)
 	if err != nil {
-		return nil, err
+		return tool.BrTable{}, err
 	}
-	return jsonObj, nil
+	return brTable, nil
 }
 
-func (immediataryParser) CallIndirect(stream *tool.Stream) (tool.JSON, error) {
-	jsonObj := make(tool.JSON)
+func (immediataryParser) CallIndirect(stream *tool.Stream) (tool.CallIndirect, error) {
+	callIndirect := tool.CallIndirect{}
 	var err error
-	jsonObj["index"], err = tool.DecodeULEB128(stream)
+	callIndirect.Index, err = tool.DecodeULEB128(stream)
 	if err != nil {
-		return nil, err
+		return tool.CallIndirect{}, err
 	}
-	jsonObj["reserved"], err = stream.ReadByte()
+	callIndirect.Reserved, err = stream.ReadByte()
 	if err != nil {
-		return nil, err
+		return tool.CallIndirect{}, err
 	}
-	return jsonObj, nil
+	return callIndirect, nil
 }
 
-func (immediataryParser) MemoryImmediate(stream *tool.Stream) (tool.JSON, error) {
-	jsonObj := make(tool.JSON)
+func (immediataryParser) MemoryImmediate(stream *tool.Stream) (tool.MemoryImmediate, error) {
+	memImm := tool.MemoryImmediate{}
 	var err error
-	jsonObj["flags"], err = tool.DecodeULEB128(stream)
+	memImm.Flags, err = tool.DecodeULEB128(stream)
 	if err != nil {
-		return nil, err
+		return tool.MemoryImmediate{}, err
 	}
-	jsonObj["offset"], err = tool.DecodeULEB128(stream)
+	memImm.Offset, err = tool.DecodeULEB128(stream)
 	if err != nil {
-		return nil, err
+		return tool.MemoryImmediate{}, err
 	}
-	return jsonObj, nil
+	return memImm, nil
 }
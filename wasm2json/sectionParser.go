@@ -1,9 +1,28 @@
 package wasm2json
 
-import "github.com/meshplus/go-wasm-metering/tool"
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
 
 type sectionParser struct{}
 
+// readCount reads the length of a vector. Entries take a byte at least, so
+// lengths above the bytes left are rejected before anything is allocated.
+func readCount(stream *tool.Stream) (uint32, error) {
+	count, err := tool.DecodeULEB128(stream)
+	if err != nil {
+		return 0, err
+	}
+	if int64(count) > int64(stream.Len()) {
+		return 0, fmt.Errorf("length out of bounds: %d entries, %d bytes left", count, stream.Len())
+	}
+	return count, nil
+}
+
 func (sectionParser) Custom(stream *tool.Stream, header tool.SectionHeader) (tool.CustomSec, error) {
 	sec := tool.CustomSec{Name: "custom"}
 
@@ -14,7 +33,11 @@ func (sectionParser) Custom(stream *tool.Stream, header tool.SectionHeader) (too
 		return tool.CustomSec{}, err
 	}
 	name := section.Read(int(nameLen))
+	if section.BytesRead > section.Length {
+		return tool.CustomSec{}, fmt.Errorf("unexpected end of custom section")
+	}
 	sec.SectionName = string(name)
+	payload := section.Bytes()
 
 	var custom interface{}
 	switch string(name) {
@@ -23,8 +46,28 @@ func (sectionParser) Custom(stream *tool.Stream, header tool.SectionHeader) (too
 		if err != nil {
 			return tool.CustomSec{}, err
 		}
+	case "producers":
+		custom, err = cparser.Producers(section)
+	case "target_features":
+		custom, err = cparser.TargetFeatures(section)
+	case "sourceMappingURL":
+		var url string
+		url, err = cparser.URL(section)
+		custom = tool.SourceMappingURL(url)
+	case "external_debug_info":
+		var url string
+		url, err = cparser.URL(section)
+		custom = tool.ExternalDebugInfo(url)
+	case "dylink.0":
+		custom, err = cparser.Dylink(section)
 	default:
-		custom = section.String()
+		custom = append([]byte{}, payload...)
+	}
+
+	// custom sections must not invalidate a module, so a well-known section
+	// that fails to decode is kept as its raw payload.
+	if err != nil {
+		custom = append([]byte{}, payload...)
 	}
 
 	sec.Custom = custom
@@ -33,7 +76,7 @@ func (sectionParser) Custom(stream *tool.Stream, header tool.SectionHeader) (too
 }
 
 func (sectionParser) Type(stream *tool.Stream) (tool.TypeSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.TypeSec{}, err
 	}
@@ -47,12 +90,16 @@ func (sectionParser) Type(stream *tool.Stream) (tool.TypeSec, error) {
 		if err != nil {
 			return tool.TypeSec{}, err
 		}
+		// the form is a one byte signed LEB128 number.
+		if typ&0x80 != 0 {
+			return tool.TypeSec{}, fmt.Errorf("integer representation too long")
+		}
 		entry := tool.TypeEntry{
 			Form:   W2J_LANGUAGE_TYPES[typ],
 			Params: []string{},
 		}
 
-		paramCount, err := tool.DecodeULEB128(stream)
+		paramCount, err := readCount(stream)
 		if err != nil {
 			return tool.TypeSec{}, err
 		}
@@ -66,7 +113,7 @@ func (sectionParser) Type(stream *tool.Stream) (tool.TypeSec, error) {
 			entry.Params = append(entry.Params, W2J_LANGUAGE_TYPES[typ])
 		}
 
-		numOfReturns, err := tool.DecodeULEB128(stream)
+		numOfReturns, err := readCount(stream)
 		if err != nil {
 			return tool.TypeSec{}, err
 		}
@@ -86,7 +133,7 @@ func (sectionParser) Type(stream *tool.Stream) (tool.TypeSec, error) {
 }
 
 func (s sectionParser) Import(stream *tool.Stream) (tool.ImportSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.ImportSec{}, err
 	}
@@ -151,7 +198,7 @@ func (s sectionParser) Import(stream *tool.Stream) (tool.ImportSec, error) {
 }
 
 func (sectionParser) Function(stream *tool.Stream) (tool.FuncSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.FuncSec{}, err
 	}
@@ -171,7 +218,7 @@ func (sectionParser) Function(stream *tool.Stream) (tool.FuncSec, error) {
 }
 
 func (s sectionParser) Table(stream *tool.Stream) (tool.TableSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.TableSec{}, err
 	}
@@ -193,7 +240,7 @@ func (s sectionParser) Table(stream *tool.Stream) (tool.TableSec, error) {
 }
 
 func (sectionParser) Memory(stream *tool.Stream) (tool.MemSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.MemSec{}, err
 	}
@@ -213,7 +260,7 @@ func (sectionParser) Memory(stream *tool.Stream) (tool.MemSec, error) {
 }
 
 func (sectionParser) Global(stream *tool.Stream) (tool.GlobalSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.GlobalSec{}, err
 	}
@@ -243,7 +290,7 @@ func (sectionParser) Global(stream *tool.Stream) (tool.GlobalSec, error) {
 }
 
 func (sectionParser) Export(stream *tool.Stream) (tool.ExportSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.ExportSec{}, err
 	}
@@ -292,7 +339,7 @@ func (sectionParser) Start(stream *tool.Stream) (tool.StartSec, error) {
 }
 
 func (sectionParser) Element(stream *tool.Stream) (tool.ElementSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.ElementSec{}, err
 	}
@@ -312,7 +359,7 @@ func (sectionParser) Element(stream *tool.Stream) (tool.ElementSec, error) {
 			return tool.ElementSec{}, err
 		}
 
-		numElem, err := tool.DecodeULEB128(stream)
+		numElem, err := readCount(stream)
 		if err != nil {
 			return tool.ElementSec{}, err
 		}
@@ -331,7 +378,7 @@ func (sectionParser) Element(stream *tool.Stream) (tool.ElementSec, error) {
 }
 
 func (sectionParser) Code(stream *tool.Stream) (tool.CodeSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.CodeSec{}, err
 	}
@@ -341,53 +388,120 @@ func (sectionParser) Code(stream *tool.Stream) (tool.CodeSec, error) {
 	}
 
 	for i := uint32(0); i < numberOfEntries; i++ {
-		codeBody := tool.CodeBody{
-			Locals: []tool.LocalEntry{},
-			Code:   []tool.OP{},
+		codeBody, err := ParseCodeBody(stream, i)
+		if err != nil {
+			return tool.CodeSec{}, err
 		}
+		codeSec.Entries = append(codeSec.Entries, codeBody)
+	}
+
+	return codeSec, nil
+}
 
+// LazyCode parses the code section, keeping the bytes of the function bodies
+// to decode them when accessed. `offset` is the offset of the section payload
+// in the module.
+func (sectionParser) LazyCode(stream *tool.Stream, offset int) (*tool.CodeSec, error) {
+	numberOfEntries, err := readCount(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		raw     [][]byte
+		offsets []int
+	)
+	for i := uint32(0); i < numberOfEntries; i++ {
+		offsets = append(offsets, offset+stream.BytesRead)
 		bodySize, err := tool.DecodeULEB128(stream)
-		if err != nil {
-			return tool.CodeSec{}, err
+		if err != nil || int(bodySize) > stream.Len() {
+			return nil, fmt.Errorf("function body %d: unexpected end of code section", i)
 		}
-		endBytes := stream.BytesRead + int(bodySize)
+		raw = append(raw, append([]byte{}, stream.Read(int(bodySize))...))
+	}
 
-		// parse locals
-		localCount, err := tool.DecodeULEB128(stream)
+	return tool.NewLazyCodeSec(raw, offsets, decodeCodeBody), nil
+}
+
+// decodeCodeBody decodes a function body kept by LazyCode.
+func decodeCodeBody(body []byte, index uint32) (tool.CodeBody, error) {
+	stream := tool.NewStream(nil)
+	if _, err := tool.EncodeULEB128(uint32(len(body)), stream); err != nil {
+		return tool.CodeBody{}, err
+	}
+	if _, err := stream.Write(body); err != nil {
+		return tool.CodeBody{}, err
+	}
+	codeBody, err := ParseCodeBody(stream, index)
+	if errors.Is(err, io.EOF) {
+		return tool.CodeBody{}, fmt.Errorf("function body %d: unexpected end", index)
+	}
+	return codeBody, err
+}
+
+// ParseCodeBody parses the function body at `index` in the code section,
+// starting with its size.
+func ParseCodeBody(stream *tool.Stream, index uint32) (tool.CodeBody, error) {
+	codeBody := tool.CodeBody{
+		Locals: []tool.LocalEntry{},
+		Code:   []tool.OP{},
+	}
+
+	bodySize, err := tool.DecodeULEB128(stream)
+	if err != nil {
+		return tool.CodeBody{}, err
+	}
+	endBytes := stream.BytesRead + int(bodySize)
+
+	// parse locals
+	localCount, err := readCount(stream)
+	if err != nil {
+		return tool.CodeBody{}, err
+	}
+	for j := uint32(0); j < localCount; j++ {
+		local := tool.LocalEntry{}
+		local.Count, err = tool.DecodeULEB128(stream)
 		if err != nil {
-			return tool.CodeSec{}, err
+			return tool.CodeBody{}, err
 		}
-		for j := uint32(0); j < localCount; j++ {
-			local := tool.LocalEntry{}
-			local.Count, err = tool.DecodeULEB128(stream)
-			if err != nil {
-				return tool.CodeSec{}, err
-			}
-			typ, err := stream.ReadByte()
-			if err != nil {
-				return tool.CodeSec{}, err
-			}
-			local.Type = W2J_LANGUAGE_TYPES[typ]
-			codeBody.Locals = append(codeBody.Locals, local)
+		typ, err := stream.ReadByte()
+		if err != nil {
+			return tool.CodeBody{}, err
 		}
+		local.Type = W2J_LANGUAGE_TYPES[typ]
+		codeBody.Locals = append(codeBody.Locals, local)
+	}
 
-		// parse code
-		for stream.BytesRead < endBytes {
-			op, err := ParseOp(stream)
-			if err != nil {
-				return tool.CodeSec{}, err
-			}
-			codeBody.Code = append(codeBody.Code, op)
+	// parse code, up to the `end` of the body.
+	depth := 0
+	for stream.BytesRead < endBytes {
+		if depth < 0 {
+			return tool.CodeBody{}, fmt.Errorf("function body %d: operators after the end of the body", index)
 		}
-
-		codeSec.Entries = append(codeSec.Entries, codeBody)
+		op, err := ParseOp(stream)
+		if err != nil {
+			return tool.CodeBody{}, err
+		}
+		switch op.Name() {
+		case "block", "loop", "if":
+			depth++
+		case "end":
+			depth--
+		}
+		codeBody.Code = append(codeBody.Code, op)
+	}
+	if depth >= 0 {
+		return tool.CodeBody{}, fmt.Errorf("function body %d: END opcode expected", index)
+	}
+	if stream.BytesRead != endBytes {
+		return tool.CodeBody{}, fmt.Errorf("function body %d size mismatch: declared %d bytes, decoded %d", index, bodySize, int(bodySize)+stream.BytesRead-endBytes)
 	}
 
-	return codeSec, nil
+	return codeBody, nil
 }
 
 func (sectionParser) Data(stream *tool.Stream) (tool.DataSec, error) {
-	numberOfEntries, err := tool.DecodeULEB128(stream)
+	numberOfEntries, err := readCount(stream)
 	if err != nil {
 		return tool.DataSec{}, err
 	}
@@ -424,7 +538,7 @@ func (sectionParser) DataCount(stream *tool.Stream) (tool.DataCountSec, error) {
 		return tool.DataCountSec{}, err
 	}
 	dataCountSec := tool.DataCountSec{
-		Name:  "data_count",
+		Name:  "data count",
 		Count: count,
 	}
 
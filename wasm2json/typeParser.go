@@ -1,6 +1,10 @@
 package wasm2json
 
-import "github.com/meshplus/go-wasm-metering/tool"
+import (
+	"fmt"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
 
 type typeParser struct{}
 
@@ -43,6 +47,9 @@ func (typeParser) Memory(stream *tool.Stream) (tool.MemLimits, error) {
 	if err != nil {
 		return tool.MemLimits{}, err
 	}
+	if flags > 1 {
+		return tool.MemLimits{}, fmt.Errorf("malformed limits flags %#x", flags)
+	}
 	intial, err := tool.DecodeULEB128(stream)
 	if err != nil {
 		return tool.MemLimits{}, err
@@ -1,7 +1,10 @@
 package wasm2json
 
 import (
-	"strings"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 
 	"github.com/meshplus/go-wasm-metering/tool"
 )
@@ -13,117 +16,186 @@ var (
 	cparser    = customParser{}
 )
 
+// MAGIC and VERSION are the preramble of a module.
+var (
+	MAGIC   = []byte{0x00, 0x61, 0x73, 0x6d}
+	VERSION = []byte{0x01, 0x00, 0x00, 0x00}
+)
+
+// Options configures the decoder.
+type Options struct {
+	Lenient bool // skip sections with an unknown id by their size instead of failing.
+
+	// LazyCode keeps the bytes of function bodies and decodes them when
+	// accessed by CodeSec.Body or CodeSec.Decode, for tools reading only
+	// some of them.
+	LazyCode bool
+}
+
 // Wasm2Json convert the wasm binary to a JSON array output.
 func Wasm2Json(buf []byte) ([]tool.JSON, error) {
+	return Wasm2JsonWithOptions(buf, Options{})
+}
+
+// Wasm2JsonWithOptions converts the wasm binary to a JSON array output.
+func Wasm2JsonWithOptions(buf []byte, opts Options) ([]tool.JSON, error) {
+	module, err := Wasm2ModuleWithOptions(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tool.ModuleToJSON(module)
+}
+
+// Wasm2Module converts the wasm binary to a typed module.
+func Wasm2Module(buf []byte) (*tool.Module, error) {
+	return Wasm2ModuleWithOptions(buf, Options{})
+}
+
+// Wasm2ModuleWithOptions converts the wasm binary to a typed module. The
+// structure of the module is checked: the preramble, the order of sections,
+// that known sections appear at most once, that every section decodes to
+// exactly its declared size and that the function and code sections have
+// the same number of entries.
+func Wasm2ModuleWithOptions(buf []byte, opts Options) (*tool.Module, error) {
+	if err := CheckPreramble(buf); err != nil {
+		return nil, err
+	}
 	stream := tool.NewStream(buf)
-	preramble := ParsePreramble(stream)
-	resJson := []tool.JSON{preramble}
+	module := &tool.Module{
+		Magic:   stream.Read(4),
+		Version: stream.Read(4),
+	}
+	if !bytes.Equal(module.Version, VERSION) {
+		return nil, fmt.Errorf("unknown binary version %x", module.Version)
+	}
 
+	last := ""
 	for stream.Len() != 0 {
 		header, err := ParseSectionHeader(stream)
 		if err != nil {
 			return nil, err
 		}
-		jsonObj := make(tool.JSON)
-		switch header.Name {
-		case "custom":
-			rsec, err := secParser.Custom(stream, header)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["section_name"] = rsec.SectionName
-			jsonObj["custom"] = rsec.Custom
-		case "type":
-			rsec, err := secParser.Type(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "import":
-			rsec, err := secParser.Import(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "function":
-			rsec, err := secParser.Function(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "table":
-			rsec, err := secParser.Table(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "memory":
-			rsec, err := secParser.Memory(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "global":
-			rsec, err := secParser.Global(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "export":
-			rsec, err := secParser.Export(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "start":
-			rsec, err := secParser.Start(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["index"] = rsec.Index
-		case "element":
-			rsec, err := secParser.Element(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "code":
-			rsec, err := secParser.Code(stream)
-			if err != nil {
-				return nil, err
-			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "data":
-			rsec, err := secParser.Data(stream)
-			if err != nil {
-				return nil, err
+		payload := stream.Read(int(header.Size))
+		if stream.BytesRead > stream.Length {
+			return nil, fmt.Errorf("unexpected end of section %d", header.Id)
+		}
+
+		if header.Name == "" {
+			if opts.Lenient {
+				continue
 			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["entries"] = rsec.Entries
-		case "data count":
-			rsec, err := secParser.DataCount(stream)
-			if err != nil {
-				return nil, err
+			return nil, fmt.Errorf("unknown section id: %d", header.Id)
+		}
+		if header.Name != "custom" {
+			switch order := tool.SECTION_ORDER[header.Name]; {
+			case last == header.Name:
+				return nil, fmt.Errorf("duplicate %s section", header.Name)
+			case last != "" && order < tool.SECTION_ORDER[last]:
+				return nil, fmt.Errorf("%s section out of order after %s section", header.Name, last)
 			}
-			jsonObj["name"] = rsec.Name
-			jsonObj["count"] = rsec.Count
+			last = header.Name
+		}
+
+		sectionStream := tool.NewStream(payload)
+		var section tool.Section
+		if header.Name == "code" && opts.LazyCode {
+			section, err = secParser.LazyCode(sectionStream, stream.BytesRead-int(header.Size))
+		} else {
+			section, err = ParseSection(sectionStream, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sectionStream.BytesRead != int(header.Size) {
+			return nil, fmt.Errorf("%s section size mismatch: declared %d bytes, decoded %d", header.Name, header.Size, sectionStream.BytesRead)
 		}
+		module.Sections = append(module.Sections, section)
+	}
 
-		resJson = append(resJson, jsonObj)
+	var numFuncs, numCodes int
+	if sec := module.FuncSec(); sec != nil {
+		numFuncs = len(sec.Entries)
+	}
+	if sec := module.CodeSec(); sec != nil {
+		numCodes = len(sec.Entries)
+	}
+	if numFuncs != numCodes {
+		return nil, fmt.Errorf("function and code section have inconsistent lengths %d and %d", numFuncs, numCodes)
 	}
 
-	return resJson, nil
+	return module, nil
+}
+
+// ParseSection parses the payload of the section described by header.
+func ParseSection(stream *tool.Stream, header tool.SectionHeader) (tool.Section, error) {
+	var (
+		section tool.Section
+		err     error
+	)
+	switch header.Name {
+	case "custom":
+		var rsec tool.CustomSec
+		rsec, err = secParser.Custom(stream, header)
+		section = &rsec
+	case "type":
+		var rsec tool.TypeSec
+		rsec, err = secParser.Type(stream)
+		section = &rsec
+	case "import":
+		var rsec tool.ImportSec
+		rsec, err = secParser.Import(stream)
+		section = &rsec
+	case "function":
+		var rsec tool.FuncSec
+		rsec, err = secParser.Function(stream)
+		section = &rsec
+	case "table":
+		var rsec tool.TableSec
+		rsec, err = secParser.Table(stream)
+		section = &rsec
+	case "memory":
+		var rsec tool.MemSec
+		rsec, err = secParser.Memory(stream)
+		section = &rsec
+	case "global":
+		var rsec tool.GlobalSec
+		rsec, err = secParser.Global(stream)
+		section = &rsec
+	case "export":
+		var rsec tool.ExportSec
+		rsec, err = secParser.Export(stream)
+		section = &rsec
+	case "start":
+		var rsec tool.StartSec
+		rsec, err = secParser.Start(stream)
+		section = &rsec
+	case "element":
+		var rsec tool.ElementSec
+		rsec, err = secParser.Element(stream)
+		section = &rsec
+	case "code":
+		var rsec tool.CodeSec
+		rsec, err = secParser.Code(stream)
+		section = &rsec
+	case "data":
+		var rsec tool.DataSec
+		rsec, err = secParser.Data(stream)
+		section = &rsec
+	case "data count":
+		var rsec tool.DataCountSec
+		rsec, err = secParser.DataCount(stream)
+		section = &rsec
+	default:
+		return nil, fmt.Errorf("unknown section id: %d", header.Id)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unexpected end of %s section", header.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return section, nil
 }
 
 func ParsePreramble(stream *tool.Stream) tool.JSON {
@@ -146,7 +218,7 @@ func ParseSectionHeader(stream *tool.Stream) (tool.SectionHeader, error) {
 
 	size, err := tool.DecodeULEB128(stream)
 	if err != nil {
-		return tool.SectionHeader{}, err
+		return tool.SectionHeader{}, fmt.Errorf("unexpected end of section header %d", id)
 	}
 
 	return tool.SectionHeader{
@@ -157,31 +229,21 @@ func ParseSectionHeader(stream *tool.Stream) (tool.SectionHeader, error) {
 }
 
 func ParseOp(stream *tool.Stream) (tool.OP, error) {
-	finalOP := tool.OP{}
 	op, err := stream.ReadByte()
 	if err != nil {
 		return tool.OP{}, err
 	}
-	fullName := strings.Split(W2J_OPCODES[op], ".")
-	var (
-		typ           = fullName[0]
-		name          string
-		immediatesKey string
-	)
-
-	if len(fullName) < 2 {
-		name = typ
-	} else {
-		name = fullName[1]
-		finalOP.ReturnType = typ
+	if _, ok := W2J_OPCODES[op]; !ok {
+		return tool.OP{}, fmt.Errorf("illegal opcode %#x", op)
 	}
+	if W2J_UNSUPPORTED_OPCODES[op] {
+		return tool.OP{}, fmt.Errorf("unsupported opcode %#x", op)
+	}
+	finalOP := tool.OP{Opcode: op}
 
-	finalOP.Name = name
-
-	if name == "const" {
-		immediatesKey = typ
-	} else {
-		immediatesKey = name
+	immediatesKey := finalOP.Name()
+	if immediatesKey == "const" {
+		immediatesKey = finalOP.ReturnType()
 	}
 	immediates, exist := tool.OP_IMMEDIATES[immediatesKey]
 	if exist {
@@ -237,3 +299,17 @@ func ParseOp(stream *tool.Stream) (tool.OP, error) {
 
 	return finalOP, nil
 }
+
+// CheckPreramble checks the magic header of `buf` and that it holds a
+// version, in the order of the spec.
+func CheckPreramble(buf []byte) error {
+	switch {
+	case len(buf) < len(MAGIC):
+		return fmt.Errorf("unexpected end of preramble")
+	case !bytes.Equal(buf[:len(MAGIC)], MAGIC):
+		return fmt.Errorf("magic header not detected")
+	case len(buf) < len(MAGIC)+len(VERSION):
+		return fmt.Errorf("unexpected end of preramble")
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package wat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// node is an instruction together with the instructions nested in it.
+type node struct {
+	op      tool.OP
+	args    []*node // folded operands.
+	body    []*node // body of block and loop, `then` branch of if.
+	els     []*node // `else` branch of if.
+	hasElse bool
+	results int
+}
+
+func isBlock(op tool.OP) bool {
+	return op.ReturnType() == "" && (op.Name() == "block" || op.Name() == "loop" || op.Name() == "if")
+}
+
+// buildTree nests the bodies of structured instructions into their nodes. It
+// stops at the `end` or `else` closing the current sequence and returns it.
+func buildTree(code []tool.OP, pos *int) ([]*node, string) {
+	var seq []*node
+	for *pos < len(code) {
+		op := code[*pos]
+		*pos++
+		if op.ReturnType() == "" && (op.Name() == "end" || op.Name() == "else") {
+			return seq, op.Name()
+		}
+
+		n := &node{op: op}
+		if isBlock(op) {
+			var term string
+			n.body, term = buildTree(code, pos)
+			if term == "else" {
+				n.hasElse = true
+				n.els, term = buildTree(code, pos)
+			}
+			if term != "end" {
+				return append(seq, n), ""
+			}
+		}
+		seq = append(seq, n)
+	}
+	return seq, ""
+}
+
+// funcTree builds the tree of a function body, which must be terminated by a
+// single `end`.
+func funcTree(code []tool.OP) ([]*node, error) {
+	pos := 0
+	seq, term := buildTree(code, &pos)
+	if term != "end" || pos != len(code) {
+		return nil, fmt.Errorf("unbalanced function body")
+	}
+	return seq, nil
+}
+
+func blockResults(op tool.OP) int {
+	if typ, _ := op.Immediates.(string); typ != "" && typ != "block_type" {
+		return 1
+	}
+	return 0
+}
+
+// arity returns the number of operands and results of an instruction. Labels
+// holds the arity of the enclosing labels, innermost last.
+func (p *printer) arity(op tool.OP, labels []int) (params, results int, ok bool) {
+	name := op.FullName()
+	if sig, exist := tool.OP_SIGNATURES[name]; exist {
+		return len(sig.Params), len(sig.Results), true
+	}
+
+	label := func(depth uint32) (int, bool) {
+		if int(depth) >= len(labels) {
+			return 0, false
+		}
+		return labels[len(labels)-1-int(depth)], true
+	}
+
+	switch name {
+	case "nop", "unreachable":
+		return 0, 0, true
+	case "block", "loop":
+		return 0, blockResults(op), true
+	case "if":
+		return 1, blockResults(op), true
+	case "local.get", "global.get":
+		return 0, 1, true
+	case "local.set", "global.set", "drop":
+		return 1, 0, true
+	case "local.tee":
+		return 1, 1, true
+	case "select":
+		return 3, 1, true
+	case "return":
+		return p.results, 0, true
+	case "br":
+		if depth, isIndex := op.Immediates.(uint32); isIndex {
+			arity, ok := label(depth)
+			return arity, 0, ok
+		}
+	case "br_if":
+		if depth, isIndex := op.Immediates.(uint32); isIndex {
+			arity, ok := label(depth)
+			return arity + 1, arity, ok
+		}
+	case "br_table":
+		if imm, isTable := op.Immediates.(tool.BrTable); isTable {
+			arity, ok := label(imm.DefaultTarget)
+			return arity + 1, 0, ok
+		}
+	case "call":
+		if index, isIndex := op.Immediates.(uint32); isIndex {
+			if typ, exist := p.module.FuncType(index); exist {
+				return len(typ.Params), len(typ.Returns), true
+			}
+		}
+	case "call_indirect":
+		if imm, isCall := op.Immediates.(tool.CallIndirect); isCall {
+			if sec := p.module.TypeSec(); sec != nil && imm.Index < uint32(len(sec.Entries)) {
+				typ := sec.Entries[imm.Index]
+				return len(typ.Params) + 1, len(typ.Returns), true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// fold nests the operands of every instruction in `seq` into it when they are
+// produced by the instructions right before it. Instructions of unknown arity
+// are never folded and end up at the top level.
+func (p *printer) fold(seq []*node, labels []int) []*node {
+	var stack []*node
+	for _, n := range seq {
+		params, results, ok := p.arity(n.op, labels)
+
+		if isBlock(n.op) {
+			label := results
+			if n.op.Name() == "loop" {
+				label = 0
+			}
+			inner := append(labels[:len(labels):len(labels)], label)
+			n.body = p.fold(n.body, inner)
+			n.els = p.fold(n.els, inner)
+		}
+
+		if ok && params > 0 && params <= len(stack) {
+			args := stack[len(stack)-params:]
+			foldable := true
+			for _, arg := range args {
+				if arg.results != 1 {
+					foldable = false
+					break
+				}
+			}
+			if foldable {
+				n.args = append([]*node{}, args...)
+				stack = stack[:len(stack)-params]
+			}
+		}
+		if ok {
+			n.results = results
+		}
+		stack = append(stack, n)
+	}
+	return stack
+}
+
+// printFlat prints a sequence of instructions one per line.
+func (p *printer) printFlat(seq []*node) {
+	for _, n := range seq {
+		p.line("%s", p.instr(n.op))
+		if !isBlock(n.op) {
+			continue
+		}
+		p.depth++
+		p.printFlat(n.body)
+		p.depth--
+		if n.hasElse {
+			p.line("else")
+			p.depth++
+			p.printFlat(n.els)
+			p.depth--
+		}
+		p.line("end")
+	}
+}
+
+// printFolded prints a folded instruction, on a single line if it does not
+// contain structured instructions.
+func (p *printer) printFolded(n *node) {
+	if inline, ok := p.inline(n); ok {
+		p.line("%s", inline)
+		return
+	}
+
+	p.line("(%s", p.instr(n.op))
+	p.depth++
+	for _, arg := range n.args {
+		p.printFolded(arg)
+	}
+	if n.op.Name() == "if" {
+		p.line("(then")
+		p.depth++
+		for _, child := range n.body {
+			p.printFolded(child)
+		}
+		p.depth--
+		p.line(")")
+		if n.hasElse {
+			p.line("(else")
+			p.depth++
+			for _, child := range n.els {
+				p.printFolded(child)
+			}
+			p.depth--
+			p.line(")")
+		}
+	} else {
+		for _, child := range n.body {
+			p.printFolded(child)
+		}
+	}
+	p.depth--
+	p.line(")")
+}
+
+func (p *printer) inline(n *node) (string, bool) {
+	if isBlock(n.op) {
+		return "", false
+	}
+	res := "(" + p.instr(n.op)
+	for _, arg := range n.args {
+		text, ok := p.inline(arg)
+		if !ok {
+			return "", false
+		}
+		res += " " + text
+	}
+	return res + ")", true
+}
+
+// instr returns the text of an instruction with its immediates.
+func (p *printer) instr(op tool.OP) string {
+	name := op.FullName()
+	op, _ = tool.OPFromJSON(op)
+
+	switch imm := op.Immediates.(type) {
+	case string:
+		if imm != "" && imm != "block_type" {
+			return name + " (result " + imm + ")"
+		}
+	case uint32:
+		switch op.ReturnType() {
+		case "local":
+			return name + " " + p.names.localRef(p.fn, imm)
+		case "":
+			if op.Name() == "call" {
+				return name + " " + p.names.funcRef(imm)
+			}
+		}
+		return name + " " + strconv.FormatUint(uint64(imm), 10)
+	case int32:
+		return name + " " + strconv.FormatInt(int64(imm), 10)
+	case int64:
+		return name + " " + strconv.FormatInt(imm, 10)
+	case []byte:
+		switch len(imm) {
+		case 4:
+			return name + " " + f32Text(binary.LittleEndian.Uint32(imm))
+		case 8:
+			return name + " " + f64Text(binary.LittleEndian.Uint64(imm))
+		}
+	case tool.BrTable:
+		var sb strings.Builder
+		sb.WriteString(name)
+		for _, target := range imm.Targets {
+			sb.WriteString(" " + strconv.FormatUint(uint64(target), 10))
+		}
+		sb.WriteString(" " + strconv.FormatUint(uint64(imm.DefaultTarget), 10))
+		return sb.String()
+	case tool.CallIndirect:
+		return fmt.Sprintf("%s (type %d)", name, imm.Index)
+	case tool.MemoryImmediate:
+		res := name
+		if imm.Offset != 0 {
+			res += " offset=" + strconv.FormatUint(uint64(imm.Offset), 10)
+		}
+		if imm.Flags != naturalAlign(op) {
+			res += " align=" + strconv.FormatUint(uint64(1)<<(imm.Flags&31), 10)
+		}
+		return res
+	}
+	return name
+}
+
+// naturalAlign returns the log2 of the natural alignment of a memory access.
+func naturalAlign(op tool.OP) uint32 {
+	switch {
+	case strings.HasSuffix(op.Name(), "8") || strings.Contains(op.Name(), "8_"):
+		return 0
+	case strings.HasSuffix(op.Name(), "16") || strings.Contains(op.Name(), "16_"):
+		return 1
+	case strings.HasSuffix(op.Name(), "32") || strings.Contains(op.Name(), "32_"):
+		return 2
+	case op.ReturnType() == "i64" || op.ReturnType() == "f64":
+		return 3
+	}
+	return 2
+}
+
+func f32Text(bits uint32) string {
+	sign := ""
+	if bits>>31 != 0 {
+		sign = "-"
+	}
+	if exp, mant := bits>>23&0xff, bits&0x7fffff; exp == 0xff {
+		switch mant {
+		case 0:
+			return sign + "inf"
+		case 0x400000:
+			return sign + "nan"
+		}
+		return fmt.Sprintf("%snan:0x%x", sign, mant)
+	}
+	return strconv.FormatFloat(float64(math.Float32frombits(bits)), 'g', -1, 32)
+}
+
+func f64Text(bits uint64) string {
+	sign := ""
+	if bits>>63 != 0 {
+		sign = "-"
+	}
+	if exp, mant := bits>>52&0x7ff, bits&0xfffffffffffff; exp == 0x7ff {
+		switch mant {
+		case 0:
+			return sign + "inf"
+		case 0x8000000000000:
+			return sign + "nan"
+		}
+		return fmt.Sprintf("%snan:0x%x", sign, mant)
+	}
+	return strconv.FormatFloat(math.Float64frombits(bits), 'g', -1, 64)
+}
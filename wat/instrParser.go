@@ -0,0 +1,423 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// LEGACY_OPS maps the operator names of older text formats to current ones.
+// Conversions such as `i32.trunc_s/f32` are rewritten by opName.
+var LEGACY_OPS = map[string]string{
+	"get_local":      "local.get",
+	"set_local":      "local.set",
+	"tee_local":      "local.tee",
+	"get_global":     "global.get",
+	"set_global":     "global.set",
+	"current_memory": "memory.size",
+	"grow_memory":    "memory.grow",
+}
+
+// opName returns the current name of an operator.
+func opName(name string) string {
+	if current, exist := LEGACY_OPS[name]; exist {
+		return current
+	}
+	slash := strings.IndexByte(name, '/')
+	if slash < 0 {
+		return name
+	}
+	base, from := name[:slash], name[slash+1:]
+	if strings.HasSuffix(base, "_s") || strings.HasSuffix(base, "_u") {
+		return base[:len(base)-2] + "_" + from + base[len(base)-2:]
+	}
+	return base + "_" + from
+}
+
+// funcParser parses the instructions of a function body or of a constant
+// expression.
+type funcParser struct {
+	p          *moduleParser
+	locals     map[string]uint32
+	localNames map[uint32]string
+	labels     []string // labels of the enclosing blocks, innermost last.
+	code       []tool.OP
+}
+
+func newFuncParser(p *moduleParser) *funcParser {
+	return &funcParser{
+		p:          p,
+		locals:     map[string]uint32{},
+		localNames: map[uint32]string{},
+	}
+}
+
+func (fp *funcParser) addLocal(id string, index uint32) error {
+	if id == "" {
+		return nil
+	}
+	if _, exist := fp.locals[id]; exist {
+		return fmt.Errorf("duplicate local %s", id)
+	}
+	fp.locals[id] = index
+	fp.localNames[index] = id[1:]
+	return nil
+}
+
+func (fp *funcParser) emit(op tool.OP) {
+	fp.code = append(fp.code, op)
+}
+
+// instrs parses a sequence of flat and folded instructions.
+func (fp *funcParser) instrs(items []*sexpr) error {
+	open := 0 // blocks opened by flat instructions in this sequence.
+	for i := 0; i < len(items); {
+		item := items[i]
+		if item.isList {
+			if err := fp.folded(item); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		if item.isStr || isID(item) {
+			return fmt.Errorf("%s: unexpected %s", item.pos(), item)
+		}
+
+		switch item.atom {
+		case "block", "loop", "if":
+			label, blockType, next, err := fp.blockHeader(items, i+1)
+			if err != nil {
+				return err
+			}
+			fp.emit(tool.NewOP(item.atom, blockType))
+			fp.labels = append(fp.labels, label)
+			open++
+			i = next
+		case "else", "end":
+			if open == 0 {
+				return fmt.Errorf("%s: unexpected %s", item.pos(), item.atom)
+			}
+			i++
+			if i < len(items) && isID(items[i]) {
+				if items[i].atom != fp.labels[len(fp.labels)-1] {
+					return fmt.Errorf("%s: mismatching label %s", items[i].pos(), items[i].atom)
+				}
+				i++
+			}
+			fp.emit(tool.NewOP(item.atom, nil))
+			if item.atom == "end" {
+				fp.labels = fp.labels[:len(fp.labels)-1]
+				open--
+			}
+		default:
+			op, next, err := fp.plain(items, i)
+			if err != nil {
+				return err
+			}
+			fp.emit(op)
+			i = next
+		}
+	}
+	if open > 0 {
+		return fmt.Errorf("unclosed block")
+	}
+	return nil
+}
+
+// folded parses a folded instruction.
+func (fp *funcParser) folded(s *sexpr) error {
+	items := s.list
+	if len(items) == 0 || items[0].isList || items[0].isStr {
+		return fmt.Errorf("%s: expected an instruction", s.pos())
+	}
+
+	switch keyword := items[0].atom; keyword {
+	case "block", "loop":
+		label, blockType, next, err := fp.blockHeader(items, 1)
+		if err != nil {
+			return err
+		}
+		fp.emit(tool.NewOP(keyword, blockType))
+		fp.labels = append(fp.labels, label)
+		if err := fp.instrs(items[next:]); err != nil {
+			return err
+		}
+		fp.labels = fp.labels[:len(fp.labels)-1]
+		fp.emit(tool.NewOP("end", nil))
+	case "if":
+		label, blockType, next, err := fp.blockHeader(items, 1)
+		if err != nil {
+			return err
+		}
+		for ; next < len(items) && !items[next].isKeyword("then"); next++ {
+			if !items[next].isList {
+				return fmt.Errorf("%s: unexpected %s", items[next].pos(), items[next])
+			}
+			if err := fp.folded(items[next]); err != nil {
+				return err
+			}
+		}
+		if next == len(items) {
+			return fmt.Errorf("%s: missing then", s.pos())
+		}
+		fp.emit(tool.NewOP(keyword, blockType))
+		fp.labels = append(fp.labels, label)
+		if err := fp.instrs(items[next].list[1:]); err != nil {
+			return err
+		}
+		next++
+		if next < len(items) && items[next].isKeyword("else") {
+			fp.emit(tool.NewOP("else", nil))
+			if err := fp.instrs(items[next].list[1:]); err != nil {
+				return err
+			}
+			next++
+		}
+		if next < len(items) {
+			return fmt.Errorf("%s: unexpected %s", items[next].pos(), items[next])
+		}
+		fp.labels = fp.labels[:len(fp.labels)-1]
+		fp.emit(tool.NewOP("end", nil))
+	default:
+		op, next, err := fp.plain(items, 0)
+		if err != nil {
+			return err
+		}
+		for _, operand := range items[next:] {
+			if !operand.isList {
+				return fmt.Errorf("%s: unexpected %s", operand.pos(), operand)
+			}
+			if err := fp.folded(operand); err != nil {
+				return err
+			}
+		}
+		fp.emit(op)
+	}
+	return nil
+}
+
+// blockHeader parses the optional label and result type of a block starting
+// at items[i].
+func (fp *funcParser) blockHeader(items []*sexpr, i int) (string, string, int, error) {
+	label := ""
+	if i < len(items) && isID(items[i]) {
+		label = items[i].atom
+		i++
+	}
+
+	var results []string
+	if i < len(items) && !items[i].isList {
+		// older text formats write the result type bare, e.g. `if i64`.
+		if typ, err := valueType(items[i]); err == nil {
+			results = append(results, typ)
+			i++
+		}
+	}
+	if i < len(items) && items[i].isKeyword("type") {
+		index, err := fp.p.ref(items[i].list[len(items[i].list)-1], "type")
+		if err != nil {
+			return "", "", 0, err
+		}
+		if index >= uint32(len(fp.p.types)) || len(fp.p.types[index].Params) > 0 {
+			return "", "", 0, fmt.Errorf("%s: unsupported block type", items[i].pos())
+		}
+		results = fp.p.types[index].Returns
+		i++
+	}
+	for ; i < len(items) && (items[i].isKeyword("param") || items[i].isKeyword("result")); i++ {
+		if items[i].head() == "param" && len(items[i].list) > 1 {
+			return "", "", 0, fmt.Errorf("%s: unsupported block params", items[i].pos())
+		}
+		for _, item := range items[i].list[1:] {
+			typ, err := valueType(item)
+			if err != nil {
+				return "", "", 0, err
+			}
+			results = append(results, typ)
+		}
+	}
+	switch len(results) {
+	case 0:
+		return label, "block_type", i, nil
+	case 1:
+		return label, results[0], i, nil
+	}
+	return "", "", 0, fmt.Errorf("%s: unsupported multi-value block", items[i-1].pos())
+}
+
+// isImmediate reports whether a node can be the immediate of an instruction.
+func isImmediate(s *sexpr) bool {
+	if s.isList || s.isStr || s.atom == "" {
+		return false
+	}
+	c := s.atom[0]
+	return c == '$' || c == '-' || c == '+' || c >= '0' && c <= '9' || s.atom == "inf" || strings.HasPrefix(s.atom, "nan")
+}
+
+// plain parses the plain instruction at items[i] and its immediates.
+func (fp *funcParser) plain(items []*sexpr, i int) (tool.OP, int, error) {
+	keyword := items[i]
+	name := opName(keyword.atom)
+	opcode, exist := tool.Opcode(name)
+	op := tool.OP{Opcode: opcode}
+	if !exist || name == "else" || name == "end" || isBlock(op) {
+		return tool.OP{}, 0, fmt.Errorf("%s: unknown operator %s", keyword.pos(), keyword.atom)
+	}
+	i++
+
+	key := op.Name()
+	if key == "const" {
+		key = op.ReturnType()
+	}
+	immediates, exist := tool.OP_IMMEDIATES[key]
+	if !exist {
+		return op, i, nil
+	}
+
+	// next returns the required immediate.
+	next := func() (*sexpr, error) {
+		if i >= len(items) || !isImmediate(items[i]) {
+			return nil, fmt.Errorf("%s: missing immediate of %s", keyword.pos(), name)
+		}
+		i++
+		return items[i-1], nil
+	}
+
+	var err error
+	switch immediates {
+	case "varuint32":
+		var imm *sexpr
+		if imm, err = next(); err != nil {
+			break
+		}
+		switch {
+		case op.Name() == "br" || op.Name() == "br_if":
+			op.Immediates, err = fp.label(imm)
+		case op.Name() == "call":
+			op.Immediates, err = fp.p.ref(imm, "func")
+		case op.ReturnType() == "local":
+			op.Immediates, err = fp.local(imm)
+		default:
+			op.Immediates, err = fp.p.ref(imm, op.ReturnType())
+		}
+	case "br_table":
+		var targets []uint32
+		for i < len(items) && isImmediate(items[i]) {
+			target, err := fp.label(items[i])
+			if err != nil {
+				return tool.OP{}, 0, err
+			}
+			targets = append(targets, target)
+			i++
+		}
+		if len(targets) == 0 {
+			return tool.OP{}, 0, fmt.Errorf("%s: missing immediate of %s", keyword.pos(), name)
+		}
+		op.Immediates = tool.BrTable{
+			Targets:       append([]uint32{}, targets[:len(targets)-1]...),
+			DefaultTarget: targets[len(targets)-1],
+		}
+	case "call_indirect":
+		var index uint32
+		if i < len(items) && isImmediate(items[i]) {
+			// older text formats write the type index as a bare immediate.
+			index, err = fp.p.ref(items[i], "type")
+			i++
+		} else {
+			var rest []*sexpr
+			index, _, rest, err = fp.p.typeUse(items[i:])
+			i = len(items) - len(rest)
+		}
+		op.Immediates = tool.CallIndirect{Index: index}
+	case "memory_immediate":
+		imm := tool.MemoryImmediate{Flags: naturalAlign(op)}
+		if i < len(items) && strings.HasPrefix(items[i].atom, "offset=") {
+			if imm.Offset, err = parseUint32(items[i].atom[len("offset="):]); err != nil {
+				return tool.OP{}, 0, fmt.Errorf("%s: %w", items[i].pos(), err)
+			}
+			i++
+		}
+		if i < len(items) && strings.HasPrefix(items[i].atom, "align=") {
+			align, err := parseUint32(items[i].atom[len("align="):])
+			if err != nil || align == 0 || align&(align-1) != 0 {
+				return tool.OP{}, 0, fmt.Errorf("%s: invalid alignment", items[i].pos())
+			}
+			imm.Flags = 0
+			for ; align > 1; align >>= 1 {
+				imm.Flags++
+			}
+			i++
+		}
+		op.Immediates = imm
+	case "varuint1":
+		op.Immediates = int8(0)
+	case "varint32", "varint64":
+		var imm *sexpr
+		if imm, err = next(); err != nil {
+			break
+		}
+		bitSize := 32
+		if immediates == "varint64" {
+			bitSize = 64
+		}
+		var v int64
+		if v, err = parseInt(imm.atom, bitSize); err != nil {
+			err = fmt.Errorf("%s: %w", imm.pos(), err)
+		} else if bitSize == 32 {
+			op.Immediates = int32(v)
+		} else {
+			op.Immediates = v
+		}
+	case "uint32", "uint64":
+		var imm *sexpr
+		if imm, err = next(); err != nil {
+			break
+		}
+		bitSize := 32
+		if immediates == "uint64" {
+			bitSize = 64
+		}
+		if op.Immediates, err = floatBytes(imm.atom, bitSize); err != nil {
+			err = fmt.Errorf("%s: %w", imm.pos(), err)
+		}
+	}
+	if err != nil {
+		return tool.OP{}, 0, err
+	}
+	return op, i, nil
+}
+
+// label resolves a label identifier or depth.
+func (fp *funcParser) label(s *sexpr) (uint32, error) {
+	if isID(s) {
+		for i := len(fp.labels) - 1; i >= 0; i-- {
+			if fp.labels[i] == s.atom {
+				return uint32(len(fp.labels) - 1 - i), nil
+			}
+		}
+		return 0, fmt.Errorf("%s: unknown label %s", s.pos(), s.atom)
+	}
+	depth, err := parseUint32(s.atom)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.pos(), err)
+	}
+	return depth, nil
+}
+
+// local resolves a local identifier or index.
+func (fp *funcParser) local(s *sexpr) (uint32, error) {
+	if isID(s) {
+		index, exist := fp.locals[s.atom]
+		if !exist {
+			return 0, fmt.Errorf("%s: unknown local %s", s.pos(), s.atom)
+		}
+		return index, nil
+	}
+	index, err := parseUint32(s.atom)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.pos(), err)
+	}
+	return index, nil
+}
@@ -0,0 +1,251 @@
+package wat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// sexpr is a node of the S-expression tree of a WAT text: an atom (keyword,
+// identifier or number), a string or a list.
+type sexpr struct {
+	atom   string
+	str    []byte
+	isStr  bool
+	list   []*sexpr
+	isList bool
+	line   int
+	col    int
+}
+
+func (s *sexpr) pos() string {
+	return fmt.Sprintf("%d:%d", s.line, s.col)
+}
+
+// isKeyword reports whether the node is a list starting with `keyword`.
+func (s *sexpr) isKeyword(keyword string) bool {
+	return s.isList && len(s.list) > 0 && s.list[0].atom == keyword
+}
+
+// head returns the keyword a list starts with.
+func (s *sexpr) head() string {
+	if s.isList && len(s.list) > 0 {
+		return s.list[0].atom
+	}
+	return ""
+}
+
+func (s *sexpr) String() string {
+	switch {
+	case s.isList:
+		return "(" + s.head() + " ...)"
+	case s.isStr:
+		return quote(s.str)
+	}
+	return s.atom
+}
+
+// lexer splits a WAT text into S-expressions, dropping comments.
+type lexer struct {
+	text string
+	pos  int
+	line int
+	col  int
+}
+
+// readSexprs returns the top-level S-expressions of a text.
+func readSexprs(text string) ([]*sexpr, error) {
+	l := &lexer{text: text, line: 1, col: 1}
+	var res []*sexpr
+	for {
+		if err := l.skip(); err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.text) {
+			return res, nil
+		}
+		s, err := l.read()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%d:%d: %s", l.line, l.col, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) advance(n int) {
+	for _, c := range l.text[l.pos : l.pos+n] {
+		if c == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.pos += n
+}
+
+// skip skips white space, line comments and nested block comments.
+func (l *lexer) skip() error {
+	for l.pos < len(l.text) {
+		rest := l.text[l.pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r':
+			l.advance(1)
+		case strings.HasPrefix(rest, ";;"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			l.advance(end)
+		case strings.HasPrefix(rest, "(;"):
+			depth := 0
+			i := 0
+			for {
+				if i >= len(rest) {
+					return l.errorf("unterminated block comment")
+				}
+				if strings.HasPrefix(rest[i:], "(;") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(rest[i:], ";)") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			l.advance(i)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) read() (*sexpr, error) {
+	s := &sexpr{line: l.line, col: l.col}
+	switch l.text[l.pos] {
+	case '(':
+		l.advance(1)
+		s.isList = true
+		for {
+			if err := l.skip(); err != nil {
+				return nil, err
+			}
+			if l.pos >= len(l.text) {
+				return nil, fmt.Errorf("%d:%d: unclosed list", s.line, s.col)
+			}
+			if l.text[l.pos] == ')' {
+				l.advance(1)
+				return s, nil
+			}
+			child, err := l.read()
+			if err != nil {
+				return nil, err
+			}
+			s.list = append(s.list, child)
+		}
+	case ')':
+		return nil, l.errorf("unexpected )")
+	case '"':
+		str, err := l.readString()
+		if err != nil {
+			return nil, err
+		}
+		s.str = str
+		s.isStr = true
+		return s, nil
+	}
+
+	end := l.pos
+	for end < len(l.text) && !strings.ContainsRune(" \t\n\r()\";", rune(l.text[end])) {
+		end++
+	}
+	if end == l.pos {
+		return nil, l.errorf("unexpected character %q", l.text[l.pos])
+	}
+	s.atom = l.text[l.pos:end]
+	l.advance(end - l.pos)
+	return s, nil
+}
+
+// readString reads a string literal, decoding its escapes.
+func (l *lexer) readString() ([]byte, error) {
+	var res []byte
+	i := l.pos + 1
+	for {
+		if i >= len(l.text) || l.text[i] == '\n' {
+			return nil, l.errorf("unterminated string")
+		}
+		c := l.text[i]
+		if c == '"' {
+			l.advance(i + 1 - l.pos)
+			return res, nil
+		}
+		if c < 0x20 || c == 0x7f {
+			return nil, l.errorf("invalid character in string")
+		}
+		if c != '\\' {
+			res = append(res, c)
+			i++
+			continue
+		}
+
+		if i+1 >= len(l.text) {
+			return nil, l.errorf("unterminated string")
+		}
+		switch e := l.text[i+1]; e {
+		case 't':
+			res = append(res, '\t')
+		case 'n':
+			res = append(res, '\n')
+		case 'r':
+			res = append(res, '\r')
+		case '"', '\'', '\\':
+			res = append(res, e)
+		case 'u':
+			end := strings.IndexByte(l.text[i:], '}')
+			if !strings.HasPrefix(l.text[i+2:], "{") || end < 0 {
+				return nil, l.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(strings.ReplaceAll(l.text[i+3:i+end], "_", ""), 16, 32)
+			if err != nil || code >= 0xd800 && code < 0xe000 || code > 0x10ffff {
+				return nil, l.errorf("invalid unicode escape")
+			}
+			res = append(res, string(rune(code))...)
+			i += end + 1
+			continue
+		default:
+			if i+2 >= len(l.text) {
+				return nil, l.errorf("unterminated string")
+			}
+			b, err := strconv.ParseUint(l.text[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, l.errorf("invalid escape \\%s", l.text[i+1:i+3])
+			}
+			res = append(res, byte(b))
+			i += 3
+			continue
+		}
+		i += 2
+	}
+}
+
+// text returns the string of a node as UTF-8 text, for names.
+func text(s *sexpr) (string, error) {
+	if !s.isStr {
+		return "", fmt.Errorf("%s: expected a string, got %s", s.pos(), s)
+	}
+	if !utf8.Valid(s.str) {
+		return "", fmt.Errorf("%s: malformed UTF-8 encoding", s.pos())
+	}
+	return string(s.str), nil
+}
@@ -0,0 +1,135 @@
+package wat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// splitSign returns the sign and the magnitude of a number literal.
+func splitSign(s string) (bool, string) {
+	switch {
+	case strings.HasPrefix(s, "-"):
+		return true, s[1:]
+	case strings.HasPrefix(s, "+"):
+		return false, s[1:]
+	}
+	return false, s
+}
+
+// parseNat parses an unsigned decimal or hexadecimal literal with `_`
+// separators.
+func parseNat(s string, bitSize int) (uint64, error) {
+	base := 10
+	if strings.HasPrefix(s, "0x") {
+		base = 16
+		s = s[2:]
+	}
+	if s == "" || strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") || strings.Contains(s, "__") {
+		return 0, fmt.Errorf("invalid number")
+	}
+	return strconv.ParseUint(strings.ReplaceAll(s, "_", ""), base, bitSize)
+}
+
+// parseUint32 parses an unsigned 32 bit literal, e.g. an index.
+func parseUint32(s string) (uint32, error) {
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("invalid index %s", s)
+	}
+	v, err := parseNat(s, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid index %s", s)
+	}
+	return uint32(v), nil
+}
+
+// parseInt parses an integer literal of `bitSize` bits, which may be written
+// signed or unsigned, and returns its two's complement value.
+func parseInt(s string, bitSize int) (int64, error) {
+	neg, mag := splitSign(s)
+	v, err := parseNat(mag, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid i%d literal %s", bitSize, s)
+	}
+	if neg {
+		if v > 1<<(bitSize-1) {
+			return 0, fmt.Errorf("i%d literal out of range %s", bitSize, s)
+		}
+		return -int64(v), nil
+	}
+	if bitSize == 32 {
+		return int64(int32(uint32(v))), nil
+	}
+	return int64(v), nil
+}
+
+// parseFloat parses a float literal of `bitSize` bits and returns its bits.
+func parseFloat(s string, bitSize int) (uint64, error) {
+	var (
+		expBits  uint
+		mantBits uint
+	)
+	if bitSize == 32 {
+		expBits, mantBits = 8, 23
+	} else {
+		expBits, mantBits = 11, 52
+	}
+	neg, mag := splitSign(s)
+	var sign uint64
+	if neg {
+		sign = 1 << uint(bitSize-1)
+	}
+	inf := (uint64(1)<<expBits - 1) << mantBits
+
+	switch {
+	case mag == "inf":
+		return sign | inf, nil
+	case mag == "nan":
+		return sign | inf | 1<<(mantBits-1), nil
+	case strings.HasPrefix(mag, "nan:0x"):
+		payload, err := parseNat(mag[4:], 64)
+		if err != nil || payload == 0 || payload >= 1<<mantBits {
+			return 0, fmt.Errorf("invalid f%d literal %s", bitSize, s)
+		}
+		return sign | inf | payload, nil
+	}
+
+	if mag == "" || strings.HasPrefix(mag, "_") || strings.HasSuffix(mag, "_") || strings.Contains(mag, "__") {
+		return 0, fmt.Errorf("invalid f%d literal %s", bitSize, s)
+	}
+	lit := strings.ReplaceAll(mag, "_", "")
+	if strings.HasPrefix(lit, "0x") && !strings.ContainsAny(lit, "pP") {
+		lit += "p0"
+	}
+	for _, c := range lit {
+		if !strings.ContainsRune("0123456789abcdefABCDEFxpP.+-", c) {
+			return 0, fmt.Errorf("invalid f%d literal %s", bitSize, s)
+		}
+	}
+	v, err := strconv.ParseFloat(lit, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid f%d literal %s", bitSize, s)
+	}
+	if bitSize == 32 {
+		return sign | uint64(math.Float32bits(float32(v))), nil
+	}
+	return sign | math.Float64bits(v), nil
+}
+
+// floatBytes returns the little endian bytes of a float literal, the
+// immediate of `f32.const` and `f64.const`.
+func floatBytes(s string, bitSize int) ([]byte, error) {
+	bits, err := parseFloat(s, bitSize)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bitSize/8)
+	if bitSize == 32 {
+		binary.LittleEndian.PutUint32(buf, uint32(bits))
+	} else {
+		binary.LittleEndian.PutUint64(buf, bits)
+	}
+	return buf, nil
+}
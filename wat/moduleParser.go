@@ -0,0 +1,1020 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+)
+
+// ParseOptions configures the parser.
+type ParseOptions struct {
+	Names bool // add a `name` section for the identifiers of functions and locals.
+}
+
+// Wat2Wasm converts a WAT module to wasm binary.
+func Wat2Wasm(text string, opts ParseOptions) ([]byte, error) {
+	module, err := Wat2Module(text, opts)
+	if err != nil {
+		return nil, err
+	}
+	return json2wasm.Module2Wasm(module)
+}
+
+// Wat2Module parses a WAT module. The text is either a `(module ...)` or the
+// sequence of its fields. `(module binary ...)` and `(module quote ...)` are
+// accepted as in spec scripts.
+func Wat2Module(text string, opts ParseOptions) (*tool.Module, error) {
+	sexprs, err := readSexprs(text)
+	if err != nil {
+		return nil, fmt.Errorf("wat 2 module error: %w", err)
+	}
+	if len(sexprs) == 1 && sexprs[0].isKeyword("module") {
+		return parseModule(sexprs[0], opts)
+	}
+	return parseModule(&sexpr{isList: true, list: append([]*sexpr{{atom: "module"}}, sexprs...), line: 1, col: 1}, opts)
+}
+
+// parseModule parses a `(module ...)` node.
+func parseModule(s *sexpr, opts ParseOptions) (*tool.Module, error) {
+	fields := s.list[1:]
+	if len(fields) > 0 && isID(fields[0]) {
+		fields = fields[1:]
+	}
+
+	if len(fields) > 0 && (fields[0].atom == "binary" || fields[0].atom == "quote") {
+		var buf []byte
+		for _, str := range fields[1:] {
+			if !str.isStr {
+				return nil, fmt.Errorf("wat 2 module error: %s: expected a string", str.pos())
+			}
+			buf = append(buf, str.str...)
+		}
+		if fields[0].atom == "quote" {
+			return Wat2Module(string(buf), opts)
+		}
+		module, err := wasm2json.Wasm2Module(buf)
+		if err != nil {
+			return nil, fmt.Errorf("wat 2 module error: %w", err)
+		}
+		return module, nil
+	}
+
+	p := newModuleParser(opts)
+	if err := p.declare(fields); err != nil {
+		return nil, fmt.Errorf("wat 2 module error: %w", err)
+	}
+	if err := p.define(fields); err != nil {
+		return nil, fmt.Errorf("wat 2 module error: %w", err)
+	}
+	module, err := p.build()
+	if err != nil {
+		return nil, fmt.Errorf("wat 2 module error: %w", err)
+	}
+	return module, nil
+}
+
+type customDef struct {
+	sec       *tool.CustomSec
+	placement tool.Placement
+}
+
+// moduleParser builds a module from its fields in two passes: declare
+// assigns the indices of identifiers and parses the explicit types, define
+// parses everything else.
+type moduleParser struct {
+	opts ParseOptions
+
+	types     []tool.TypeEntry
+	typeIDs   map[string]uint32
+	funcIDs   map[string]uint32
+	tableIDs  map[string]uint32
+	memIDs    map[string]uint32
+	globalIDs map[string]uint32
+
+	// number of imports per index space, and of imports and definitions
+	// seen so far by define.
+	numImports map[string]uint32
+	importSeen map[string]uint32
+	defineSeen map[string]uint32
+
+	imports []tool.ImportEntry
+	funcs   []uint32
+	tables  []tool.Table
+	mems    []tool.MemLimits
+	globals []tool.GlobalEntry
+	exports []tool.ExportEntry
+	start   *tool.StartSec
+	elems   []tool.ElementEntry
+	codes   []tool.CodeBody
+	datas   []tool.DataSegment
+	customs []customDef
+
+	funcNames  map[uint32]string
+	localNames map[uint32]map[uint32]string
+}
+
+func newModuleParser(opts ParseOptions) *moduleParser {
+	return &moduleParser{
+		opts:       opts,
+		typeIDs:    map[string]uint32{},
+		funcIDs:    map[string]uint32{},
+		tableIDs:   map[string]uint32{},
+		memIDs:     map[string]uint32{},
+		globalIDs:  map[string]uint32{},
+		importSeen: map[string]uint32{},
+		defineSeen: map[string]uint32{},
+		funcNames:  map[uint32]string{},
+		localNames: map[uint32]map[uint32]string{},
+	}
+}
+
+func isID(s *sexpr) bool {
+	return !s.isList && !s.isStr && strings.HasPrefix(s.atom, "$")
+}
+
+// isImported reports whether a func, table, memory or global field carries an
+// inline import.
+func isImported(field *sexpr) bool {
+	for _, item := range field.list[1:] {
+		if item.isKeyword("import") {
+			return true
+		}
+		if !isID(item) && !item.isKeyword("export") {
+			return false
+		}
+	}
+	return false
+}
+
+// spaceOf returns the index space a field defines, or "" for other fields.
+func spaceOf(field *sexpr) (string, bool) {
+	switch field.head() {
+	case "func", "table", "memory", "global":
+		return field.head(), isImported(field)
+	case "import":
+		if len(field.list) > 3 && field.list[3].isList {
+			return field.list[3].head(), true
+		}
+	}
+	return "", false
+}
+
+func (p *moduleParser) ids(space string) map[string]uint32 {
+	switch space {
+	case "func":
+		return p.funcIDs
+	case "table":
+		return p.tableIDs
+	case "memory":
+		return p.memIDs
+	case "global":
+		return p.globalIDs
+	}
+	return p.typeIDs
+}
+
+// declare assigns the indices of the identifiers. Imports come first in their
+// index space, in the order they appear.
+func (p *moduleParser) declare(fields []*sexpr) error {
+	numImports := map[string]uint32{}
+	for _, field := range fields {
+		if !field.isList {
+			return fmt.Errorf("%s: unexpected %s", field.pos(), field)
+		}
+		if space, imported := spaceOf(field); imported {
+			numImports[space]++
+		}
+	}
+
+	imported := map[string]uint32{}
+	defined := map[string]uint32{}
+	for _, field := range fields {
+		if field.head() == "type" {
+			if err := p.declareType(field); err != nil {
+				return err
+			}
+			continue
+		}
+
+		space, isImport := spaceOf(field)
+		if space == "" {
+			continue
+		}
+		var index uint32
+		if isImport {
+			index = imported[space]
+			imported[space]++
+		} else {
+			index = numImports[space] + defined[space]
+			defined[space]++
+		}
+
+		// the identifier follows the keyword, or the `func` of an import.
+		items := field.list[1:]
+		if field.head() == "import" {
+			items = field.list[3].list[1:]
+		}
+		if len(items) > 0 && isID(items[0]) {
+			ids := p.ids(space)
+			if _, exist := ids[items[0].atom]; exist {
+				return fmt.Errorf("%s: duplicate %s %s", items[0].pos(), space, items[0].atom)
+			}
+			ids[items[0].atom] = index
+			if space == "func" {
+				p.funcNames[index] = items[0].atom[1:]
+			}
+		}
+	}
+	p.numImports = numImports
+	return nil
+}
+
+func (p *moduleParser) declareType(field *sexpr) error {
+	items := field.list[1:]
+	if len(items) > 0 && isID(items[0]) {
+		if _, exist := p.typeIDs[items[0].atom]; exist {
+			return fmt.Errorf("%s: duplicate type %s", items[0].pos(), items[0].atom)
+		}
+		p.typeIDs[items[0].atom] = uint32(len(p.types))
+		items = items[1:]
+	}
+	if len(items) != 1 || !items[0].isKeyword("func") {
+		return fmt.Errorf("%s: malformed type", field.pos())
+	}
+	entry, _, rest, err := p.signature(items[0].list[1:])
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%s: unexpected %s", rest[0].pos(), rest[0])
+	}
+	p.types = append(p.types, entry)
+	return nil
+}
+
+// define parses the fields in order.
+func (p *moduleParser) define(fields []*sexpr) error {
+	for _, field := range fields {
+		var err error
+		switch field.head() {
+		case "type":
+		case "import":
+			err = p.importField(field)
+		case "func":
+			err = p.funcField(field)
+		case "table":
+			err = p.tableField(field)
+		case "memory":
+			err = p.memoryField(field)
+		case "global":
+			err = p.globalField(field)
+		case "export":
+			err = p.exportField(field)
+		case "start":
+			err = p.startField(field)
+		case "elem":
+			err = p.elemField(field)
+		case "data":
+			err = p.dataField(field)
+		case "@custom":
+			err = p.customField(field)
+		default:
+			// unknown annotations are ignored.
+			if !strings.HasPrefix(field.head(), "@") {
+				err = fmt.Errorf("%s: unknown module field %s", field.pos(), field)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// build assembles the sections of the module.
+func (p *moduleParser) build() (*tool.Module, error) {
+	m := tool.NewModule()
+	if len(p.types) > 0 {
+		m.AddSection(&tool.TypeSec{Name: "type", Entries: p.types})
+	}
+	if len(p.imports) > 0 {
+		m.AddSection(&tool.ImportSec{Name: "import", Entries: p.imports})
+	}
+	if len(p.funcs) > 0 {
+		m.AddSection(&tool.FuncSec{Name: "function", Entries: p.funcs})
+	}
+	if len(p.tables) > 0 {
+		m.AddSection(&tool.TableSec{Name: "table", Entries: p.tables})
+	}
+	if len(p.mems) > 0 {
+		m.AddSection(&tool.MemSec{Name: "memory", Entries: p.mems})
+	}
+	if len(p.globals) > 0 {
+		m.AddSection(&tool.GlobalSec{Name: "global", Entries: p.globals})
+	}
+	if len(p.exports) > 0 {
+		m.AddSection(&tool.ExportSec{Name: "export", Entries: p.exports})
+	}
+	if p.start != nil {
+		m.AddSection(p.start)
+	}
+	if len(p.elems) > 0 {
+		m.AddSection(&tool.ElementSec{Name: "element", Entries: p.elems})
+	}
+	if len(p.codes) > 0 {
+		m.AddSection(&tool.CodeSec{Name: "code", Entries: p.codes})
+	}
+	if len(p.datas) > 0 {
+		m.AddSection(&tool.DataSec{Name: "data", Entries: p.datas})
+	}
+	for _, custom := range p.customs {
+		if err := m.InsertCustom(custom.sec, custom.placement); err != nil {
+			return nil, err
+		}
+	}
+	if p.opts.Names {
+		if sec := nameSection(p.funcNames, p.localNames); sec != nil {
+			m.AddSection(sec)
+		}
+	}
+	return m, nil
+}
+
+// internType returns the index of a type equal to `entry`, adding it to the
+// type section if there is none.
+func (p *moduleParser) internType(entry tool.TypeEntry) uint32 {
+	for i, typ := range p.types {
+		if sameTypes(typ.Params, entry.Params) && sameTypes(typ.Returns, entry.Returns) {
+			return uint32(i)
+		}
+	}
+	p.types = append(p.types, entry)
+	return uint32(len(p.types) - 1)
+}
+
+func sameTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func valueType(s *sexpr) (string, error) {
+	switch s.atom {
+	case "i32", "i64", "f32", "f64":
+		return s.atom, nil
+	}
+	return "", fmt.Errorf("%s: unknown value type %s", s.pos(), s)
+}
+
+// signature parses `(param ...)* (result ...)*` and returns the identifiers
+// of the params and the remaining items.
+func (p *moduleParser) signature(items []*sexpr) (tool.TypeEntry, []string, []*sexpr, error) {
+	entry := tool.TypeEntry{Form: "func", Params: []string{}, Returns: []string{}}
+	var ids []string
+	for len(items) > 0 && items[0].isKeyword("param") {
+		list := items[0].list[1:]
+		if len(list) > 0 && isID(list[0]) {
+			if len(list) != 2 {
+				return entry, nil, nil, fmt.Errorf("%s: malformed param", items[0].pos())
+			}
+			typ, err := valueType(list[1])
+			if err != nil {
+				return entry, nil, nil, err
+			}
+			entry.Params = append(entry.Params, typ)
+			ids = append(ids, list[0].atom)
+		} else {
+			for _, item := range list {
+				typ, err := valueType(item)
+				if err != nil {
+					return entry, nil, nil, err
+				}
+				entry.Params = append(entry.Params, typ)
+				ids = append(ids, "")
+			}
+		}
+		items = items[1:]
+	}
+	for len(items) > 0 && items[0].isKeyword("result") {
+		for _, item := range items[0].list[1:] {
+			typ, err := valueType(item)
+			if err != nil {
+				return entry, nil, nil, err
+			}
+			entry.Returns = append(entry.Returns, typ)
+		}
+		items = items[1:]
+	}
+	return entry, ids, items, nil
+}
+
+// typeUse parses `(type x)? (param ...)* (result ...)*` and returns the type
+// index, the identifiers of the params and the remaining items.
+func (p *moduleParser) typeUse(items []*sexpr) (uint32, []string, []*sexpr, error) {
+	explicit := len(items) > 0 && items[0].isKeyword("type")
+	var index uint32
+	if explicit {
+		if len(items[0].list) != 2 {
+			return 0, nil, nil, fmt.Errorf("%s: malformed type use", items[0].pos())
+		}
+		var err error
+		if index, err = p.ref(items[0].list[1], "type"); err != nil {
+			return 0, nil, nil, err
+		}
+		if index >= uint32(len(p.types)) {
+			return 0, nil, nil, fmt.Errorf("%s: unknown type %d", items[0].pos(), index)
+		}
+		items = items[1:]
+	}
+
+	pos := ""
+	if len(items) > 0 {
+		pos = items[0].pos()
+	}
+	entry, ids, rest, err := p.signature(items)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if !explicit {
+		return p.internType(entry), ids, rest, nil
+	}
+
+	typ := p.types[index]
+	if len(rest) != len(items) {
+		if !sameTypes(typ.Params, entry.Params) || !sameTypes(typ.Returns, entry.Returns) {
+			return 0, nil, nil, fmt.Errorf("%s: inline function type does not match type %d", pos, index)
+		}
+	} else {
+		ids = make([]string, len(typ.Params))
+	}
+	return index, ids, rest, nil
+}
+
+// ref resolves an index or an identifier in an index space.
+func (p *moduleParser) ref(s *sexpr, space string) (uint32, error) {
+	if isID(s) {
+		index, exist := p.ids(space)[s.atom]
+		if !exist {
+			return 0, fmt.Errorf("%s: unknown %s %s", s.pos(), space, s.atom)
+		}
+		return index, nil
+	}
+	if s.isList || s.isStr {
+		return 0, fmt.Errorf("%s: expected a %s index, got %s", s.pos(), space, s)
+	}
+	index, err := parseUint32(s.atom)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", s.pos(), err)
+	}
+	return index, nil
+}
+
+// header parses the identifier, inline exports and inline import at the start
+// of a func, table, memory or global field. `index` is the index the field
+// defines.
+func (p *moduleParser) header(field *sexpr, kind string, index uint32) (*tool.ImportEntry, []*sexpr, error) {
+	items := field.list[1:]
+	if len(items) > 0 && isID(items[0]) {
+		items = items[1:]
+	}
+	for len(items) > 0 && items[0].isKeyword("export") {
+		if len(items[0].list) != 2 {
+			return nil, nil, fmt.Errorf("%s: malformed export", items[0].pos())
+		}
+		name, err := text(items[0].list[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		p.exports = append(p.exports, tool.ExportEntry{FieldStr: name, Kind: kind, Index: index})
+		items = items[1:]
+	}
+	if len(items) > 0 && items[0].isKeyword("import") {
+		entry, err := importNames(items[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		entry.Kind = kind
+		return &entry, items[1:], nil
+	}
+	return nil, items, nil
+}
+
+func importNames(s *sexpr) (tool.ImportEntry, error) {
+	if len(s.list) < 3 {
+		return tool.ImportEntry{}, fmt.Errorf("%s: malformed import", s.pos())
+	}
+	moduleStr, err := text(s.list[1])
+	if err != nil {
+		return tool.ImportEntry{}, err
+	}
+	fieldStr, err := text(s.list[2])
+	if err != nil {
+		return tool.ImportEntry{}, err
+	}
+	return tool.ImportEntry{ModuleStr: moduleStr, FieldStr: fieldStr}, nil
+}
+
+// index returns the index the next func, table, memory or global field
+// defines.
+func (p *moduleParser) index(space string, imported bool) uint32 {
+	if imported {
+		index := p.importSeen[space]
+		p.importSeen[space]++
+		return index
+	}
+	index := p.numImports[space] + p.defineSeen[space]
+	p.defineSeen[space]++
+	return index
+}
+
+// EXTERNAL_KINDS maps WAT keywords to the kinds of imports and exports.
+var EXTERNAL_KINDS = map[string]string{
+	"func":   "function",
+	"table":  "table",
+	"memory": "memory",
+	"global": "global",
+}
+
+func (p *moduleParser) importField(field *sexpr) error {
+	if len(field.list) != 4 || !field.list[3].isList {
+		return fmt.Errorf("%s: malformed import", field.pos())
+	}
+	entry, err := importNames(field)
+	if err != nil {
+		return err
+	}
+	desc := field.list[3]
+	space := desc.head()
+	kind, exist := EXTERNAL_KINDS[space]
+	if !exist {
+		return fmt.Errorf("%s: unknown import kind %s", desc.pos(), space)
+	}
+	entry.Kind = kind
+	p.index(space, true)
+	items := desc.list[1:]
+	if len(items) > 0 && isID(items[0]) {
+		items = items[1:]
+	}
+	return p.importDesc(entry, space, items)
+}
+
+// importDesc completes an import with the type in `items` and adds it.
+func (p *moduleParser) importDesc(entry tool.ImportEntry, space string, items []*sexpr) error {
+	var (
+		rest []*sexpr
+		err  error
+	)
+	switch space {
+	case "func":
+		var typeIndex uint32
+		if typeIndex, _, rest, err = p.typeUse(items); err == nil {
+			entry.Type = typeIndex
+		}
+	case "table":
+		var table tool.Table
+		if table, rest, err = p.tableType(items); err == nil {
+			entry.Type = table
+		}
+	case "memory":
+		var limits tool.MemLimits
+		if limits, rest, err = p.limits(items); err == nil {
+			entry.Type = limits
+		}
+	case "global":
+		var global tool.Global
+		if global, rest, err = p.globalType(items); err == nil {
+			entry.Type = global
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%s: unexpected %s", rest[0].pos(), rest[0])
+	}
+	p.imports = append(p.imports, entry)
+	return nil
+}
+
+func (p *moduleParser) funcField(field *sexpr) error {
+	index := p.index("func", isImported(field))
+	entry, items, err := p.header(field, "function", index)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return p.importDesc(*entry, "func", items)
+	}
+
+	typeIndex, params, items, err := p.typeUse(items)
+	if err != nil {
+		return err
+	}
+	fp := newFuncParser(p)
+	for i, id := range params {
+		if err := fp.addLocal(id, uint32(i)); err != nil {
+			return err
+		}
+	}
+
+	body := tool.CodeBody{Locals: []tool.LocalEntry{}}
+	local := uint32(len(params))
+	for len(items) > 0 && items[0].isKeyword("local") {
+		list := items[0].list[1:]
+		var types []string
+		if len(list) > 0 && isID(list[0]) {
+			if len(list) != 2 {
+				return fmt.Errorf("%s: malformed local", items[0].pos())
+			}
+			if err := fp.addLocal(list[0].atom, local); err != nil {
+				return err
+			}
+			list = list[1:]
+		}
+		for _, item := range list {
+			typ, err := valueType(item)
+			if err != nil {
+				return err
+			}
+			types = append(types, typ)
+		}
+		for _, typ := range types {
+			if n := len(body.Locals); n > 0 && body.Locals[n-1].Type == typ {
+				body.Locals[n-1].Count++
+			} else {
+				body.Locals = append(body.Locals, tool.LocalEntry{Count: 1, Type: typ})
+			}
+			local++
+		}
+		items = items[1:]
+	}
+
+	if err := fp.instrs(items); err != nil {
+		return err
+	}
+	body.Code = append(fp.code, tool.NewOP("end", nil))
+	if len(fp.localNames) > 0 {
+		p.localNames[index] = fp.localNames
+	}
+
+	p.funcs = append(p.funcs, typeIndex)
+	p.codes = append(p.codes, body)
+	return nil
+}
+
+// limits parses `min max?`.
+func (p *moduleParser) limits(items []*sexpr) (tool.MemLimits, []*sexpr, error) {
+	limits := tool.MemLimits{}
+	if len(items) == 0 || items[0].isList || items[0].isStr {
+		return limits, nil, fmt.Errorf("missing limits")
+	}
+	min, err := parseUint32(items[0].atom)
+	if err != nil {
+		return limits, nil, fmt.Errorf("%s: %w", items[0].pos(), err)
+	}
+	limits.Intial = min
+	items = items[1:]
+	if len(items) > 0 && !items[0].isList && !items[0].isStr && items[0].atom != "funcref" && items[0].atom != "anyfunc" {
+		max, err := parseUint32(items[0].atom)
+		if err != nil {
+			return limits, nil, fmt.Errorf("%s: %w", items[0].pos(), err)
+		}
+		limits.Flags = 1
+		limits.Maximum = max
+		items = items[1:]
+	}
+	return limits, items, nil
+}
+
+func elemType(s *sexpr) bool {
+	return s.atom == "funcref" || s.atom == "anyfunc"
+}
+
+// tableType parses `limits funcref`.
+func (p *moduleParser) tableType(items []*sexpr) (tool.Table, []*sexpr, error) {
+	limits, items, err := p.limits(items)
+	if err != nil {
+		return tool.Table{}, nil, err
+	}
+	if len(items) == 0 || !elemType(items[0]) {
+		return tool.Table{}, nil, fmt.Errorf("missing table element type")
+	}
+	return tool.Table{ElementType: "funcref", Limits: limits}, items[1:], nil
+}
+
+// globalType parses `t` or `(mut t)`.
+func (p *moduleParser) globalType(items []*sexpr) (tool.Global, []*sexpr, error) {
+	if len(items) == 0 {
+		return tool.Global{}, nil, fmt.Errorf("missing global type")
+	}
+	if items[0].isKeyword("mut") {
+		if len(items[0].list) != 2 {
+			return tool.Global{}, nil, fmt.Errorf("%s: malformed global type", items[0].pos())
+		}
+		typ, err := valueType(items[0].list[1])
+		if err != nil {
+			return tool.Global{}, nil, err
+		}
+		return tool.Global{ContentType: typ, Mutability: 1}, items[1:], nil
+	}
+	typ, err := valueType(items[0])
+	if err != nil {
+		return tool.Global{}, nil, err
+	}
+	return tool.Global{ContentType: typ}, items[1:], nil
+}
+
+func (p *moduleParser) tableField(field *sexpr) error {
+	index := p.index("table", isImported(field))
+	entry, items, err := p.header(field, "table", index)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return p.importDesc(*entry, "table", items)
+	}
+
+	// `funcref (elem ...)` declares a table sized to its inline segment.
+	if len(items) == 2 && elemType(items[0]) && items[1].isKeyword("elem") {
+		elements, err := p.funcRefs(items[1].list[1:])
+		if err != nil {
+			return err
+		}
+		size := uint32(len(elements))
+		p.tables = append(p.tables, tool.Table{
+			ElementType: "funcref",
+			Limits:      tool.MemLimits{Flags: 1, Intial: size, Maximum: size},
+		})
+		p.elems = append(p.elems, tool.ElementEntry{
+			Index:    index,
+			Offset:   tool.NewOP("i32.const", int32(0)),
+			Elements: elements,
+		})
+		return nil
+	}
+
+	table, rest, err := p.tableType(items)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.pos(), err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%s: unexpected %s", rest[0].pos(), rest[0])
+	}
+	p.tables = append(p.tables, table)
+	return nil
+}
+
+func (p *moduleParser) memoryField(field *sexpr) error {
+	index := p.index("memory", isImported(field))
+	entry, items, err := p.header(field, "memory", index)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return p.importDesc(*entry, "memory", items)
+	}
+
+	// `(data ...)` declares a memory sized to its inline segment.
+	if len(items) == 1 && items[0].isKeyword("data") {
+		data, err := dataString(items[0].list[1:])
+		if err != nil {
+			return err
+		}
+		pages := uint32((len(data) + 65535) / 65536)
+		p.mems = append(p.mems, tool.MemLimits{Flags: 1, Intial: pages, Maximum: pages})
+		p.datas = append(p.datas, tool.DataSegment{
+			Index:  index,
+			Offset: tool.NewOP("i32.const", int32(0)),
+			Data:   data,
+		})
+		return nil
+	}
+
+	limits, rest, err := p.limits(items)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.pos(), err)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("%s: unexpected %s", rest[0].pos(), rest[0])
+	}
+	p.mems = append(p.mems, limits)
+	return nil
+}
+
+func (p *moduleParser) globalField(field *sexpr) error {
+	index := p.index("global", isImported(field))
+	entry, items, err := p.header(field, "global", index)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		return p.importDesc(*entry, "global", items)
+	}
+
+	global, items, err := p.globalType(items)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field.pos(), err)
+	}
+	init, err := p.constExpr(field, items)
+	if err != nil {
+		return err
+	}
+	p.globals = append(p.globals, tool.GlobalEntry{Type: global, Init: init})
+	return nil
+}
+
+// constExpr parses an initializer expression, which is a single instruction.
+func (p *moduleParser) constExpr(field *sexpr, items []*sexpr) (tool.OP, error) {
+	fp := newFuncParser(p)
+	if err := fp.instrs(items); err != nil {
+		return tool.OP{}, err
+	}
+	if len(fp.code) != 1 {
+		return tool.OP{}, fmt.Errorf("%s: constant expression must be a single instruction", field.pos())
+	}
+	return fp.code[0], nil
+}
+
+// offset parses `(offset instr*)` or a folded instruction.
+func (p *moduleParser) offset(field *sexpr, items []*sexpr) (tool.OP, []*sexpr, error) {
+	if len(items) == 0 || !items[0].isList {
+		return tool.OP{}, nil, fmt.Errorf("%s: missing offset", field.pos())
+	}
+	if items[0].isKeyword("offset") {
+		op, err := p.constExpr(items[0], items[0].list[1:])
+		return op, items[1:], err
+	}
+	op, err := p.constExpr(items[0], items[:1])
+	return op, items[1:], err
+}
+
+func (p *moduleParser) funcRefs(items []*sexpr) ([]uint32, error) {
+	elements := []uint32{}
+	for _, item := range items {
+		index, err := p.ref(item, "func")
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, index)
+	}
+	return elements, nil
+}
+
+func dataString(items []*sexpr) ([]byte, error) {
+	data := []byte{}
+	for _, item := range items {
+		if !item.isStr {
+			return nil, fmt.Errorf("%s: expected a string, got %s", item.pos(), item)
+		}
+		data = append(data, item.str...)
+	}
+	return data, nil
+}
+
+func (p *moduleParser) exportField(field *sexpr) error {
+	if len(field.list) != 3 || !field.list[2].isList || len(field.list[2].list) != 2 {
+		return fmt.Errorf("%s: malformed export", field.pos())
+	}
+	name, err := text(field.list[1])
+	if err != nil {
+		return err
+	}
+	desc := field.list[2]
+	kind, exist := EXTERNAL_KINDS[desc.head()]
+	if !exist {
+		return fmt.Errorf("%s: unknown export kind %s", desc.pos(), desc.head())
+	}
+	index, err := p.ref(desc.list[1], desc.head())
+	if err != nil {
+		return err
+	}
+	p.exports = append(p.exports, tool.ExportEntry{FieldStr: name, Kind: kind, Index: index})
+	return nil
+}
+
+func (p *moduleParser) startField(field *sexpr) error {
+	if len(field.list) != 2 {
+		return fmt.Errorf("%s: malformed start", field.pos())
+	}
+	if p.start != nil {
+		return fmt.Errorf("%s: multiple start functions", field.pos())
+	}
+	index, err := p.ref(field.list[1], "func")
+	if err != nil {
+		return err
+	}
+	p.start = &tool.StartSec{Name: "start", Index: index}
+	return nil
+}
+
+// segmentTarget parses the table or memory of an active segment, written
+// `(table x)`, `(memory x)` or as a bare index.
+func (p *moduleParser) segmentTarget(items []*sexpr, space string) (uint32, []*sexpr, error) {
+	if len(items) > 0 && isID(items[0]) {
+		items = items[1:]
+	}
+	if len(items) == 0 {
+		return 0, items, nil
+	}
+	if items[0].isKeyword(space) && len(items[0].list) == 2 {
+		index, err := p.ref(items[0].list[1], space)
+		return index, items[1:], err
+	}
+	if !items[0].isList && !items[0].isStr && items[0].atom != "func" {
+		index, err := p.ref(items[0], space)
+		return index, items[1:], err
+	}
+	return 0, items, nil
+}
+
+func (p *moduleParser) elemField(field *sexpr) error {
+	index, items, err := p.segmentTarget(field.list[1:], "table")
+	if err != nil {
+		return err
+	}
+	offset, items, err := p.offset(field, items)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 && items[0].atom == "func" {
+		items = items[1:]
+	}
+	elements, err := p.funcRefs(items)
+	if err != nil {
+		return err
+	}
+	p.elems = append(p.elems, tool.ElementEntry{Index: index, Offset: offset, Elements: elements})
+	return nil
+}
+
+func (p *moduleParser) dataField(field *sexpr) error {
+	index, items, err := p.segmentTarget(field.list[1:], "memory")
+	if err != nil {
+		return err
+	}
+	offset, items, err := p.offset(field, items)
+	if err != nil {
+		return err
+	}
+	data, err := dataString(items)
+	if err != nil {
+		return err
+	}
+	p.datas = append(p.datas, tool.DataSegment{Index: index, Offset: offset, Data: data})
+	return nil
+}
+
+// customField parses `(@custom "name" (before|after section)? "payload"*)`.
+// Sections are placed after the last known section by default.
+func (p *moduleParser) customField(field *sexpr) error {
+	if len(field.list) < 2 {
+		return fmt.Errorf("%s: malformed custom annotation", field.pos())
+	}
+	name, err := text(field.list[1])
+	if err != nil {
+		return err
+	}
+	items := field.list[2:]
+	placement := tool.Placement{}
+	if len(items) > 0 && (items[0].isKeyword("before") || items[0].isKeyword("after")) {
+		place := items[0]
+		if len(place.list) != 2 {
+			return fmt.Errorf("%s: malformed custom placement", place.pos())
+		}
+		placement.Before = place.head() == "before"
+		switch anchor := place.list[1].atom; anchor {
+		case "first", "last":
+			if placement.Before != (anchor == "first") {
+				return fmt.Errorf("%s: invalid custom placement", place.pos())
+			}
+		default:
+			for secName, keyword := range WAT_SECTION_NAMES {
+				if keyword == anchor {
+					placement.Anchor = secName
+				}
+			}
+			if placement.Anchor == "" {
+				return fmt.Errorf("%s: unknown section %s", place.pos(), anchor)
+			}
+		}
+		items = items[1:]
+	}
+	payload, err := dataString(items)
+	if err != nil {
+		return err
+	}
+	p.customs = append(p.customs, customDef{
+		sec:       &tool.CustomSec{Name: "custom", SectionName: name, Custom: payload},
+		placement: placement,
+	})
+	return nil
+}
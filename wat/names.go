@@ -0,0 +1,165 @@
+package wat
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/tool"
+)
+
+// names holds the identifiers printed for functions and locals, taken from
+// the `name` custom section.
+type names struct {
+	funcs  map[uint32]string
+	locals map[uint32]map[uint32]string
+}
+
+func newNames(module *tool.Module) *names {
+	n := &names{
+		funcs:  map[uint32]string{},
+		locals: map[uint32]map[uint32]string{},
+	}
+	used := map[string]bool{}
+	for _, sec := range module.CustomSecs("name") {
+		custom, ok := sec.Custom.([]tool.CustomName)
+		if !ok {
+			continue
+		}
+		for _, cusName := range custom {
+			switch assocs := cusName.Names.(type) {
+			case []tool.NameAssoc:
+				for _, assoc := range assocs {
+					if _, exist := n.funcs[assoc.Index]; exist {
+						continue
+					}
+					if id := identifier(assoc.NameStr); id != "" {
+						n.funcs[assoc.Index] = unique(id, used)
+					}
+				}
+			case []tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌:
+				for _, fn := range assocs {
+					localUsed := map[string]bool{}
+					locals := map[uint32]string{}
+					for _, assoc := range fn.NameMap {
+						if id := identifier(assoc.NameStr); id != "" {
+							locals[assoc.Index] = unique(id, localUsed)
+						}
+					}
+					n.locals[fn.Index] = locals
+				}
+			}
+		}
+	}
+
+	// unnamed imported functions are named after their import, e.g. `$metering.usegas`.
+	if sec := module.ImportSec(); sec != nil {
+		index := uint32(0)
+		for _, entry := range sec.Entries {
+			if entry.Kind != "function" {
+				continue
+			}
+			if _, exist := n.funcs[index]; !exist {
+				n.funcs[index] = unique(identifier(entry.ModuleStr+"."+entry.FieldStr), used)
+			}
+			index++
+		}
+	}
+	return n
+}
+
+// funcRef returns the identifier of a function, or its index if it has none.
+func (n *names) funcRef(index uint32) string {
+	if id, exist := n.funcs[index]; exist {
+		return id
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
+
+// localRef returns the identifier of a local of function `fn`, or its index.
+func (n *names) localRef(fn, index uint32) string {
+	if id, exist := n.locals[fn][index]; exist {
+		return id
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
+
+// identifier turns a name into a WAT identifier, replacing the characters
+// that are not allowed in identifiers with `_`.
+func identifier(name string) string {
+	if name == "" {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('$')
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isIDChar(c) {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+func isIDChar(c byte) bool {
+	switch {
+	case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-./:<=>?@\\^_`|~", c) >= 0
+}
+
+// unique appends a numeric suffix to `id` until it is not in `used`.
+func unique(id string, used map[string]bool) string {
+	res := id
+	for i := 1; used[res]; i++ {
+		res = id + "." + strconv.Itoa(i)
+	}
+	used[res] = true
+	return res
+}
+
+// nameSection returns a `name` section for the names of functions and
+// locals, or nil if there are none.
+func nameSection(funcs map[uint32]string, locals map[uint32]map[uint32]string) *tool.CustomSec {
+	var custom []tool.CustomName
+	if len(funcs) > 0 {
+		assocs := make([]tool.NameAssoc, 0, len(funcs))
+		for _, index := range sortedKeys(funcs) {
+			assocs = append(assocs, tool.NameAssoc{Index: index, NameStr: funcs[index]})
+		}
+		custom = append(custom, tool.CustomName{Kind: "function", Names: assocs})
+	}
+	if len(locals) > 0 {
+		fns := make([]uint32, 0, len(locals))
+		for index := range locals {
+			fns = append(fns, index)
+		}
+		sort.Slice(fns, func(i, j int) bool { return fns[i] < fns[j] })
+
+		assocs := make([]tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌, 0, len(locals))
+		for _, fn := range fns {
+			assoc := tool.I𝚗𝚍𝚒𝚛𝚎𝚌𝚝N𝚊𝚖𝚎A𝚜𝚜𝚘𝚌{Index: fn}
+			for _, index := range sortedKeys(locals[fn]) {
+				assoc.NameMap = append(assoc.NameMap, tool.NameAssoc{Index: index, NameStr: locals[fn][index]})
+			}
+			assocs = append(assocs, assoc)
+		}
+		custom = append(custom, tool.CustomName{Kind: "local", Names: assocs})
+	}
+	if custom == nil {
+		return nil
+	}
+	return &tool.CustomSec{Name: "custom", SectionName: "name", Custom: custom}
+}
+
+func sortedKeys(m map[uint32]string) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,171 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+)
+
+// ScriptOptions configures RunScript.
+type ScriptOptions struct {
+	Validate func(*tool.Module) error // validates modules. `assert_invalid` commands are skipped when nil.
+}
+
+// ScriptResult is the outcome of a command of a `.wast` script.
+type ScriptResult struct {
+	Line    int
+	Command string
+	Skipped bool  // the command is not supported by the runner.
+	Err     error // why the command failed, nil if it passed.
+}
+
+// Passed reports whether the command ran and passed.
+func (r ScriptResult) Passed() bool {
+	return !r.Skipped && r.Err == nil
+}
+
+func (r ScriptResult) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("%d: %s: skipped", r.Line, r.Command)
+	case r.Err != nil:
+		return fmt.Sprintf("%d: %s: %v", r.Line, r.Command, r.Err)
+	}
+	return fmt.Sprintf("%d: %s: passed", r.Line, r.Command)
+}
+
+// SKIPPED_COMMANDS are the script commands that need to run the modules.
+var SKIPPED_COMMANDS = map[string]bool{
+	"register":              true,
+	"invoke":                true,
+	"get":                   true,
+	"assert_return":         true,
+	"assert_trap":           true,
+	"assert_exhaustion":     true,
+	"assert_unlinkable":     true,
+	"assert_uninstantiable": true,
+}
+
+// SPEC_MESSAGES maps the messages of spec assertions to the errors of this
+// module with the same cause, worded otherwise.
+var SPEC_MESSAGES = map[string][]string{
+	"malformed section id":                           {"unknown section id"},
+	"length out of bounds":                           {"unexpected end of section"},
+	"unexpected end of section or function":          {"END opcode expected"},
+	"unexpected content after last section":          {"duplicate", "out of order after"},
+	"unexpected token":                               {"missing immediate"},
+	"size minimum must not be greater than maximum":  {"must not be greater than maximum"},
+	"memory size must be at most 65536 pages (4GiB)": {"must be at most 65536"},
+	"alignment must not be larger than natural":      {"must not be larger than natural alignment"},
+	"integer too large":                              {"malformed limits flags"},
+}
+
+// matchMessage reports whether `err` is the error expected by an assertion
+// of message `msg`.
+func matchMessage(err error, msg string) bool {
+	if strings.Contains(err.Error(), msg) {
+		return true
+	}
+	for _, alias := range SPEC_MESSAGES[msg] {
+		if strings.Contains(err.Error(), alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunScript runs the `module`, `assert_malformed` and `assert_invalid`
+// commands of a spec `.wast` script and returns the result of every command.
+// A module passes if it parses, goes through the binary decoder and validates,
+// and an assertion if the module fails with the expected message, see
+// SPEC_MESSAGES. Panics of the decoder or the validator are not recovered.
+// The error is set if the script itself can not be read.
+func RunScript(text string, opts ScriptOptions) ([]ScriptResult, error) {
+	commands, err := readSexprs(text)
+	if err != nil {
+		return nil, fmt.Errorf("run script error: %w", err)
+	}
+
+	results := make([]ScriptResult, 0, len(commands))
+	for _, command := range commands {
+		res := ScriptResult{Line: command.line, Command: command.head()}
+		switch {
+		case command.isKeyword("module"):
+			_, res.Err = loadModule(command, opts)
+		case command.isKeyword("assert_malformed"):
+			module, msg, err := assertion(command)
+			if err != nil {
+				return nil, fmt.Errorf("run script error: %w", err)
+			}
+			if len(module.list) < 2 || module.list[1].atom != "binary" && module.list[1].atom != "quote" {
+				return nil, fmt.Errorf("run script error: %s: malformed module must be binary or quote", module.pos())
+			}
+			if _, err := decodeModule(module); err == nil {
+				res.Err = fmt.Errorf("module is not malformed, expected %q", msg)
+			} else if !matchMessage(err, msg) {
+				res.Err = fmt.Errorf("module is malformed, expected %q: %w", msg, err)
+			}
+		case command.isKeyword("assert_invalid"):
+			module, msg, err := assertion(command)
+			if err != nil {
+				return nil, fmt.Errorf("run script error: %w", err)
+			}
+			if opts.Validate == nil {
+				res.Skipped = true
+				break
+			}
+			decoded, err := decodeModule(module)
+			if err != nil {
+				res.Err = fmt.Errorf("module is malformed, expected %q: %w", msg, err)
+			} else if err := opts.Validate(decoded); err == nil {
+				res.Err = fmt.Errorf("module is valid, expected %q", msg)
+			} else if !matchMessage(err, msg) {
+				res.Err = fmt.Errorf("module is invalid, expected %q: %w", msg, err)
+			}
+		case SKIPPED_COMMANDS[command.head()]:
+			res.Skipped = true
+		default:
+			return nil, fmt.Errorf("run script error: %s: unknown command %s", command.pos(), command)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// assertion returns the module and the message of an assertion.
+func assertion(command *sexpr) (*sexpr, string, error) {
+	if len(command.list) != 3 || !command.list[1].isKeyword("module") || !command.list[2].isStr {
+		return nil, "", fmt.Errorf("%s: malformed %s", command.pos(), command.head())
+	}
+	return command.list[1], string(command.list[2].str), nil
+}
+
+// decodeModule parses a module command and runs the result through the
+// binary encoder and decoder.
+func decodeModule(s *sexpr) (*tool.Module, error) {
+	module, err := parseModule(s, ParseOptions{})
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json2wasm.Module2Wasm(module)
+	if err != nil {
+		return nil, err
+	}
+	return wasm2json.Wasm2Module(buf)
+}
+
+func loadModule(s *sexpr, opts ScriptOptions) (*tool.Module, error) {
+	module, err := decodeModule(s)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Validate != nil {
+		if err := opts.Validate(module); err != nil {
+			return nil, err
+		}
+	}
+	return module, nil
+}
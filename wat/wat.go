@@ -0,0 +1,394 @@
+// Package wat prints modules in the WebAssembly text format.
+package wat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/meshplus/go-wasm-metering/json2wasm"
+	"github.com/meshplus/go-wasm-metering/tool"
+	"github.com/meshplus/go-wasm-metering/wasm2json"
+)
+
+// Options configures the printer.
+type Options struct {
+	Folded bool // print instructions as folded S-expressions instead of one per line.
+}
+
+// WAT_SECTION_NAMES maps section names to the keywords used in the placement
+// of custom annotations.
+var WAT_SECTION_NAMES = map[string]string{
+	"type":       "type",
+	"import":     "import",
+	"function":   "func",
+	"table":      "table",
+	"memory":     "memory",
+	"global":     "global",
+	"export":     "export",
+	"start":      "start",
+	"element":    "elem",
+	"data count": "datacount",
+	"code":       "code",
+	"data":       "data",
+}
+
+type printer struct {
+	module  *tool.Module
+	opts    Options
+	names   *names
+	sb      strings.Builder
+	depth   int
+	fn      uint32 // function being printed.
+	results int    // result count of the function being printed.
+}
+
+func newPrinter(module *tool.Module, opts Options) *printer {
+	return &printer{
+		module: module,
+		opts:   opts,
+		names:  newNames(module),
+	}
+}
+
+// Wasm2Wat converts wasm binary to WAT.
+func Wasm2Wat(buf []byte, opts Options) (string, error) {
+	module, err := wasm2json.Wasm2Module(buf)
+	if err != nil {
+		return "", fmt.Errorf("wasm 2 wat error: %w", err)
+	}
+	return Module2Wat(module, opts)
+}
+
+// Module2Wat prints a module as WAT. Names of functions and locals are taken
+// from the `name` custom section, other custom sections are printed as
+// `@custom` annotations.
+func Module2Wat(module *tool.Module, opts Options) (string, error) {
+	p := newPrinter(module, opts)
+	if err := p.printModule(); err != nil {
+		return "", fmt.Errorf("module 2 wat error: %w", err)
+	}
+	return p.sb.String(), nil
+}
+
+// Func2Wat prints the function at `index` of the function index space, so a
+// function can be compared before and after metering.
+func Func2Wat(module *tool.Module, index uint32, opts Options) (string, error) {
+	p := newPrinter(module, opts)
+	imported := uint32(module.ImportedFuncs())
+	if index < imported {
+		return "", fmt.Errorf("func 2 wat error: function %d is imported", index)
+	}
+	if err := p.printFunc(index); err != nil {
+		return "", fmt.Errorf("func 2 wat error: %w", err)
+	}
+	return p.sb.String(), nil
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	p.sb.WriteString(strings.Repeat("  ", p.depth))
+	fmt.Fprintf(&p.sb, format, args...)
+	p.sb.WriteByte('\n')
+}
+
+func (p *printer) printModule() error {
+	p.line("(module")
+	p.depth++
+
+	if sec := p.module.TypeSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			p.line("(type (;%d;) (func%s))", i, signature(entry))
+		}
+	}
+
+	if sec := p.module.ImportSec(); sec != nil {
+		funcIndex := uint32(0)
+		for _, entry := range sec.Entries {
+			var desc string
+			switch typ := entry.Type.(type) {
+			case uint32:
+				desc = fmt.Sprintf("(func %s (type %d))", p.names.funcRef(funcIndex), typ)
+				if !strings.HasPrefix(p.names.funcRef(funcIndex), "$") {
+					desc = fmt.Sprintf("(func (;%d;) (type %d))", funcIndex, typ)
+				}
+				funcIndex++
+			case tool.Table:
+				desc = fmt.Sprintf("(table %s %s)", limits(typ.Limits), typ.ElementType)
+			case tool.MemLimits:
+				desc = fmt.Sprintf("(memory %s)", limits(typ))
+			case tool.Global:
+				desc = fmt.Sprintf("(global %s)", globalType(typ))
+			default:
+				return fmt.Errorf("invalid import %s.%s", entry.ModuleStr, entry.FieldStr)
+			}
+			p.line("(import %s %s %s)", quote([]byte(entry.ModuleStr)), quote([]byte(entry.FieldStr)), desc)
+		}
+	}
+
+	if sec := p.module.FuncSec(); sec != nil {
+		imported := uint32(p.module.ImportedFuncs())
+		for i := range sec.Entries {
+			if err := p.printFunc(imported + uint32(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if sec := p.module.TableSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			p.line("(table (;%d;) %s %s)", i, limits(entry.Limits), entry.ElementType)
+		}
+	}
+
+	if sec := p.module.MemSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			p.line("(memory (;%d;) %s)", i, limits(entry))
+		}
+	}
+
+	if sec := p.module.GlobalSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			p.line("(global (;%d;) %s (%s))", i, globalType(entry.Type), p.instr(entry.Init))
+		}
+	}
+
+	if sec := p.module.ExportSec(); sec != nil {
+		for _, entry := range sec.Entries {
+			kind := entry.Kind
+			ref := strconv.FormatUint(uint64(entry.Index), 10)
+			if kind == "function" {
+				kind = "func"
+				ref = p.names.funcRef(entry.Index)
+			}
+			p.line("(export %s (%s %s))", quote([]byte(entry.FieldStr)), kind, ref)
+		}
+	}
+
+	if sec := p.module.StartSec(); sec != nil {
+		p.line("(start %s)", p.names.funcRef(sec.Index))
+	}
+
+	if sec := p.module.ElementSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			table := ""
+			if entry.Index != 0 {
+				table = fmt.Sprintf(" (table %d)", entry.Index)
+			}
+			refs := make([]string, 0, len(entry.Elements)+1)
+			refs = append(refs, "func")
+			for _, index := range entry.Elements {
+				refs = append(refs, p.names.funcRef(index))
+			}
+			p.line("(elem (;%d;)%s (%s) %s)", i, table, p.instr(entry.Offset), strings.Join(refs, " "))
+		}
+	}
+
+	if sec := p.module.DataSec(); sec != nil {
+		for i, entry := range sec.Entries {
+			memory := ""
+			if entry.Index != 0 {
+				memory = fmt.Sprintf(" (memory %d)", entry.Index)
+			}
+			p.line("(data (;%d;)%s (%s) %s)", i, memory, p.instr(entry.Offset), quote(entry.Data))
+		}
+	}
+
+	if err := p.printCustoms(); err != nil {
+		return err
+	}
+
+	p.depth--
+	p.line(")")
+	return nil
+}
+
+func (p *printer) printFunc(index uint32) error {
+	imported := uint32(p.module.ImportedFuncs())
+	typeIndex, ok := p.module.FuncTypeIndex(index)
+	if !ok {
+		return fmt.Errorf("function %d not found", index)
+	}
+	typ, ok := p.module.FuncType(index)
+	if !ok {
+		return fmt.Errorf("type of function %d not found", index)
+	}
+	code := p.module.CodeSec()
+	if code == nil || index-imported >= uint32(code.Len()) {
+		return fmt.Errorf("body of function %d not found", index)
+	}
+	body, err := code.Body(int(index - imported))
+	if err != nil {
+		return err
+	}
+
+	p.fn = index
+	p.results = len(typ.Returns)
+
+	header := "(func " + p.names.funcRef(index)
+	if !strings.HasPrefix(header, "(func $") {
+		header = fmt.Sprintf("(func (;%d;)", index)
+	}
+	header += fmt.Sprintf(" (type %d)", typeIndex)
+	local := uint32(0)
+	header += p.locals("param", typ.Params, &local)
+	if len(typ.Returns) > 0 {
+		header += " (result " + strings.Join(typ.Returns, " ") + ")"
+	}
+	p.line("%s", header)
+
+	p.depth++
+	var locals []string
+	for _, entry := range body.Locals {
+		for i := uint32(0); i < entry.Count; i++ {
+			locals = append(locals, entry.Type)
+		}
+	}
+	if decl := p.locals("local", locals, &local); decl != "" {
+		p.line("%s", decl[1:])
+	}
+
+	tree, err := funcTree(body.Code)
+	if err != nil {
+		return fmt.Errorf("function %d: %w", index, err)
+	}
+	if p.opts.Folded {
+		for _, n := range p.fold(tree, []int{p.results}) {
+			p.printFolded(n)
+		}
+	} else {
+		p.printFlat(tree)
+	}
+	p.depth--
+	p.line(")")
+	return nil
+}
+
+// locals returns the declaration of params or locals starting at index
+// `local`, grouping the unnamed ones.
+func (p *printer) locals(keyword string, types []string, local *uint32) string {
+	var (
+		res   string
+		group []string
+	)
+	flush := func() {
+		if len(group) > 0 {
+			res += " (" + keyword + " " + strings.Join(group, " ") + ")"
+			group = nil
+		}
+	}
+	for _, typ := range types {
+		if id, exist := p.names.locals[p.fn][*local]; exist {
+			flush()
+			res += " (" + keyword + " " + id + " " + typ + ")"
+		} else {
+			group = append(group, typ)
+		}
+		*local++
+	}
+	flush()
+	return res
+}
+
+// printCustoms prints the custom sections, but `name`, as annotations placed
+// after the known section preceding them.
+func (p *printer) printCustoms() error {
+	anchor := ""
+	for i, section := range p.module.Sections {
+		sec, ok := section.(*tool.CustomSec)
+		if !ok {
+			anchor = section.SecName()
+			continue
+		}
+		if sec.SectionName == "name" {
+			continue
+		}
+
+		placement := ""
+		if anchor != "" {
+			placement = " (after " + WAT_SECTION_NAMES[anchor] + ")"
+		} else {
+			for _, next := range p.module.Sections[i+1:] {
+				if _, isCustom := next.(*tool.CustomSec); !isCustom {
+					placement = " (before first)"
+					break
+				}
+			}
+		}
+
+		payload, err := customPayload(sec)
+		if err != nil {
+			return err
+		}
+		p.line("(@custom %s%s %s)", quote([]byte(sec.SectionName)), placement, quote(payload))
+	}
+	return nil
+}
+
+// customPayload returns the binary payload of a custom section.
+func customPayload(sec *tool.CustomSec) ([]byte, error) {
+	switch custom := sec.Custom.(type) {
+	case []byte:
+		return custom, nil
+	case string:
+		return []byte(custom), nil
+	}
+
+	stream, err := json2wasm.GenerateModuleSection(sec, nil)
+	if err != nil {
+		return nil, err
+	}
+	// skip the section id, size and name.
+	if _, err := stream.ReadByte(); err != nil {
+		return nil, err
+	}
+	if _, err := tool.DecodeULEB128(stream); err != nil {
+		return nil, err
+	}
+	nameLen, err := tool.DecodeULEB128(stream)
+	if err != nil {
+		return nil, err
+	}
+	stream.Read(int(nameLen))
+	return stream.Bytes(), nil
+}
+
+func signature(entry tool.TypeEntry) string {
+	res := ""
+	if len(entry.Params) > 0 {
+		res += " (param " + strings.Join(entry.Params, " ") + ")"
+	}
+	if len(entry.Returns) > 0 {
+		res += " (result " + strings.Join(entry.Returns, " ") + ")"
+	}
+	return res
+}
+
+func limits(l tool.MemLimits) string {
+	if max, ok := l.Maximum.(uint32); ok {
+		return fmt.Sprintf("%d %d", l.Intial, max)
+	}
+	return strconv.FormatUint(uint64(l.Intial), 10)
+}
+
+func globalType(g tool.Global) string {
+	if g.Mutability != 0 {
+		return "(mut " + g.ContentType + ")"
+	}
+	return g.ContentType
+}
+
+// quote returns `data` as a WAT string, escaping bytes that are not printable
+// ASCII.
+func quote(data []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range data {
+		if c >= 0x20 && c < 0x7f && c != '"' && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\%02x", c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}